@@ -6,8 +6,9 @@ toolchain go1.24.6
 
 require (
 	github.com/rivo/uniseg v0.4.7
+	golang.org/x/image v0.34.0
 	golang.org/x/net v0.47.0
 	golang.org/x/term v0.37.0
 )
 
-require golang.org/x/sys v0.38.0 // indirect
+require golang.org/x/sys v0.38.0
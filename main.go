@@ -3,27 +3,57 @@ package main
 
 import (
 	"bufio"
+	"context"
 	_ "embed"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"runtime/pprof"
 	"slices"
 	"strconv"
 	"strings"
 	"os"
+	"time"
 
 	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/auth"
+	"github.com/yueleshia/streamsurf/src/chat"
+	"github.com/yueleshia/streamsurf/src/server"
 	"github.com/yueleshia/streamsurf/src/tui"
 )
 
 func help() {
-		fmt.Println(`
+		fmt.Print(`
 Possible options:
 USAGE: (Use first character or full word)
 
 streamsurf follow                    - list online status of various channels
 streamsurf open <channel> [<offset>] - see latest vods
 streamsurf vods <channel> [<offset>] - see latest vods
+streamsurf vods <channel> --type all|archive|highlight|upload|premiere
+                                     - only past broadcasts, highlights, uploads or past premieres
+streamsurf queue                     - list the watch-later queue
+streamsurf queue add <channel> [<offset>]
+streamsurf queue remove <position>
+streamsurf queue move <position> <new position>
+streamsurf queue play                - play the queue in order, removing finished videos
+streamsurf chat export <vod> [--format jsonl|txt|ass] [--output <file>]
+                                     - write the comments of a VOD (URL or ID), to stdout by default
+streamsurf chat play <vod> [<offset>]
+                                     - play a VOD in mpv with its chat as subtitles
+streamsurf search <query>            - search for live streams, channels and categories
+streamsurf schedule [--ics] [<channel>...]
+                                     - upcoming streams of the followed channels, as an iCalendar file with --ics
+streamsurf serve [--listen 127.0.0.1:8420]
+                                     - keep the follow list refreshed and serve it as JSON over HTTP
+streamsurf feed                      - write an Atom feed of the latest VODs of the followed channels
+streamsurf channels                  - list the channels you follow locally
+streamsurf channels sync [--dry-run] [--mode union|mirror|review]
+                                     - add the channels followed on Twitch to the list (needs login)
+streamsurf login                     - log in to Twitch with a code shown on twitch.tv/activate
+streamsurf logout                    - revoke and forget the login
+streamsurf whoami                    - show who is logged in
 `)
 }
 
@@ -56,11 +86,37 @@ func main() {
 		cmd = os.Args[1]
 	}
 
-	UI.Load_config(CHANNELS)
+	// A synced list in the config directory takes over from the built in one
+	channels := src.Parse_channel_list(CHANNELS)
+	if list, ok, err := src.Read_channel_list(src.Must(src.Config_path("channel_list.txt"))); err != nil {
+		src.Must1(err)
+	} else if ok {
+		channels = list
+	}
+	UI.Load_config(strings.Join(channels, "\n"))
+	UI.Channel_list_path = src.Must(src.Config_path("channel_list.txt"))
+	// Set up by chat_setup, for the commands that show or fetch chat
+	defer func() {
+		if UI.Chat_logger != nil {
+			UI.Chat_logger.Close()
+		}
+	}()
 
 	switch cmd {
 	case "interactive":
+		config := read_config()
+		UI.Keymap = exit_on_error(tui.New_keymap(config.Keys))
+		UI.Categories = config.Categories
+		chat_setup(config.Chat)
+		session_setup(config.Auth)
 		src.Set_log_level(io.Discard, src.DEBUG)
+		// A broken queue file should not keep us from watching anything else
+		if queue, err := src.Load_queue(src.Must(src.Config_path("queue.json"))); err != nil {
+			// Without a path the queue is not saved, so the file is kept for the user to fix
+			_, _ = UI.Message.WriteString(err.Error() + ", the queue is not saved this session\n")
+		} else {
+			UI.Queue = queue
+		}
 		UI.Interactive()
 
 	case "o": fallthrough
@@ -76,7 +132,7 @@ func main() {
 			return
 		}
 
-		sync_refresh(channel)
+		sync_refresh("", channel)
 
 		cur := src.Video{}
 		buffer_length := len(UI.Cache.Buffer)
@@ -91,7 +147,7 @@ func main() {
 
 	case "f": fallthrough
 	case "follow":
-		sync_refresh(UI.Channel_list...)
+		sync_refresh("", UI.Channel_list...)
 
 		// @VOLATILE: Load_config seeds the keys
 		var idx uint = 0
@@ -122,47 +178,638 @@ func main() {
 
 	case "v": fallthrough
 	case "vods":
-		if len(os.Args) < 3 {
+		var channel, broadcast_type string
+		for i := 2; i < len(os.Args); i += 1 {
+			switch os.Args[i] {
+			case "--type":
+				if i + 1 >= len(os.Args) {
+					fmt.Fprintf(os.Stderr, "%s needs a value\n", os.Args[i])
+					os.Exit(1)
+				}
+				ty, err := src.Parse_broadcast_type(os.Args[i + 1])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s\n", err)
+					os.Exit(1)
+				}
+				broadcast_type = ty
+				i += 1
+			default:
+				channel = os.Args[i]
+			}
+		}
+		if channel == "" {
 			fmt.Fprintf(os.Stderr, "Please specify a channel to query the VODs for")
 			os.Exit(1)
 		}
-		channel := os.Args[2]
 
-		sync_refresh(channel)
-		if pair, ok := UI.Follow_latest[channel]; ok && pair.Live.Duration > 0 {
-			UI.Cache.Push(pair.Live)
+		vid, err := choose_vod(channel, broadcast_type)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return
 		}
-		slices.SortFunc(UI.Cache.Buffer[UI.Cache.Start:UI.Cache.Close], src.Sort_videos_by_latest)
+		play(vid)
 
-		buffer_length := len(UI.Cache.Buffer)
-		ring_length := UI.Cache.Close - UI.Cache.Start
-		choice, err := basic_menu(
-			fmt.Sprintf("VODs for %s\n", channel),
-			ring_length,
-			"Enter a Video: ",
-			func (out io.Writer, idx int) {
-				reversed_idx := ring_length - ((UI.Cache.Close - idx) % buffer_length)
-				vid := UI.Cache.Buffer[reversed_idx]
-				tui.Print_formatted_line(out, " | ", vid)
-			},
-		)
+	case "q": fallthrough
+	case "queue":
+		UI.Queue = src.Must(src.Load_queue(src.Must(src.Config_path("queue.json"))))
+		queue_command(os.Args[2:])
+
+	case "c": fallthrough
+	case "chat":
+		chat_setup(read_config().Chat)
+		chat_command(os.Args[2:])
+
+	case "s": fallthrough
+	case "search":
+		query := strings.Join(os.Args[2:], " ")
+		if strings.TrimSpace(query) == "" {
+			fmt.Fprintf(os.Stderr, "Please specify what to search for\n")
+			os.Exit(1)
+		}
+		results, err := src.Search(context.Background(), query)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
-			return
+			os.Exit(1)
 		}
+		search_print(results)
 
-		vid := UI.Cache.Buffer[(UI.Cache.Close - choice - 1) % buffer_length]
-		play(vid)
+	case "schedule":
+		schedule_command(os.Args[2:])
+
+	case "serve":
+		serve_command(os.Args[2:], read_config().Serve)
+
+	case "feed":
+		if len(os.Args) > 2 {
+			fmt.Fprintf(os.Stderr, "Unknown option %q, use 'serve' for the feed over HTTP\n", os.Args[2])
+			os.Exit(1)
+		}
+		sync_refresh("", UI.Channel_list...)
+		writer := bufio.NewWriter(os.Stdout)
+		if err := src.Write_atom(writer, UI.Cache.As_slice(), ""); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		src.Must1(writer.Flush())
+
+	case "channels":
+		channels_command(os.Args[2:], channels, read_config())
+
+	case "login":
+		session := session_setup(read_config().Auth)
+		token, err := session.Login(context.Background(), func(code auth.DeviceCode) {
+			fmt.Fprintf(os.Stderr, "Open %s and enter the code %s\n", code.Verification_uri, code.User_code)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Logged in as %s\n", token.Login)
+
+	case "logout":
+		session := session_setup(read_config().Auth)
+		if err := session.Logout(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Logged out\n")
+
+	case "whoami":
+		session := session_setup(read_config().Auth)
+		token, err := session.Token(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s (user ID %s)\n", token.Login, token.User_id)
+		fmt.Printf("Scopes: %s\n", strings.Join(token.Scopes, " "))
+		fmt.Printf("Expires: %s\n", token.Expires_at.Local().Format(time.DateTime))
 
 	default:
 		fmt.Fprintf(os.Stderr, "Unsupported command %q\n", cmd)
 	}
 }
 
-func sync_refresh(channels ...string) {
+// Setup errors only stop the commands that need that part of the config, and
+// are reported like any other error
+func exit_on_error[T any](x T, err error) T {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	return x
+}
+
+func read_config() src.Config {
+	path := exit_on_error(src.Config_path("config.json"))
+	config, err := src.Read_config(path)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", path, err)
+	}
+	return exit_on_error(config, err)
+}
+
+// Highlight rules, scrollback, subtitles and the chat logs
+func chat_setup(config src.ChatConfig) {
+	UI.Chat_rules = exit_on_error(chat.Compile_rules(config.Highlight, config.Hide))
+	UI.Chat.Capacity = config.Scrollback
+	UI.Chat_subtitles = chat.Ass_options(config)
+	UI.Chat_timeout = time.Duration(config.Timeout_seconds) * time.Second
+	if config.Log {
+		UI.Chat_logger = exit_on_error(chat_logger(config))
+	}
+}
+
+// Loads the login from the token store on first use
+func session_setup(config src.AuthConfig) *auth.Session {
+	session := auth.New_session(auth.New_client(config), exit_on_error(auth.New_store(config)))
+	src.Authorizer = session.Access_token
+	UI.Session = session
+	UI.Moderator = chat.Helix_moderator{
+		Client_id: session.Client.Client_id,
+		Moderator_id: func(ctx context.Context) (string, error) {
+			token, err := session.Token(ctx)
+			return token.User_id, err
+		},
+	}
+	return session
+}
+
+// broadcast_type is one of the src.Broadcast* constants, or empty for all
+func choose_vod(channel string, broadcast_type string) (src.Video, error) {
+	sync_refresh(broadcast_type, channel)
+	if pair, ok := UI.Follow_latest[channel]; ok && pair.Live.Duration > 0 && (broadcast_type == "" || broadcast_type == src.BroadcastArchive) {
+		UI.Cache.Push(pair.Live)
+	}
+	slices.SortFunc(UI.Cache.Buffer[UI.Cache.Start:UI.Cache.Close], src.Sort_videos_by_latest)
+
+	buffer_length := len(UI.Cache.Buffer)
+	ring_length := UI.Cache.Close - UI.Cache.Start
+	choice, err := basic_menu(
+		fmt.Sprintf("VODs for %s\n", channel),
+		ring_length,
+		"Enter a Video: ",
+		func (out io.Writer, idx int) {
+			reversed_idx := ring_length - ((UI.Cache.Close - idx) % buffer_length)
+			vid := UI.Cache.Buffer[reversed_idx]
+			tui.Print_formatted_line(out, " | ", vid)
+		},
+	)
+	if err != nil {
+		return src.Video{}, err
+	}
+	return UI.Cache.Buffer[(UI.Cache.Close - choice - 1) % buffer_length], nil
+}
+
+func queue_command(args []string) {
+	var sub string
+	if len(args) > 0 {
+		sub = args[0]
+	}
+
+	position := func(s string) int {
+		x, err := strconv.Atoi(s)
+		if err != nil || x < 1 || x > len(UI.Queue.Items) {
+			fmt.Fprintf(os.Stderr, "%q is not a position in the queue\n", s)
+			os.Exit(1)
+		}
+		return x - 1
+	}
+
+	switch sub {
+	case "":
+		if len(UI.Queue.Items) == 0 {
+			fmt.Println("The queue is empty")
+		}
+		for i, item := range UI.Queue.Items {
+			offset := item.Offset
+			if offset == "" {
+				offset = "-"
+			}
+			fmt.Printf("%3d | %-8s | ", i + 1, offset)
+			tui.Print_formatted_line(os.Stdout, " | ", item.Video)
+		}
+		return
+
+	case "a": fallthrough
+	case "add":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Please specify a channel to queue a VOD from\n")
+			os.Exit(1)
+		}
+		var offset string
+		if len(args) >= 3 {
+			offset = args[2]
+		}
+		vid, err := choose_vod(args[1], "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		UI.Queue.Add(vid, offset)
+
+	case "r": fallthrough
+	case "remove":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Please specify the position to remove\n")
+			os.Exit(1)
+		}
+		UI.Queue.Remove(position(args[1]))
+
+	case "m": fallthrough
+	case "move":
+		if len(args) < 3 {
+			fmt.Fprintf(os.Stderr, "Please specify the position to move and its new position\n")
+			os.Exit(1)
+		}
+		from, to := position(args[1]), position(args[2])
+		for from != to {
+			if from < to {
+				from = UI.Queue.Move(from, 1)
+			} else {
+				from = UI.Queue.Move(from, -1)
+			}
+		}
+
+	case "p": fallthrough
+	case "play":
+		for len(UI.Queue.Items) > 0 {
+			item := UI.Queue.Items[0]
+			tui.Print_formatted_line(os.Stderr, " | ", item.Video)
+			if err := src.Run(nil, os.Stdout, "streamlink", src.Streamlink_args(item.Video, item.Offset)...); err != nil {
+				fmt.Fprintf(os.Stderr, "Stopping the queue: %s\n", err)
+				return
+			}
+			UI.Queue.Remove(0)
+			src.Must1(UI.Queue.Save())
+		}
+		return
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported queue command %q\n", sub)
+		os.Exit(1)
+	}
+	src.Must1(UI.Queue.Save())
+}
+
+func chat_logger(config src.ChatConfig) (*chat.Logger, error) {
+	dir := config.Log_dir
+	if dir == "" {
+		if x, err := src.Config_path("logs"); err != nil {
+			return nil, err
+		} else {
+			dir = x
+		}
+	}
+	logger := chat.New_logger(dir)
+	if config.Log_max_mb > 0 {
+		logger.Max_bytes = int64(config.Log_max_mb) * 1024 * 1024
+	}
+	logger.Keep_days = config.Log_keep_days
+	return logger, logger.Prune(time.Now())
+}
+
+func chat_command(args []string) {
+	var sub string
+	if len(args) > 0 {
+		sub = args[0]
+	}
+
+	switch sub {
+	case "e": fallthrough
+	case "export":
+		var vod string
+		format := chat.FormatTxt
+		output := "-"
+		for i := 1; i < len(args); i += 1 {
+			switch args[i] {
+			case "--format", "--output":
+				if i + 1 >= len(args) {
+					fmt.Fprintf(os.Stderr, "%s needs a value\n", args[i])
+					os.Exit(1)
+				}
+				if args[i] == "--format" {
+					format = args[i + 1]
+				} else {
+					output = args[i + 1]
+				}
+				i += 1
+			default:
+				vod = args[i]
+			}
+		}
+
+		video_id, err := chat.Video_id(vod)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		var writer io.Writer = os.Stdout
+		if output != "-" {
+			fh := src.Must(os.Create(output))
+			defer fh.Close()
+			writer = fh
+		}
+		buffered := bufio.NewWriter(writer)
+		exporter, err := chat.New_exporter(buffered, format, UI.Chat_subtitles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+		count := 0
+		err = chat.Fetch_comments(context.Background(), video_id, func(messages []chat.Message) error {
+			for _, msg := range messages {
+				if err := exporter.Write(msg); err != nil {
+					return err
+				}
+				if UI.Chat_logger != nil {
+					if err := UI.Chat_logger.Write(msg); err != nil {
+						return err
+					}
+				}
+			}
+			count += len(messages)
+			fmt.Fprintf(os.Stderr, "\rFetched %d comments", count)
+			return nil
+		})
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		src.Must1(exporter.Close())
+		src.Must1(buffered.Flush())
+
+	case "p": fallthrough
+	case "play":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Please specify a VOD to play\n")
+			os.Exit(1)
+		}
+		video_id, err := chat.Video_id(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		var offset string
+		if len(args) >= 3 {
+			offset = args[2]
+		}
+
+		count := 0
+		path, err := chat.Generate_subtitles(context.Background(), video_id, UI.Chat_subtitles, func(messages []chat.Message) error {
+			if UI.Chat_logger != nil {
+				for _, msg := range messages {
+					if err := UI.Chat_logger.Write(msg); err != nil {
+						return err
+					}
+				}
+			}
+			count += len(messages)
+			fmt.Fprintf(os.Stderr, "\rFetched %d comments", count)
+			return nil
+		})
+		if count > 0 {
+			fmt.Fprintln(os.Stderr)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		vid := src.Video{ Url: "https://www.twitch.tv/videos/" + video_id }
+		src.Must1(src.Run(nil, os.Stdout, "streamlink", src.Streamlink_args_with_subtitles(vid, offset, path)...))
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported chat command %q\n", sub)
+		os.Exit(1)
+	}
+}
+
+func channels_command(args []string, channels []string, config src.Config) {
+	var sub string
+	if len(args) > 0 {
+		sub = args[0]
+	}
+
+	switch sub {
+	case "":
+		for _, channel := range channels {
+			fmt.Println(channel)
+		}
+
+	case "s": fallthrough
+	case "sync":
+		mode := config.Follows.Sync_mode
+		if mode == "" {
+			mode = src.SyncUnion
+		}
+		is_dry_run := false
+		for i := 1; i < len(args); i += 1 {
+			switch args[i] {
+			case "--dry-run":
+				is_dry_run = true
+			case "--mode":
+				if i + 1 >= len(args) {
+					fmt.Fprintf(os.Stderr, "%s needs a value\n", args[i])
+					os.Exit(1)
+				}
+				mode = args[i + 1]
+				i += 1
+			default:
+				fmt.Fprintf(os.Stderr, "Unknown option %q\n", args[i])
+				os.Exit(1)
+			}
+		}
+
+		session := session_setup(config.Auth)
+		token, err := session.Token(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		follows, err := src.Fetch_follows(context.Background(), session.Client.Client_id, token.User_id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+		// The follows as of the last sync, to tell unfollows from local-only channels
+		follows_path := src.Must(src.Config_path("follows.txt"))
+		previous, _, err := src.Read_channel_list(follows_path)
+		src.Must1(err)
+
+		stdin := bufio.NewReader(os.Stdin)
+		review := func(channel string, is_add bool) bool {
+			if is_dry_run {
+				return true
+			}
+			if is_add {
+				fmt.Fprintf(os.Stderr, "Add %s? [y/N] ", channel)
+			} else {
+				fmt.Fprintf(os.Stderr, "Remove %s (unfollowed on Twitch)? [y/N] ", channel)
+			}
+			input, _ := stdin.ReadString('\n')
+			return strings.EqualFold(strings.TrimSpace(input), "y")
+		}
+		list, diff, err := src.Sync_follows(channels, follows, previous, mode, review)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+
+		for _, channel := range diff.Add {
+			fmt.Printf("%s+ %s%s\n", src.ANSI_FG_GREEN, channel, src.ANSI_RESET)
+		}
+		for _, channel := range diff.Remove {
+			fmt.Printf("%s- %s%s\n", src.ANSI_FG_RED, channel, src.ANSI_RESET)
+		}
+		if is_dry_run {
+			fmt.Fprintf(os.Stderr, "Dry run, %d to add and %d to remove\n", len(diff.Add), len(diff.Remove))
+			return
+		}
+		if err := src.Check_channel_limit(len(list)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s, try --mode review\n", err)
+			os.Exit(1)
+		}
+		src.Must1(src.Write_channel_list(src.Must(src.Config_path("channel_list.txt")), list))
+		src.Must1(src.Write_channel_list(follows_path, follows))
+		fmt.Fprintf(os.Stderr, "Added %d and removed %d channels, now following %d\n", len(diff.Add), len(diff.Remove), len(list))
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported channels command %q\n", sub)
+		os.Exit(1)
+	}
+}
+
+func serve_command(args []string, config src.ServeConfig) {
+	listen := config.Listen
+	if listen == "" {
+		listen = server.DEFAULT_LISTEN
+	}
+	for i := 0; i < len(args); i += 1 {
+		switch args[i] {
+		case "--listen":
+			if i + 1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "%s needs a value\n", args[i])
+				os.Exit(1)
+			}
+			listen = args[i + 1]
+			i += 1
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown option %q\n", args[i])
+			os.Exit(1)
+		}
+	}
+
+	srv := server.New_server(&UI, time.Duration(config.Refresh_seconds) * time.Second)
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Serving the follow list on http://%s\n", listener.Addr())
+	go srv.Run(context.Background())
+	if err := http.Serve(listener, srv.Handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// Every followed channel unless some are given
+func schedule_command(args []string) {
+	is_ics := false
+	var channels []string
+	for _, arg := range args {
+		switch arg {
+		case "--ics":
+			is_ics = true
+		default:
+			if strings.HasPrefix(arg, "--") {
+				fmt.Fprintf(os.Stderr, "Unknown option %q\n", arg)
+				os.Exit(1)
+			}
+			channels = append(channels, arg)
+		}
+	}
+	if len(channels) == 0 {
+		channels = UI.Channel_list
+	}
+
+	queue := make(chan tui.ScheduleReply, len(channels))
+	tui.Refresh_schedules(queue, channels...)
+	var segments []src.ScheduleSegment
+	for range channels {
+		if reply := <-queue; reply.Err != nil {
+			fmt.Fprintln(os.Stderr, reply.Err.Error())
+		} else {
+			segments = append(segments, reply.Segments...)
+		}
+	}
+	// Replies arrive in any order, keep the output stable for calendar diffs
+	slices.SortStableFunc(segments, func(a, b src.ScheduleSegment) int {
+		if x := a.Start_time.Compare(b.Start_time); x != 0 {
+			return x
+		}
+		return strings.Compare(a.Channel, b.Channel)
+	})
+
+	if is_ics {
+		if err := src.Write_ics(os.Stdout, segments, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(segments) == 0 {
+		fmt.Fprintln(os.Stderr, "No scheduled streams")
+	}
+	for _, x := range segments {
+		var end, cancelled string
+		if !x.End_time.IsZero() {
+			end = x.End_time.Local().Format("-15:04")
+		}
+		if x.Is_cancelled {
+			cancelled = " (cancelled)"
+		}
+		fmt.Printf("%-26s | %-16s | %s | %s%s\n", x.Start_time.Local().Format("Mon 2006-01-02 15:04") + end, x.Channel, x.Category, x.Title, cancelled)
+	}
+}
+
+func search_print(results src.SearchResults) {
+	if len(results.Live) > 0 {
+		fmt.Println("Live")
+		for _, vid := range results.Live {
+			tui.Print_formatted_line(os.Stdout, " | ", vid)
+		}
+	}
+	if len(results.Channels) > 0 {
+		fmt.Println("Channels")
+		for _, x := range results.Channels {
+			var live string
+			if x.Is_live {
+				live = fmt.Sprintf(" (live, %d viewers)", x.Viewers)
+			}
+			fmt.Printf("%-25s %9d followers%s\n", x.Login, x.Followers, live)
+		}
+	}
+	if len(results.Categories) > 0 {
+		fmt.Println("Categories")
+		for _, x := range results.Categories {
+			fmt.Printf("%-40s %9d viewers\n", x.Display_name, x.Viewers)
+		}
+	}
+	if len(results.Live) + len(results.Channels) + len(results.Categories) == 0 {
+		fmt.Fprintln(os.Stderr, "No results")
+	}
+}
+
+func sync_refresh(broadcast_type string, channels ...string) {
 	job_count := len(channels) * tui.PACKETS_PER_REFRESH
 	vid_chan := make(chan src.VideoPacket, job_count)
-	tui.Refresh_channels(vid_chan, channels...)
+	for _, channel := range channels {
+		tui.Refresh_channel_type(vid_chan, channel, broadcast_type)
+	}
 	for i := 0; i < job_count; i += 1 {
 		if packet := <-vid_chan; packet.Err != nil {
 			fmt.Fprintln(os.Stderr, packet.Err.Error())
@@ -184,7 +831,7 @@ func play(vid src.Video) {
 	//src.Must1(src.Run(nil, os.Stdout, "streamlink", "https://www.twitch.tv/" + vid.Channel))
 	var start_time string
 	if input, err := stdin.ReadString('\n'); err != nil {
-		fmt.Fprint(os.Stderr, "%s\n", err)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 	} else {
 		start_time = input[:len(input) - len("\n")]
 	}
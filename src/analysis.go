@@ -0,0 +1,182 @@
+package src
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Guessing when a channel usually streams from the start times and durations
+// of its past broadcasts, for the many channels without a schedule
+
+const HOURS_PER_WEEK = 7 * 24
+
+// Share of weeks an hour has to be live in to count as a usual time
+const ACTIVITY_THRESHOLD = 0.5
+
+// Less history than this lowers the confidence, one week says little
+const ACTIVITY_FULL_WEEKS = 4
+
+// A weekly histogram of when a channel was live
+type Activity struct {
+	Hours [HOURS_PER_WEEK]int // Weeks live in each hour of the week, Sunday 00:00 first
+	Weeks int // Weeks of history that Hours counts over, 0 without any
+}
+
+// e.g. Tue/Thu 18:00–22:00
+type LiveWindow struct {
+	Days       []time.Weekday // Days the window starts on
+	Start      int // Hour of the day
+	Hours      int
+	Confidence float64 // 0 to 1
+}
+
+// Only past broadcasts and live streams say when a channel is live, uploads
+// and highlights are published whenever. An hour counts if the stream covered
+// its middle, so a stream from 18:50 to 22:10 is live 19:00–22:00.
+func Build_activity(videos []Video, now time.Time, loc *time.Location) Activity {
+	var ret Activity
+	seen := make(map[int64]bool) // Streams overlap when the cache has the live stream and its VOD
+	oldest := now
+	for _, vid := range videos {
+		if vid.Broadcast_type != "" && vid.Broadcast_type != BroadcastArchive {
+			continue
+		}
+		if vid.Start_time.IsZero() || vid.Duration <= 0 {
+			continue
+		}
+		if vid.Start_time.Before(oldest) {
+			oldest = vid.Start_time
+		}
+		end := vid.Start_time.Add(vid.Duration)
+		for t := vid.Start_time.Truncate(time.Hour); t.Before(end); t = t.Add(time.Hour) {
+			middle := t.Add(30 * time.Minute)
+			if middle.Before(vid.Start_time) || !middle.Before(end) || seen[t.Unix()] {
+				continue
+			}
+			seen[t.Unix()] = true
+			local := t.In(loc)
+			ret.Hours[int(local.Weekday()) * 24 + local.Hour()] += 1
+		}
+	}
+	if len(seen) > 0 {
+		ret.Weeks = max(int(math.Ceil(now.Sub(oldest).Hours() / HOURS_PER_WEEK)), 1)
+	}
+	return ret
+}
+
+func (self Activity) share(hour_of_week int) float64 {
+	return float64(self.Hours[hour_of_week % HOURS_PER_WEEK]) / float64(self.Weeks)
+}
+
+// Runs of usually live hours, with the days that have the same run merged.
+// Most confident first.
+func (self Activity) Windows() []LiveWindow {
+	if self.Weeks == 0 {
+		return nil
+	}
+	is_usual := func(i int) bool { return self.share(i) >= ACTIVITY_THRESHOLD }
+
+	// Start after a quiet hour so that a run over midnight is not split in two
+	first := -1
+	for i := 0; i < HOURS_PER_WEEK; i += 1 {
+		if !is_usual(i) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil // Live around the clock
+	}
+
+	type Run struct {
+		Start int // Hour of the week
+		Hours int
+		Share float64
+	}
+	var runs []Run
+	for i := first + 1; i < first + HOURS_PER_WEEK; i += 1 {
+		if !is_usual(i) {
+			continue
+		}
+		if is_usual(i - 1) {
+			runs[len(runs) - 1].Hours += 1
+			runs[len(runs) - 1].Share += self.share(i)
+		} else {
+			runs = append(runs, Run{ i % HOURS_PER_WEEK, 1, self.share(i) })
+		}
+	}
+
+	var ret []LiveWindow
+	var shares, hours []float64
+	for _, run := range runs {
+		day, start := time.Weekday(run.Start / 24), run.Start % 24
+		idx := slices.IndexFunc(ret, func(x LiveWindow) bool { return x.Start == start && x.Hours == run.Hours })
+		if idx < 0 {
+			ret = append(ret, LiveWindow{ Start: start, Hours: run.Hours })
+			shares, hours = append(shares, 0), append(hours, 0)
+			idx = len(ret) - 1
+		}
+		ret[idx].Days = append(ret[idx].Days, day)
+		shares[idx] += run.Share
+		hours[idx] += float64(run.Hours)
+	}
+	history := min(float64(self.Weeks) / ACTIVITY_FULL_WEEKS, 1)
+	for i := range ret {
+		slices.Sort(ret[i].Days)
+		ret[i].Confidence = min(shares[i] / hours[i], 1) * history
+	}
+	slices.SortStableFunc(ret, func(a, b LiveWindow) int {
+		if a.Confidence != b.Confidence {
+			return cmp.Compare(b.Confidence, a.Confidence)
+		}
+		return int(a.Days[0]) * 24 + a.Start - int(b.Days[0]) * 24 - b.Start
+	})
+	return ret
+}
+
+// The start of the next window, or of the current one if we are in it
+func (self Activity) Next_window(now time.Time, loc *time.Location) (time.Time, LiveWindow, bool) {
+	var best time.Time
+	var best_window LiveWindow
+	local := now.In(loc)
+	for _, window := range self.Windows() {
+		for _, day := range window.Days {
+			this_week := time.Date(local.Year(), local.Month(), local.Day() - int(local.Weekday()) + int(day), window.Start, 0, 0, 0, loc)
+			for _, start := range []time.Time{this_week.AddDate(0, 0, -7), this_week, this_week.AddDate(0, 0, 7)} {
+				if !start.Add(time.Duration(window.Hours) * time.Hour).After(now) {
+					continue
+				}
+				if best.IsZero() || start.Before(best) {
+					best, best_window = start, window
+				}
+				break
+			}
+		}
+	}
+	return best, best_window, !best.IsZero()
+}
+
+func (self LiveWindow) String() string {
+	days := make([]string, len(self.Days))
+	for i, day := range self.Days {
+		days[i] = day.String()[:3]
+	}
+	return fmt.Sprintf("%s %02d:00–%02d:00", strings.Join(days, "/"), self.Start, (self.Start + self.Hours) % 24)
+}
+
+// e.g. "usually live Tue/Thu 18:00–22:00 (80%)", empty if there is no pattern
+func (self Activity) Summary() string {
+	windows := self.Windows()
+	if len(windows) == 0 {
+		return ""
+	}
+	var parts []string
+	for _, x := range windows[:min(len(windows), 2)] {
+		parts = append(parts, fmt.Sprintf("%s (%.0f%%)", x, x.Confidence * 100))
+	}
+	return "usually live " + strings.Join(parts, ", ")
+}
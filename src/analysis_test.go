@@ -0,0 +1,70 @@
+package src
+
+import (
+	"testing"
+	"time"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run Activity
+
+func TestActivity(t *testing.T) {
+	// A Monday
+	now := time.Date(2024, 5, 27, 12, 0, 0, 0, time.UTC)
+	stream := func(days_ago int, hour int, minute int, length time.Duration) Video {
+		start := time.Date(2024, 5, 27 - days_ago, hour, minute, 0, 0, time.UTC)
+		return Video{ Url: start.String(), Start_time: start, Duration: length, Broadcast_type: BroadcastArchive }
+	}
+	var videos []Video
+	for week := 0; week < 4; week += 1 {
+		// Tuesday and Thursday evenings, a few minutes late
+		videos = append(videos, stream(week * 7 + 6, 17, 55, 4 * time.Hour + 10 * time.Minute))
+		videos = append(videos, stream(week * 7 + 4, 18, 5, 4 * time.Hour))
+		// Friday nights over midnight, three weeks out of four
+		if week != 0 {
+			videos = append(videos, stream(week * 7 + 3, 22, 0, 4 * time.Hour))
+		}
+	}
+	// Once is not a habit
+	videos = append(videos, stream(2, 10, 0, 3 * time.Hour))
+	highlight := stream(1, 9, 0, 2 * time.Hour)
+	highlight.Broadcast_type = BroadcastHighlight
+	videos = append(videos, highlight)
+
+	activity := Build_activity(videos, now, time.UTC)
+	a.AssertEqual(t, 4, activity.Weeks)
+	a.AssertEqual(t, 4, activity.Hours[int(time.Tuesday) * 24 + 18])
+	a.AssertEqual(t, 0, activity.Hours[int(time.Sunday) * 24 + 9])
+
+	windows := activity.Windows()
+	a.AssertEqual(t, []LiveWindow{
+		{ Days: []time.Weekday{time.Tuesday, time.Thursday}, Start: 18, Hours: 4, Confidence: 1 },
+		{ Days: []time.Weekday{time.Friday}, Start: 22, Hours: 4, Confidence: 0.75 },
+	}, windows)
+	a.AssertEqual(t, "Fri 22:00–02:00", windows[1].String())
+	a.AssertEqual(t, "usually live Tue/Thu 18:00–22:00 (100%), Fri 22:00–02:00 (75%)", activity.Summary())
+
+	start, window, ok := activity.Next_window(now, time.UTC)
+	a.AssertEqual(t, true, ok)
+	a.AssertEqual(t, time.Date(2024, 5, 28, 18, 0, 0, 0, time.UTC), start)
+	a.AssertEqual(t, 18, window.Start)
+
+	// In the middle of a window it is the current one
+	start, _, _ = activity.Next_window(time.Date(2024, 5, 28, 20, 0, 0, 0, time.UTC), time.UTC)
+	a.AssertEqual(t, time.Date(2024, 5, 28, 18, 0, 0, 0, time.UTC), start)
+	// Saturday 01:00 is still in Friday's window
+	start, _, _ = activity.Next_window(time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC), time.UTC)
+	a.AssertEqual(t, time.Date(2024, 5, 31, 22, 0, 0, 0, time.UTC), start)
+}
+
+func TestActivityShortHistory(t *testing.T) {
+	now := time.Date(2024, 5, 27, 12, 0, 0, 0, time.UTC)
+	a.AssertEqual(t, 0, len(Build_activity(nil, now, time.UTC).Windows()))
+
+	// One stream a few days ago is a pattern, but not a confident one
+	start := time.Date(2024, 5, 25, 18, 0, 0, 0, time.UTC)
+	activity := Build_activity([]Video{{ Start_time: start, Duration: 2 * time.Hour }}, now, time.UTC)
+	a.AssertEqual(t, 1, activity.Weeks)
+	a.AssertEqual(t, "usually live Sat 18:00–20:00 (25%)", activity.Summary())
+}
@@ -0,0 +1,321 @@
+// Logging in to Twitch with the OAuth device authorization grant
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+//run: go test
+
+// https://dev.twitch.tv/docs/authentication/getting-tokens-oauth/#device-code-grant-flow
+const ID_URL = "https://id.twitch.tv"
+
+// Reading chat and follows, sending chat, and moderating
+var DEFAULT_SCOPES = []string{
+	"chat:read",
+	"chat:edit",
+	"user:read:follows",
+	"moderator:manage:banned_users",
+	"moderator:manage:chat_messages",
+}
+
+// Tokens are refreshed this long before they expire
+const REFRESH_MARGIN = 5 * time.Minute
+
+// Twitch requires apps to validate tokens at least hourly
+// https://dev.twitch.tv/docs/authentication/validate-tokens/
+const VALIDATE_INTERVAL = time.Hour
+
+type Client struct {
+	Base      string // ID_URL, or a stand-in server in tests
+	Client_id string
+	Scopes    []string
+	Http      *http.Client
+}
+
+func New_client(config src.AuthConfig) *Client {
+	ret := &Client{ Base: ID_URL, Client_id: config.Client_id, Scopes: config.Scopes, Http: &http.Client{ Timeout: 30 * time.Second } }
+	if ret.Client_id == "" {
+		ret.Client_id = src.CLIENT_ID
+	}
+	if len(ret.Scopes) == 0 {
+		ret.Scopes = DEFAULT_SCOPES
+	}
+	return ret
+}
+
+// What the user has to do to finish logging in
+type DeviceCode struct {
+	Device_code      string `json:"device_code"`
+	User_code        string `json:"user_code"`
+	Verification_uri string `json:"verification_uri"`
+	Expires_in       int    `json:"expires_in"` // Seconds
+	Interval         int    `json:"interval"` // Seconds between polls
+}
+
+// Twitch reports errors as {"status": 400, "message": "authorization_pending"}
+type ErrOAuth struct {
+	Status  int
+	Message string
+}
+
+func (e ErrOAuth) Error() string {
+	return fmt.Sprintf("Twitch login: HTTP %d %s", e.Status, e.Message)
+}
+
+func (self *Client) Start_device(ctx context.Context) (DeviceCode, error) {
+	var ret DeviceCode
+	err := self.post(ctx, "/oauth2/device", url.Values{
+		"client_id": {self.Client_id},
+		"scopes": {strings.Join(self.Scopes, " ")},
+	}, &ret)
+	return ret, err
+}
+
+type token_response struct {
+	Access_token  string   `json:"access_token"`
+	Refresh_token string   `json:"refresh_token"`
+	Expires_in    int      `json:"expires_in"`
+	Scope         []string `json:"scope"`
+}
+
+func (self token_response) token(now time.Time) Token {
+	return Token{
+		Access_token: self.Access_token,
+		Refresh_token: self.Refresh_token,
+		Scopes: self.Scope,
+		Expires_at: now.Add(time.Duration(self.Expires_in) * time.Second),
+	}
+}
+
+// Polls until the user has entered the code, the code expires or ctx is done
+func (self *Client) Poll(ctx context.Context, code DeviceCode) (Token, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(code.Expires_in) * time.Second)
+	for {
+		var resp token_response
+		err := self.post(ctx, "/oauth2/token", url.Values{
+			"client_id": {self.Client_id},
+			"scopes": {strings.Join(self.Scopes, " ")},
+			"device_code": {code.Device_code},
+			"grant_type": {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &resp)
+		if err == nil {
+			return resp.token(time.Now()), nil
+		}
+		if x, ok := err.(ErrOAuth); !ok {
+			return Token{}, err
+		} else if x.Message == "slow_down" {
+			interval += 5 * time.Second
+		} else if x.Message != "authorization_pending" {
+			return Token{}, err
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return Token{}, fmt.Errorf("The login code expired, please try again")
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return Token{}, ctx.Err()
+		}
+	}
+}
+
+// Refresh tokens can only be used once, the returned token has a new one
+func (self *Client) Refresh(ctx context.Context, token Token) (Token, error) {
+	var resp token_response
+	err := self.post(ctx, "/oauth2/token", url.Values{
+		"client_id": {self.Client_id},
+		"grant_type": {"refresh_token"},
+		"refresh_token": {token.Refresh_token},
+	}, &resp)
+	if err != nil {
+		return token, err
+	}
+	ret := resp.token(time.Now())
+	ret.Login, ret.User_id, ret.Validated_at = token.Login, token.User_id, token.Validated_at
+	return ret, nil
+}
+
+type Validation struct {
+	Client_id  string   `json:"client_id"`
+	Login      string   `json:"login"`
+	User_id    string   `json:"user_id"`
+	Scopes     []string `json:"scopes"`
+	Expires_in int      `json:"expires_in"`
+}
+
+// Errors with a 401 ErrOAuth if the token was revoked or has expired
+func (self *Client) Validate(ctx context.Context, access_token string) (Validation, error) {
+	var ret Validation
+	req, err := http.NewRequestWithContext(ctx, "GET", self.Base + "/oauth2/validate", nil)
+	if err != nil {
+		return ret, err
+	}
+	req.Header.Set("Authorization", "OAuth " + access_token)
+	err = self.do(req, &ret)
+	return ret, err
+}
+
+func (self *Client) Revoke(ctx context.Context, access_token string) error {
+	return self.post(ctx, "/oauth2/revoke", url.Values{
+		"client_id": {self.Client_id},
+		"token": {access_token},
+	}, nil)
+}
+
+func (self *Client) post(ctx context.Context, path string, form url.Values, output any) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", self.Base + path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return self.do(req, output)
+}
+
+// Not src.Request, tokens should never end up in the local shim files
+func (self *Client) do(req *http.Request, output any) error {
+	src.L_DEBUG.Printf("%s %q", req.Method, req.URL.Path)
+	resp, err := self.Http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		var x struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &x) != nil || x.Message == "" {
+			x.Message = strings.TrimSpace(string(data))
+		}
+		return ErrOAuth{ resp.StatusCode, x.Message }
+	}
+	if output == nil {
+		return nil
+	}
+	return json.Unmarshal(data, output)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Session
+
+// Hands out a valid access token, refreshing and validating it as needed
+// Access_token is meant to be used as src.Authorizer
+type Session struct {
+	Client *Client
+	Store  Store
+	Now    func() time.Time
+
+	mutex sync.Mutex
+	token *Token
+}
+
+func New_session(client *Client, store Store) *Session {
+	return &Session{ Client: client, Store: store, Now: time.Now }
+}
+
+// Returns ErrNoToken when logged out
+func (self *Session) Token(ctx context.Context) (Token, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.token == nil {
+		token, err := self.Store.Load()
+		if err != nil {
+			return token, err
+		}
+		self.token = &token
+	}
+	token := *self.token
+	now := self.Now()
+
+	changed := false
+	if now.Add(REFRESH_MARGIN).After(token.Expires_at) {
+		if x, err := self.Client.Refresh(ctx, token); err != nil {
+			return token, fmt.Errorf("Could not refresh the login, please log in again: %w", err)
+		} else {
+			token = x
+			changed = true
+		}
+	}
+	if now.Sub(token.Validated_at) >= VALIDATE_INTERVAL {
+		validation, err := self.Client.Validate(ctx, token.Access_token)
+		if err != nil {
+			return token, fmt.Errorf("The login is no longer valid, please log in again: %w", err)
+		}
+		token.Login, token.User_id, token.Scopes = validation.Login, validation.User_id, validation.Scopes
+		token.Validated_at = now
+		changed = true
+	}
+
+	if changed {
+		if err := self.Store.Save(token); err != nil {
+			return token, err
+		}
+		self.token = &token
+	}
+	return token, nil
+}
+
+func (self *Session) Access_token(ctx context.Context) (string, error) {
+	token, err := self.Token(ctx)
+	return token.Access_token, err
+}
+
+// Runs the device code flow, show is called with the code the user enters
+func (self *Session) Login(ctx context.Context, show func(DeviceCode)) (Token, error) {
+	code, err := self.Client.Start_device(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+	show(code)
+	token, err := self.Client.Poll(ctx, code)
+	if err != nil {
+		return token, err
+	}
+	validation, err := self.Client.Validate(ctx, token.Access_token)
+	if err != nil {
+		return token, err
+	}
+	token.Login, token.User_id, token.Validated_at = validation.Login, validation.User_id, self.Now()
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if err := self.Store.Save(token); err != nil {
+		return token, err
+	}
+	self.token = &token
+	return token, nil
+}
+
+// Revokes the token (if Twitch is reachable) and forgets it
+func (self *Session) Logout(ctx context.Context) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	token, err := self.Store.Load()
+	if err != nil {
+		return err
+	}
+	if err := self.Client.Revoke(ctx, token.Access_token); err != nil {
+		src.L_ERROR.Printf("Could not revoke the token: %s", err)
+	}
+	self.token = nil
+	return self.Store.Delete()
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+// Stands in for id.twitch.tv
+type fake_oauth struct {
+	mutex     sync.Mutex
+	polls     int // Polls answered with authorization_pending before success
+	issued    int
+	refreshed int
+	validated int
+	revoked   []string
+}
+
+func (self *fake_oauth) serve(w http.ResponseWriter, r *http.Request) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	fail := func(status int, message string) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"status":%d,"message":%q}`, status, message)
+	}
+	issue := func() {
+		self.issued += 1
+		fmt.Fprintf(w, `{"access_token":"access%d","refresh_token":"refresh%d","expires_in":14400,"scope":["chat:read"],"token_type":"bearer"}`, self.issued, self.issued)
+	}
+	_ = r.ParseForm()
+	if r.URL.Path != "/oauth2/validate" && r.Form.Get("client_id") != "app" {
+		fail(400, "invalid client")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/oauth2/device":
+		fmt.Fprint(w, `{"device_code":"device","user_code":"ABCDEFGH","verification_uri":"https://www.twitch.tv/activate?device-code=ABCDEFGH","expires_in":1800,"interval":0}`)
+	case "/oauth2/token":
+		switch r.Form.Get("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:device_code":
+			if self.polls > 0 {
+				self.polls -= 1
+				fail(400, "authorization_pending")
+			} else {
+				issue()
+			}
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != fmt.Sprintf("refresh%d", self.issued) {
+				fail(400, "Invalid refresh token")
+				return
+			}
+			self.refreshed += 1
+			issue()
+		}
+	case "/oauth2/validate":
+		if r.Header.Get("Authorization") != fmt.Sprintf("OAuth access%d", self.issued) {
+			fail(401, "invalid access token")
+			return
+		}
+		self.validated += 1
+		fmt.Fprint(w, `{"client_id":"app","login":"foo","user_id":"123","scopes":["chat:read"],"expires_in":14000}`)
+	case "/oauth2/revoke":
+		self.revoked = append(self.revoked, r.Form.Get("token"))
+	default:
+		fail(404, "not found")
+	}
+}
+
+func new_fake(t *testing.T, polls int) (*fake_oauth, *Client) {
+	fake := &fake_oauth{ polls: polls }
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+	client := New_client(src.AuthConfig{ Client_id: "app" })
+	client.Base = server.URL
+	return fake, client
+}
+
+func TestLogin(t *testing.T) {
+	fake, client := new_fake(t, 2)
+	store := File_store{ filepath.Join(t.TempDir(), "token.json") }
+	session := New_session(client, store)
+
+	_, err := session.Token(context.Background())
+	a.AssertEqual(t, ErrNoToken, err)
+
+	var shown DeviceCode
+	token, err := session.Login(context.Background(), func(code DeviceCode) { shown = code })
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "ABCDEFGH", shown.User_code)
+	a.AssertEqual(t, "access1", token.Access_token)
+	a.AssertEqual(t, "foo", token.Login)
+	a.AssertEqual(t, "123", token.User_id)
+
+	info, err := os.Stat(store.Path)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, os.FileMode(0o600), info.Mode().Perm())
+	saved, err := store.Load()
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "refresh1", saved.Refresh_token)
+
+	a.AssertEqual(t, nil, session.Logout(context.Background()))
+	a.AssertEqual(t, []string{"access1"}, fake.revoked)
+	_, err = store.Load()
+	a.AssertEqual(t, ErrNoToken, err)
+}
+
+func TestRefreshAndValidate(t *testing.T) {
+	fake, client := new_fake(t, 0)
+	store := File_store{ filepath.Join(t.TempDir(), "token.json") }
+	session := New_session(client, store)
+	_, err := session.Login(context.Background(), func(DeviceCode) {})
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, 1, fake.validated)
+
+	// Fresh tokens are used as they are
+	access, err := session.Access_token(context.Background())
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "access1", access)
+	a.AssertEqual(t, 0, fake.refreshed)
+
+	// Validated again after an hour
+	now := time.Now()
+	session.Now = func() time.Time { return now.Add(VALIDATE_INTERVAL) }
+	_, err = session.Access_token(context.Background())
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, 2, fake.validated)
+
+	// Refreshed shortly before expiring, and the new refresh token is saved
+	session.Now = func() time.Time { return now.Add(4 * time.Hour - time.Minute) }
+	access, err = session.Access_token(context.Background())
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "access2", access)
+	a.AssertEqual(t, 1, fake.refreshed)
+	saved, err := store.Load()
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "refresh2", saved.Refresh_token)
+	a.AssertEqual(t, "foo", saved.Login)
+
+	// A revoked login can no longer be refreshed
+	fake.issued += 1
+	session.Now = func() time.Time { return now.Add(5 * time.Hour) }
+	_, err = session.Access_token(context.Background())
+	a.AssertEqual(t, true, err != nil)
+}
+
+func TestFileStorePermissions(t *testing.T) {
+	store := File_store{ filepath.Join(t.TempDir(), "token.json") }
+	a.AssertEqual(t, nil, os.WriteFile(store.Path, []byte("{}"), 0o644))
+	a.AssertEqual(t, nil, store.Save(Token{ Access_token: "x" }))
+	info, err := os.Stat(store.Path)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, os.FileMode(0o600), info.Mode().Perm())
+	a.AssertEqual(t, nil, store.Delete())
+	a.AssertEqual(t, nil, store.Delete()) // Already gone is fine
+}
+
+func TestCommandStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	store := Command_store{ src.KeyringConfig{
+		Store: []string{"sh", "-c", "cat > " + path},
+		Lookup: []string{"sh", "-c", "cat " + path + " 2>/dev/null"},
+		Clear: []string{"rm", "-f", path},
+	}}
+	_, err := store.Load()
+	a.AssertEqual(t, ErrNoToken, err)
+
+	a.AssertEqual(t, nil, store.Save(Token{ Access_token: "x", Login: "foo" }))
+	token, err := store.Load()
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "foo", token.Login)
+
+	var raw map[string]any
+	data, _ := os.ReadFile(path)
+	a.AssertEqual(t, nil, json.Unmarshal(data, &raw))
+	a.AssertEqual(t, "x", raw["access_token"])
+
+	a.AssertEqual(t, nil, store.Delete())
+	_, err = store.Load()
+	a.AssertEqual(t, ErrNoToken, err)
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+type Token struct {
+	Access_token  string    `json:"access_token"`
+	Refresh_token string    `json:"refresh_token"`
+	Scopes        []string  `json:"scopes"`
+	Expires_at    time.Time `json:"expires_at"`
+	Login         string    `json:"login"`
+	User_id       string    `json:"user_id"`
+	Validated_at  time.Time `json:"validated_at"`
+}
+
+var ErrNoToken = src.ErrLoggedOut
+
+// Where the token is kept between runs
+type Store interface {
+	Load() (Token, error) // ErrNoToken if there is none
+	Save(token Token) error
+	Delete() error
+}
+
+// The file and keyring stores from the config
+func New_store(config src.AuthConfig) (Store, error) {
+	if config.Keyring != nil {
+		if len(config.Keyring.Store) == 0 || len(config.Keyring.Lookup) == 0 || len(config.Keyring.Clear) == 0 {
+			return nil, fmt.Errorf("auth.keyring needs store, lookup and clear commands")
+		}
+		return Command_store{ *config.Keyring }, nil
+	}
+	path, err := src.Config_path("token.json")
+	if err != nil {
+		return nil, err
+	}
+	return File_store{ path }, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// File
+
+// Readable only by the user, like ~/.ssh keys
+type File_store struct {
+	Path string
+}
+
+func (self File_store) Load() (Token, error) {
+	var ret Token
+	data, err := os.ReadFile(self.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, ErrNoToken
+		}
+		return ret, err
+	}
+	if info, err := os.Stat(self.Path); err == nil && info.Mode().Perm() & 0o077 != 0 {
+		src.L_ERROR.Printf("%s is readable by other users, consider chmod 600", self.Path)
+	}
+	return ret, json.Unmarshal(data, &ret)
+}
+
+func (self File_store) Save(token Token) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	return src.Write_file_atomic(self.Path, data, 0o600)
+}
+
+func (self File_store) Delete() error {
+	if err := os.Remove(self.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Keyring
+
+// Hands the token (as JSON) to external commands, e.g. secret-tool or pass
+type Command_store struct {
+	Commands src.KeyringConfig
+}
+
+func (self Command_store) Load() (Token, error) {
+	var ret Token
+	var stdout bytes.Buffer
+	if err := run_keyring(self.Commands.Lookup, nil, &stdout); err != nil {
+		// e.g. secret-tool exits with 1 when there is no such secret
+		if _, ok := err.(*exec.ExitError); ok && stdout.Len() == 0 {
+			return ret, ErrNoToken
+		}
+		return ret, err
+	}
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return ret, ErrNoToken
+	}
+	return ret, json.Unmarshal(stdout.Bytes(), &ret)
+}
+
+func (self Command_store) Save(token Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return run_keyring(self.Commands.Store, bytes.NewReader(data), nil)
+}
+
+func (self Command_store) Delete() error {
+	return run_keyring(self.Commands.Clear, nil, nil)
+}
+
+func run_keyring(command []string, stdin *bytes.Reader, stdout *bytes.Buffer) error {
+	src.L_DEBUG.Printf("%s", strings.Join(command, " "))
+	cmd := exec.Command(command[0], command[1:]...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			src.L_DEBUG.Printf("%s", stderr.String())
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+// Advanced SubStation Alpha subtitles, so that players show VOD chat in sync
+//
+// Chat is drawn as a panel on the right of the video. Every time a message
+// arrives or expires, the visible messages are laid out again from the
+// bottom up, so older messages scroll up like in the browser. libass cannot
+// measure text for us, so we wrap lines ourselves and force \q2 (no wrap).
+
+type AssOptions struct {
+	Font_size   int
+	Lifetime    time.Duration // How long each message stays on screen
+	Panel_width int // In script pixels, out of ASS_WIDTH
+}
+
+const (
+	ASS_WIDTH = 1920
+	ASS_HEIGHT = 1080
+	ASS_MARGIN = 20
+
+	DEFAULT_ASS_FONT_SIZE = 28
+	DEFAULT_ASS_LIFETIME = 15 * time.Second
+	DEFAULT_ASS_PANEL_WIDTH = 480
+)
+
+// Fills in the defaults for unset fields
+func (self AssOptions) or_defaults() AssOptions {
+	if self.Font_size <= 0 {
+		self.Font_size = DEFAULT_ASS_FONT_SIZE
+	}
+	if self.Lifetime <= 0 {
+		self.Lifetime = DEFAULT_ASS_LIFETIME
+	}
+	if self.Panel_width <= 0 {
+		self.Panel_width = DEFAULT_ASS_PANEL_WIDTH
+	}
+	self.Panel_width = min(self.Panel_width, ASS_WIDTH - 2 * ASS_MARGIN)
+	return self
+}
+
+// Comments are buffered since the layout depends on the messages after them
+type ass_sink struct {
+	output   io.Writer
+	options  AssOptions
+	messages []Message
+}
+
+func (self *ass_sink) Write(msg Message) error {
+	self.messages = append(self.messages, msg)
+	return nil
+}
+
+func (self *ass_sink) Close() error {
+	return Write_ass(self.output, self.messages, self.options)
+}
+
+type ass_line struct {
+	text  string // With override tags and \N between wrapped lines
+	lines int
+}
+
+// An event being extended for as long as its message stays in place
+type ass_open struct {
+	start time.Duration
+	y     int
+}
+
+func Write_ass(output io.Writer, messages []Message, options AssOptions) error {
+	options = options.or_defaults()
+	messages = slices.Clone(messages)
+	slices.SortStableFunc(messages, func(a, b Message) int {
+		return int(a.Offset - b.Offset)
+	})
+
+	line_height := options.Font_size * 5 / 4
+	// Average glyph width of a proportional font is about half its size
+	columns := max(options.Panel_width * 2 / options.Font_size, 8)
+	left := ASS_WIDTH - ASS_MARGIN - options.Panel_width
+	bottom := ASS_HEIGHT - ASS_MARGIN
+
+	var ret strings.Builder
+	ret.WriteString("[Script Info]\n")
+	ret.WriteString("; Generated by streamsurf from VOD comments\n")
+	fmt.Fprintf(&ret, "ScriptType: v4.00+\nPlayResX: %d\nPlayResY: %d\nWrapStyle: 2\nScaledBorderAndShadow: yes\n\n", ASS_WIDTH, ASS_HEIGHT)
+	ret.WriteString("[V4+ Styles]\n")
+	ret.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	fmt.Fprintf(&ret, "Style: Chat,Sans,%d,&H00FFFFFF,&H00FFFFFF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,2,1,7,0,0,0,1\n\n", options.Font_size)
+	ret.WriteString("[Events]\n")
+	ret.WriteString("Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+
+	lines := make([]ass_line, len(messages))
+	for i, msg := range messages {
+		lines[i] = ass_wrap(msg, columns)
+	}
+
+	// Every arrival and expiry changes the layout
+	changes := make([]time.Duration, 0, 2 * len(messages))
+	for _, msg := range messages {
+		changes = append(changes, msg.Offset, msg.Offset + options.Lifetime)
+	}
+	slices.Sort(changes)
+	changes = slices.Compact(changes)
+
+	open := make(map[int]ass_open)
+	emit := func(i int, close time.Duration) {
+		x := open[i]
+		fmt.Fprintf(&ret, "Dialogue: 0,%s,%s,Chat,,0,0,0,,{\\an7\\q2\\pos(%d,%d)}%s\n",
+			ass_time(x.start), ass_time(close), left, x.y, lines[i].text)
+		delete(open, i)
+	}
+
+	// Both only move forward, so that long VODs are not scanned once per change
+	first := 0 // Messages before this have expired for good
+	upper := 0 // Messages from this on have not arrived yet
+	for _, now := range changes {
+		for first < len(messages) && messages[first].Offset + options.Lifetime <= now {
+			first += 1
+		}
+		for upper < len(messages) && messages[upper].Offset <= now {
+			upper += 1
+		}
+		// Newest at the bottom, stop once the panel is full
+		placed := make(map[int]int)
+		y := bottom
+		for i := upper - 1; i >= first; i -= 1 {
+			y -= lines[i].lines * line_height
+			if y < ASS_MARGIN {
+				break
+			}
+			placed[i] = y
+		}
+
+		// Sorted so that the output does not depend on map order
+		for _, i := range slices.Sorted(maps.Keys(open)) {
+			if y, ok := placed[i]; !ok || y != open[i].y {
+				emit(i, now)
+			}
+		}
+		for i, y := range placed {
+			if _, ok := open[i]; !ok {
+				open[i] = ass_open{ start: now, y: y }
+			}
+		}
+	}
+	// The last change is always an expiry, so nothing can be left open
+	src.Assert(len(open) == 0)
+
+	_, err := io.WriteString(output, ret.String())
+	return err
+}
+
+// Wraps "Name: text" to columns wide lines, breaking on spaces where possible
+func ass_wrap(msg Message, columns int) ass_line {
+	sep := ": "
+	if msg.Is_action {
+		sep = " "
+	}
+
+	var rows []string
+	var row strings.Builder
+	width := uniseg.StringWidth(msg.Display_name + sep) // The name starts the first row
+	flush := func() {
+		rows = append(rows, row.String())
+		row.Reset()
+		width = 0
+	}
+	need_space := false
+	for _, word := range strings.Fields(msg.Text) {
+		w := uniseg.StringWidth(word)
+		if need_space && width + 1 + w > columns {
+			flush()
+		} else if need_space {
+			row.WriteByte(' ')
+			width += 1
+		}
+		// Words longer than a line are split wherever they hit the edge
+		for width + w > columns {
+			cut, cut_width := 0, 0
+			for _, r := range word {
+				rw := uniseg.StringWidth(string(r))
+				if width + cut_width + rw > columns {
+					break
+				}
+				cut += utf8.RuneLen(r)
+				cut_width += rw
+			}
+			if cut == 0 && width > 0 {
+				flush()
+				continue
+			} else if cut == 0 {
+				_, cut = utf8.DecodeRuneInString(word)
+				cut_width = uniseg.StringWidth(word[:cut])
+			}
+			row.WriteString(word[:cut])
+			flush()
+			word = word[cut:]
+			w -= cut_width
+		}
+		row.WriteString(word)
+		width += w
+		need_space = true
+	}
+	flush()
+
+	name := ass_escape(msg.Display_name)
+	if color, ok := ass_color(msg.Color); ok {
+		name = fmt.Sprintf("{\\c%s}%s{\\c}", color, name)
+	}
+	for i := range rows {
+		rows[i] = ass_escape(rows[i])
+	}
+	return ass_line{ text: name + sep + strings.Join(rows, "\\N"), lines: len(rows) }
+}
+
+// h:mm:ss.cc
+func ass_time(offset time.Duration) string {
+	cs := offset.Milliseconds() / 10
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs / 360000, cs / 6000 % 60, cs / 100 % 60, cs % 100)
+}
+
+// "#RRGGBB" -> "&HBBGGRR&", ASS colours are BGR
+func ass_color(hex string) (string, bool) {
+	r, g, b, ok := Parse_hex_color(hex)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("&H%02X%02X%02X&", b, g, r), true
+}
+
+// Braces start override blocks and backslashes escapes, a zero width space
+// after a backslash keeps e.g. "\N" from becoming a line break
+func ass_escape(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\u200B")
+	s = strings.ReplaceAll(s, "{", "\\{")
+	s = strings.ReplaceAll(s, "}", "\\}")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Subtitle files
+
+func Ass_options(config src.ChatConfig) AssOptions {
+	return AssOptions{
+		Font_size: config.Subtitle_font_size,
+		Lifetime: time.Duration(config.Subtitle_lifetime_seconds) * time.Second,
+		Panel_width: config.Subtitle_panel_width,
+	}
+}
+
+// Generated subtitles are kept in the cache directory, VOD comments do not
+// change after the broadcast
+func Subtitle_path(video_id string, options AssOptions) (string, error) {
+	var dir string
+	if x, err := os.UserCacheDir(); err != nil {
+		return "", err
+	} else {
+		dir = filepath.Join(x, "streamsurf", "subtitles")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	// Different options give a different file
+	options = options.or_defaults()
+	name := fmt.Sprintf("%s-%d-%d-%d.ass", video_id, options.Font_size, int(options.Lifetime.Seconds()), options.Panel_width)
+	return filepath.Join(dir, name), nil
+}
+
+// Fetches the comments of a VOD into an .ass file, unless it was already
+// generated, and returns its path. on_page is called with every page fetched.
+func Generate_subtitles(ctx context.Context, video_id string, options AssOptions, on_page func([]Message) error) (string, error) {
+	path, err := Subtitle_path(video_id, options)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	var messages []Message
+	err = Fetch_comments(ctx, video_id, func(page []Message) error {
+		messages = append(messages, page...)
+		if on_page != nil {
+			return on_page(page)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Written in one go so that an interrupted fetch is not mistaken for a
+	// finished one
+	var data bytes.Buffer
+	if err := Write_ass(&data, messages, options); err != nil {
+		return "", err
+	}
+	return path, src.Write_file_atomic(path, data.Bytes(), 0o644)
+}
@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+const DEFAULT_SCROLLBACK = 2000
+
+// Scrollback of one chat. Once Capacity is reached the oldest messages are
+// dropped, so indices shift down by the count Push returns.
+type Buffer struct {
+	Messages []Message
+	Capacity int
+}
+
+func (self *Buffer) Push(msg Message) (dropped int) {
+	capacity := self.Capacity
+	if capacity <= 0 {
+		capacity = DEFAULT_SCROLLBACK
+	}
+	self.Messages = append(self.Messages, msg)
+	if len(self.Messages) > capacity {
+		dropped = len(self.Messages) - capacity
+		// Copy down rather than reslice, so that the backing array does not
+		// grow forever
+		n := copy(self.Messages, self.Messages[dropped:])
+		clear(self.Messages[n:])
+		self.Messages = self.Messages[:n]
+	}
+	return dropped
+}
+
+func (self *Buffer) Clear() {
+	clear(self.Messages)
+	self.Messages = self.Messages[:0]
+}
+
+// The range [start, close) of up to before and after messages around idx
+// that pass include
+func (self *Buffer) Context(idx int, before int, after int, include func(int) bool) (int, int) {
+	start, close := idx, idx + 1
+	for count := 0; count < before && start > 0; {
+		start -= 1
+		if include(start) {
+			count += 1
+		}
+	}
+	for count := 0; count < after && close < len(self.Messages); close += 1 {
+		if include(close) {
+			count += 1
+		}
+	}
+	return start, close
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Search
+
+// Case-insensitive substring match on the text, login or display name
+// Returns the byte offsets into the text to highlight
+func Search_match(query string, msg Message) ([]int, bool) {
+	if query == "" {
+		return nil, false
+	}
+	lower := strings.ToLower(query)
+	if strings.Contains(msg.User, lower) || strings.Contains(strings.ToLower(msg.Display_name), lower) {
+		return nil, true
+	}
+	text := strings.ToLower(msg.Text)
+	// ToLower can change byte lengths outside of ASCII, only mark if it did not
+	if len(text) != len(msg.Text) {
+		return nil, strings.Contains(text, lower)
+	}
+	var ret []int
+	for i := 0; ; {
+		j := strings.Index(text[i:], lower)
+		if j < 0 {
+			break
+		}
+		for k := i + j; k < i + j + len(lower); k += 1 {
+			ret = append(ret, k)
+		}
+		i += j + len(lower)
+	}
+	return ret, len(ret) > 0
+}
+
+// The next message from idx (exclusive) in dir (1 or -1) that matches and
+// passes include, wrapping around. -1 if nothing matches.
+func (self *Buffer) Search(query string, idx int, dir int, include func(int) bool) int {
+	length := len(self.Messages)
+	for step := 1; step <= length; step += 1 {
+		i := ((idx + dir * step) % length + length) % length
+		if !include(i) {
+			continue
+		}
+		if _, ok := Search_match(query, self.Messages[i]); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Rules
+
+type rule struct {
+	user    string
+	keyword string
+	regex   *regexp.Regexp
+}
+
+type Rules struct {
+	highlight []rule
+	hide      []rule
+}
+
+func Compile_rules(highlight []src.ChatRule, hide []src.ChatRule) (Rules, error) {
+	var ret Rules
+	compile := func(kind string, rules []src.ChatRule) ([]rule, error) {
+		compiled := make([]rule, 0, len(rules))
+		for i, x := range rules {
+			if x.User == "" && x.Keyword == "" && x.Regex == "" {
+				return nil, fmt.Errorf("Chat %s rule %d is empty, it would match every message", kind, i + 1)
+			}
+			r := rule{ user: strings.ToLower(x.User), keyword: strings.ToLower(x.Keyword) }
+			if x.Regex != "" {
+				re, err := regexp.Compile(x.Regex)
+				if err != nil {
+					return nil, fmt.Errorf("Chat %s rule %d: %w", kind, i + 1, err)
+				}
+				r.regex = re
+			}
+			compiled = append(compiled, r)
+		}
+		return compiled, nil
+	}
+
+	var err error
+	if ret.highlight, err = compile("highlight", highlight); err != nil {
+		return ret, err
+	}
+	if ret.hide, err = compile("hide", hide); err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+func (self rule) matches(msg Message) bool {
+	if self.user != "" && self.user != msg.User && self.user != strings.ToLower(msg.Display_name) {
+		return false
+	}
+	if self.keyword != "" && !has_word(strings.ToLower(msg.Text), self.keyword) {
+		return false
+	}
+	if self.regex != nil && !self.regex.MatchString(msg.Text) {
+		return false
+	}
+	return true
+}
+
+// Whether word appears in text, not as part of a longer word
+func has_word(text string, word string) bool {
+	is_word := func(b byte) bool {
+		return b >= 0x80 || b == '_' || unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b))
+	}
+	for i := 0; i <= len(text) - len(word); {
+		j := strings.Index(text[i:], word)
+		if j < 0 {
+			return false
+		}
+		start, close := i + j, i + j + len(word)
+		if (start == 0 || !is_word(text[start - 1])) && (close == len(text) || !is_word(text[close])) {
+			return true
+		}
+		i = start + 1
+	}
+	return false
+}
+
+func (self Rules) Is_highlighted(msg Message) bool {
+	for _, r := range self.highlight {
+		if r.matches(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self Rules) Is_hidden(msg Message) bool {
+	for _, r := range self.hide {
+		if r.matches(msg) {
+			return true
+		}
+	}
+	return false
+}
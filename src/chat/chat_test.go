@@ -0,0 +1,271 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+func TestParseLine(t *testing.T) {
+	line, err := Parse_line("@badge-info=;color=#FF4500;display-name=Foo\\sBar;id=abc;tmi-sent-ts=1700000000000 :foo!foo@foo.tmi.twitch.tv PRIVMSG #tsoding :hello :) world\r\n")
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "PRIVMSG", line.Command)
+	a.AssertEqual(t, []string{"#tsoding", "hello :) world"}, line.Params)
+	a.AssertEqual(t, "Foo Bar", line.Tags["display-name"])
+	a.AssertEqual(t, "", line.Tags["badge-info"])
+
+	msg, ok := line.Message()
+	a.AssertEqual(t, true, ok)
+	a.AssertEqual(t, Message{
+		Id: "abc",
+		Time: time.UnixMilli(1700000000000),
+		Channel: "tsoding",
+		User: "foo",
+		Display_name: "Foo Bar",
+		Color: "#FF4500",
+		Text: "hello :) world",
+	}, msg)
+
+	line, err = Parse_line("PING :tmi.twitch.tv")
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, Line{ Command: "PING", Params: []string{"tmi.twitch.tv"} }, line)
+
+	line, _ = Parse_line(":bar!bar@bar PRIVMSG #tsoding :\x01ACTION waves\x01")
+	msg, _ = line.Message()
+	a.AssertEqual(t, "waves", msg.Text)
+	a.AssertEqual(t, true, msg.Is_action)
+	a.AssertEqual(t, "bar", msg.Display_name)
+
+	_, ok = Line{ Command: "JOIN", Params: []string{"#tsoding"} }.Message()
+	a.AssertEqual(t, false, ok)
+	_, err = Parse_line("@a=b")
+	a.AssertEqual(t, true, err != nil)
+}
+
+func TestBuffer(t *testing.T) {
+	buffer := Buffer{ Capacity: 3 }
+	for _, text := range []string{"a", "b", "c"} {
+		a.AssertEqual(t, 0, buffer.Push(Message{ Text: text }))
+	}
+	a.AssertEqual(t, 1, buffer.Push(Message{ Text: "d" }))
+	a.AssertEqual(t, []Message{{ Text: "b" }, { Text: "c" }, { Text: "d" }}, buffer.Messages)
+
+	all := func(int) bool { return true }
+	not_c := func(i int) bool { return buffer.Messages[i].Text != "c" }
+	a.AssertEqual(t, [2]int{0, 3}, pair(buffer.Context(1, 1, 1, all)))
+	a.AssertEqual(t, [2]int{0, 3}, pair(buffer.Context(2, 1, 5, not_c)))
+	a.AssertEqual(t, [2]int{2, 3}, pair(buffer.Context(2, 0, 0, all)))
+}
+
+func pair(x int, y int) [2]int {
+	return [2]int{x, y}
+}
+
+func TestSearch(t *testing.T) {
+	buffer := Buffer{}
+	buffer.Push(Message{ User: "alice", Display_name: "Alice", Text: "first Go message" })
+	buffer.Push(Message{ User: "bob", Display_name: "bob", Text: "nothing" })
+	buffer.Push(Message{ User: "carol", Display_name: "Carol", Text: "go go" })
+	all := func(int) bool { return true }
+
+	a.AssertEqual(t, 2, buffer.Search("go", 0, 1, all))
+	a.AssertEqual(t, 0, buffer.Search("go", 2, 1, all)) // Wraps
+	a.AssertEqual(t, 2, buffer.Search("go", 0, -1, all))
+	a.AssertEqual(t, 1, buffer.Search("BOB", 0, 1, all))
+	a.AssertEqual(t, 0, buffer.Search("go", 0, 1, func(i int) bool { return i != 2 }))
+	a.AssertEqual(t, -1, buffer.Search("zzz", 0, 1, all))
+
+	marks, ok := Search_match("go", buffer.Messages[2])
+	a.AssertEqual(t, true, ok)
+	a.AssertEqual(t, []int{0, 1, 3, 4}, marks)
+	_, ok = Search_match("", buffer.Messages[2])
+	a.AssertEqual(t, false, ok)
+}
+
+func TestRules(t *testing.T) {
+	rules, err := Compile_rules(
+		[]src.ChatRule{{ User: "Tsoding" }, { Keyword: "streamsurf" }, { Regex: "^!song" }},
+		[]src.ChatRule{{ User: "nightbot" }, { User: "streamelements", Keyword: "giveaway" }},
+	)
+	a.AssertEqual(t, nil, err)
+
+	a.AssertEqual(t, true, rules.Is_highlighted(Message{ User: "tsoding", Text: "hi" }))
+	a.AssertEqual(t, true, rules.Is_highlighted(Message{ User: "x", Text: "I use StreamSurf!" }))
+	a.AssertEqual(t, false, rules.Is_highlighted(Message{ User: "x", Text: "streamsurfing" }))
+	a.AssertEqual(t, true, rules.Is_highlighted(Message{ User: "x", Text: "!song" }))
+	a.AssertEqual(t, false, rules.Is_highlighted(Message{ User: "x", Text: "a !song" }))
+
+	a.AssertEqual(t, true, rules.Is_hidden(Message{ User: "nightbot", Text: "anything" }))
+	a.AssertEqual(t, false, rules.Is_hidden(Message{ User: "streamelements", Text: "hello" }))
+	a.AssertEqual(t, true, rules.Is_hidden(Message{ User: "streamelements", Text: "enter the giveaway" }))
+
+	_, err = Compile_rules([]src.ChatRule{{}}, nil)
+	a.AssertEqual(t, true, err != nil)
+	_, err = Compile_rules(nil, []src.ChatRule{{ Regex: "(" }})
+	a.AssertEqual(t, true, err != nil)
+}
+
+// Stands in for irc.chat.twitch.tv
+func fake_server(t *testing.T, handle func(reader *bufio.Reader, conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(bufio.NewReader(conn), conn)
+	}()
+	return listener.Addr().String()
+}
+
+func TestListen(t *testing.T) {
+	received := make(chan []string, 1)
+	addr := fake_server(t, func(reader *bufio.Reader, conn net.Conn) {
+		var lines []string
+		for len(lines) < 3 {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+		}
+		conn.Write([]byte("PING :tmi.twitch.tv\r\n"))
+		pong, _ := reader.ReadString('\n')
+		lines = append(lines, strings.TrimRight(pong, "\r\n"))
+		received <- lines
+		conn.Write([]byte("@display-name=Foo;color=#00FF00 :foo!foo@foo.tmi.twitch.tv PRIVMSG #tsoding :hello\r\n"))
+		// Hold the connection open until the client leaves
+		_, _ = reader.ReadString('\n')
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages := make(chan Message, 1)
+	done := make(chan error, 1)
+	go func() { done <- Listen(ctx, &Client{ Addr: addr }, "Tsoding", messages) }()
+
+	lines := <-received
+	a.AssertEqual(t, "CAP REQ :twitch.tv/tags twitch.tv/commands", lines[0])
+	a.AssertEqual(t, true, strings.HasPrefix(lines[1], "NICK justinfan"))
+	a.AssertEqual(t, "JOIN #tsoding", lines[2])
+	a.AssertEqual(t, "PONG :tmi.twitch.tv", lines[3])
+
+	msg := <-messages
+	a.AssertEqual(t, "Foo", msg.Display_name)
+	a.AssertEqual(t, "hello", msg.Text)
+
+	cancel()
+	a.AssertEqual(t, nil, <-done)
+}
+
+func TestSay(t *testing.T) {
+	received := make(chan []string, 1)
+	addr := fake_server(t, func(reader *bufio.Reader, conn net.Conn) {
+		var lines []string
+		read := func() {
+			line, _ := reader.ReadString('\n')
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+		}
+		for len(lines) < 4 {
+			read()
+		}
+		conn.Write([]byte("@room-id=1;emote-only=0;followers-only=-1;r9k=0;slow=30;subs-only=0 :tmi.twitch.tv ROOMSTATE #tsoding\r\n"))
+		conn.Write([]byte("@color=;display-name=Foo :tmi.twitch.tv USERSTATE #tsoding\r\n"))
+		read()
+		conn.Write([]byte("@color=#0000FF;display-name=Foo;id=abc :tmi.twitch.tv USERSTATE #tsoding\r\n"))
+		read()
+		conn.Write([]byte("@msg-id=msg_slowmode :tmi.twitch.tv NOTICE #tsoding :This room is in slow mode and you are sending messages too quickly.\r\n"))
+		received <- lines
+		_, _ = reader.ReadString('\n')
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &Client{ Addr: addr, Nick: "Foo", Token: "secret" }
+	a.AssertEqual(t, ErrAnonymous, (&Client{}).Say("tsoding", "hi"))
+	messages := make(chan Message, 4)
+	done := make(chan error, 1)
+	go func() { done <- Listen(ctx, client, "tsoding", messages) }()
+
+	notice := <-messages
+	a.AssertEqual(t, true, notice.Is_notice)
+	a.AssertEqual(t, "Slow mode (30s)", notice.Text)
+
+	a.AssertEqual(t, nil, client.Say("tsoding", "/me waves"))
+	msg := <-messages
+	a.AssertEqual(t, Message{ Id: "abc", Time: msg.Time, Channel: "tsoding", User: "foo", Display_name: "Foo", Color: "#0000FF", Text: "waves", Is_action: true }, msg)
+
+	a.AssertEqual(t, nil, client.Say("tsoding", "too fast"))
+	notice = <-messages
+	a.AssertEqual(t, true, notice.Is_notice)
+	a.AssertEqual(t, 0, len(client.pending))
+
+	lines := <-received
+	a.AssertEqual(t, []string{
+		"CAP REQ :twitch.tv/tags twitch.tv/commands",
+		"PASS oauth:secret",
+		"NICK foo",
+		"JOIN #tsoding",
+		"PRIVMSG #tsoding :\x01ACTION waves\x01",
+		"PRIVMSG #tsoding :too fast",
+	}, lines)
+
+	a.AssertEqual(t, true, client.Say("tsoding", "/ban foo") != nil)
+	a.AssertEqual(t, true, client.Say("tsoding", strings.Repeat("a", MAX_MESSAGE_LENGTH + 1)) != nil)
+
+	cancel()
+	a.AssertEqual(t, nil, <-done)
+}
+
+// The chat screen logs in on the listening goroutine, while enter may already
+// call Say (go test -race)
+func TestLogIn(t *testing.T) {
+	received := make(chan []string, 1)
+	addr := fake_server(t, func(reader *bufio.Reader, conn net.Conn) {
+		var lines []string
+		for len(lines) < 3 {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+		}
+		received <- lines
+	})
+
+	client := &Client{ Addr: addr }
+	said := make(chan bool)
+	go func() {
+		// Whether this is anonymous or sent depends on the timing
+		_ = client.Say("tsoding", "hi")
+		said <- true
+	}()
+	client.Log_in("Foo", "secret")
+	a.AssertEqual(t, nil, client.Connect(context.Background()))
+	<-said
+	defer client.Close()
+
+	lines := <-received
+	a.AssertEqual(t, []string{
+		"CAP REQ :twitch.tv/tags twitch.tv/commands",
+		"PASS oauth:secret",
+		"NICK foo",
+	}, lines)
+}
+
+func TestRoomState(t *testing.T) {
+	a.AssertEqual(t, "", room_state(map[string]string{ "room-id": "1", "emote-only": "0", "followers-only": "-1", "r9k": "0", "slow": "0", "subs-only": "0" }))
+	a.AssertEqual(t, "Followers-only (0 minutes)", room_state(map[string]string{ "room-id": "1", "emote-only": "0", "followers-only": "0", "r9k": "0", "slow": "0", "subs-only": "0" }))
+	a.AssertEqual(t, "Slow mode off", room_state(map[string]string{ "room-id": "1", "slow": "0" }))
+}
@@ -0,0 +1,461 @@
+// Twitch chat, read over IRC
+package chat
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+//run: go test
+
+// https://dev.twitch.tv/docs/chat/irc/
+const IRC_ADDR = "irc.chat.twitch.tv:6697"
+
+// Twitch pings every ~5 minutes, so anything longer means the connection died
+const READ_TIMEOUT = 6 * time.Minute
+
+type Message struct {
+	Id           string // Used to delete messages
+	Time         time.Time
+	Offset       time.Duration // Into the VOD, only set for VOD comments
+	Channel      string
+	User         string // Login name, always lowercase
+	User_id      string // Twitch user ID, what moderation works with
+	Display_name string // May differ in case, or be in another script
+	Color        string // "#RRGGBB", empty if the user never set one
+	Text         string
+	Is_action    bool // /me messages
+	Is_notice    bool // From Twitch rather than a user, e.g. "This room is in slow mode"
+}
+
+// One line of the IRC protocol, with IRCv3 tags
+// e.g. "@color=#FF0000;display-name=Foo :foo!foo@foo.tmi.twitch.tv PRIVMSG #bar :hello"
+type Line struct {
+	Tags    map[string]string
+	Prefix  string
+	Command string
+	Params  []string // The trailing parameter (after " :") is the last one
+}
+
+func Parse_line(line string) (Line, error) {
+	var ret Line
+	line = strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(line, "@") {
+		tags, rest, ok := strings.Cut(line[1:], " ")
+		if !ok {
+			return ret, fmt.Errorf("Tags without a command: %q", line)
+		}
+		ret.Tags = make(map[string]string)
+		for _, tag := range strings.Split(tags, ";") {
+			k, v, _ := strings.Cut(tag, "=")
+			ret.Tags[k] = unescape_tag(v)
+		}
+		line = rest
+	}
+	if strings.HasPrefix(line, ":") {
+		prefix, rest, ok := strings.Cut(line[1:], " ")
+		if !ok {
+			return ret, fmt.Errorf("Prefix without a command: %q", line)
+		}
+		ret.Prefix = prefix
+		line = rest
+	}
+
+	var trailing string
+	has_trailing := false
+	if i := strings.Index(line, " :"); i >= 0 {
+		trailing = line[i + 2:]
+		has_trailing = true
+		line = line[:i]
+	} else if strings.HasPrefix(line, ":") {
+		trailing = line[1:]
+		has_trailing = true
+		line = ""
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ret, fmt.Errorf("Missing command: %q", line)
+	}
+	ret.Command = fields[0]
+	ret.Params = fields[1:]
+	if has_trailing {
+		ret.Params = append(ret.Params, trailing)
+	}
+	return ret, nil
+}
+
+// https://ircv3.net/specs/extensions/message-tags#escaping-values
+func unescape_tag(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var ret strings.Builder
+	for i := 0; i < len(value); i += 1 {
+		if value[i] != '\\' || i + 1 >= len(value) {
+			ret.WriteByte(value[i])
+			continue
+		}
+		i += 1
+		switch value[i] {
+		case ':': ret.WriteByte(';')
+		case 's': ret.WriteByte(' ')
+		case 'r': ret.WriteByte('\r')
+		case 'n': ret.WriteByte('\n')
+		default: ret.WriteByte(value[i])
+		}
+	}
+	return ret.String()
+}
+
+// The nick part of "nick!user@host"
+func (self Line) Nick() string {
+	nick, _, _ := strings.Cut(self.Prefix, "!")
+	return nick
+}
+
+// Converts a PRIVMSG, returns false for every other command
+func (self Line) Message() (Message, bool) {
+	if self.Command != "PRIVMSG" || len(self.Params) < 2 {
+		return Message{}, false
+	}
+	text := self.Params[1]
+	is_action := false
+	if x, ok := strings.CutPrefix(text, "\x01ACTION "); ok {
+		text = strings.TrimSuffix(x, "\x01")
+		is_action = true
+	}
+
+	ret := Message{
+		Id: self.Tags["id"],
+		Time: time.Now(),
+		Channel: strings.TrimPrefix(self.Params[0], "#"),
+		User: self.Nick(),
+		User_id: self.Tags["user-id"],
+		Display_name: self.Tags["display-name"],
+		Color: self.Tags["color"],
+		Text: text,
+		Is_action: is_action,
+	}
+	if ms, err := strconv.ParseInt(self.Tags["tmi-sent-ts"], 10, 64); err == nil {
+		ret.Time = time.UnixMilli(ms)
+	}
+	if ret.Display_name == "" {
+		ret.Display_name = ret.User
+	}
+	return ret, true
+}
+
+// "#RRGGBB" as sent in the color tag
+func Parse_hex_color(s string) (uint8, uint8, uint8, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return 0, 0, 0, false
+	}
+	x, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return uint8(x >> 16), uint8(x >> 8), uint8(x), true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Client
+
+type Client struct {
+	Addr string
+	Tls  bool
+	// Set both to log in, which sending messages needs. Use Log_in once the
+	// client is shared with other goroutines.
+	Nick  string
+	Token string // OAuth access token with the chat:read and chat:edit scopes
+
+	conn      net.Conn
+	reader    *bufio.Reader
+	mutex     sync.Mutex // Say is called from other goroutines than Run, also guards Nick and Token
+	logged_in bool
+	pending   []Message // Sent, but not acknowledged yet
+	moderates map[string]bool // Channels we moderate, from USERSTATE
+}
+
+func New_client() *Client {
+	return &Client{ Addr: IRC_ADDR, Tls: true }
+}
+
+// Twitch drops anything longer
+const MAX_MESSAGE_LENGTH = 500
+
+var ErrAnonymous = fmt.Errorf("Log in with 'streamsurf login' to chat")
+
+// Sets who Connect logs in as
+func (self *Client) Log_in(nick string, token string) {
+	self.mutex.Lock()
+	self.Nick, self.Token = nick, token
+	self.mutex.Unlock()
+}
+
+// Logs in with Token, or anonymously which is enough to read chat
+func (self *Client) Connect(ctx context.Context) error {
+	var dialer net.Dialer
+	var conn net.Conn
+	var err error
+	if self.Tls {
+		tls_dialer := tls.Dialer{ NetDialer: &dialer }
+		conn, err = tls_dialer.DialContext(ctx, "tcp", self.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", self.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	self.mutex.Lock()
+	self.conn = conn
+	self.reader = bufio.NewReader(conn)
+	self.logged_in = self.Token != "" && self.Nick != ""
+	logged_in, nick, token := self.logged_in, self.Nick, self.Token
+	self.mutex.Unlock()
+
+	// Tags give us colours, display names and timestamps
+	if logged_in {
+		return self.Send("CAP REQ :twitch.tv/tags twitch.tv/commands", "PASS oauth:" + token, "NICK " + strings.ToLower(nick))
+	}
+	anonymous := fmt.Sprintf("justinfan%d", 10000 + rand.IntN(90000))
+	return self.Send("CAP REQ :twitch.tv/tags twitch.tv/commands", "NICK " + anonymous)
+}
+
+func (self *Client) Join(channel string) error {
+	return self.Send("JOIN #" + strings.ToLower(channel))
+}
+
+func (self *Client) Send(lines ...string) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.conn == nil {
+		return fmt.Errorf("Not connected to chat yet")
+	}
+	for _, line := range lines {
+		if strings.ContainsAny(line, "\r\n") {
+			return fmt.Errorf("IRC lines cannot contain newlines: %q", line)
+		}
+		if strings.HasPrefix(line, "PASS ") {
+			src.L_TRACE.Printf("IRC > PASS ***")
+		} else {
+			src.L_TRACE.Printf("IRC > %s", line)
+		}
+		if _, err := fmt.Fprintf(self.conn, "%s\r\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sends a chat message, "/me " makes it an action. Twitch does not echo our
+// messages back, so Run delivers it once Twitch acknowledges it with a
+// USERSTATE, or a notice if it was rejected (e.g. slow mode).
+func (self *Client) Say(channel string, text string) error {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\n", " "))
+	msg := Message{ Channel: strings.ToLower(channel), Text: text }
+	if x, ok := strings.CutPrefix(text, "/me "); ok {
+		msg.Text, msg.Is_action = x, true
+		text = "\x01ACTION " + x + "\x01"
+	} else if strings.HasPrefix(text, "/") {
+		return fmt.Errorf("Chat commands such as %q are not supported", strings.Fields(text)[0])
+	}
+	if msg.Text == "" {
+		return nil
+	} else if utf8.RuneCountInString(msg.Text) > MAX_MESSAGE_LENGTH {
+		return fmt.Errorf("Messages can be at most %d characters long", MAX_MESSAGE_LENGTH)
+	}
+
+	self.mutex.Lock()
+	if !self.logged_in {
+		self.mutex.Unlock()
+		return ErrAnonymous
+	}
+	msg.User = strings.ToLower(self.Nick)
+	self.pending = append(self.pending, msg)
+	self.mutex.Unlock()
+	return self.Send("PRIVMSG #" + msg.Channel + " :" + text)
+}
+
+// Pops the oldest message we sent to channel
+func (self *Client) acknowledge(channel string) (Message, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for i, msg := range self.pending {
+		if msg.Channel == channel {
+			self.pending = append(self.pending[:i], self.pending[i + 1:]...)
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
+
+// Reads until the connection closes or ctx is done, answering pings along
+// the way. Chat messages and notices are sent to messages.
+func (self *Client) Run(ctx context.Context, messages chan<- Message) error {
+	stop := context.AfterFunc(ctx, func() { self.conn.Close() })
+	defer stop()
+	emit := func(msg Message) bool {
+		select {
+		case messages <- msg:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for {
+		_ = self.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+		raw, err := self.reader.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		src.L_TRACE.Printf("IRC < %s", strings.TrimRight(raw, "\r\n"))
+		line, err := Parse_line(raw)
+		if err != nil {
+			src.L_DEBUG.Printf("%s", err)
+			continue
+		}
+
+		var msg Message
+		has_msg := false
+		switch line.Command {
+		case "PING":
+			if err := self.Send("PONG :" + strings.Join(line.Params, " ")); err != nil {
+				return err
+			}
+		case "RECONNECT":
+			return fmt.Errorf("Twitch asked us to reconnect")
+		case "PRIVMSG":
+			msg, has_msg = line.Message()
+		case "USERSTATE":
+			// Also sent on joining, when there is nothing to acknowledge
+			if len(line.Params) < 1 {
+				break
+			}
+			channel := strings.TrimPrefix(line.Params[0], "#")
+			self.set_moderator(channel, line.Tags)
+			if msg, has_msg = self.acknowledge(channel); has_msg {
+				msg.Id, msg.Time, msg.Color = line.Tags["id"], time.Now(), line.Tags["color"]
+				msg.Display_name = line.Tags["display-name"]
+				if msg.Display_name == "" {
+					msg.Display_name = msg.User
+				}
+			}
+		case "NOTICE":
+			if len(line.Params) < 2 {
+				break
+			}
+			text := line.Params[1]
+			if line.Params[0] == "*" && (strings.Contains(text, "authentication failed") || strings.Contains(text, "Improperly formatted auth")) {
+				return fmt.Errorf("Chat login failed, try 'streamsurf login' again: %s", text)
+			}
+			channel := strings.TrimPrefix(line.Params[0], "#")
+			// msg_* notices mean our message was not sent, e.g. msg_slowmode,
+			// msg_followersonly or msg_banned
+			if strings.HasPrefix(line.Tags["msg-id"], "msg_") {
+				_, _ = self.acknowledge(channel)
+			}
+			msg, has_msg = Notice(channel, text), true
+		case "ROOMSTATE":
+			if len(line.Params) < 1 {
+				break
+			}
+			if text := room_state(line.Tags); text != "" {
+				msg, has_msg = Notice(strings.TrimPrefix(line.Params[0], "#"), text), true
+			}
+		}
+		if has_msg && !emit(msg) {
+			return nil
+		}
+	}
+}
+
+// The broadcaster counts as a moderator of their own channel
+func (self *Client) set_moderator(channel string, tags map[string]string) {
+	is_mod := tags["mod"] == "1"
+	for _, badge := range strings.Split(tags["badges"], ",") {
+		name, _, _ := strings.Cut(badge, "/")
+		is_mod = is_mod || name == "broadcaster"
+	}
+	self.Set_moderator(channel, is_mod)
+}
+
+// Normally learnt from USERSTATE
+func (self *Client) Set_moderator(channel string, is_mod bool) {
+	channel = strings.ToLower(channel)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.moderates == nil {
+		self.moderates = make(map[string]bool)
+	}
+	self.moderates[channel] = is_mod
+}
+
+func (self *Client) Is_moderator(channel string) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.moderates[strings.ToLower(channel)]
+}
+
+func Notice(channel string, text string) Message {
+	return Message{ Time: time.Now(), Channel: channel, Text: text, Is_notice: true }
+}
+
+// Describes the chat modes of a ROOMSTATE. Joining sends every mode, of
+// which we only mention the ones that are on. Changes send one mode.
+func room_state(tags map[string]string) string {
+	is_join := len(tags) > 3
+	var ret []string
+	describe := func(tag string, off_value string, on string, off string) {
+		value, ok := tags[tag]
+		if !ok {
+			return
+		}
+		if value != off_value {
+			ret = append(ret, on)
+		} else if !is_join {
+			ret = append(ret, off)
+		}
+	}
+	describe("slow", "0", fmt.Sprintf("Slow mode (%ss)", tags["slow"]), "Slow mode off")
+	describe("followers-only", "-1", fmt.Sprintf("Followers-only (%s minutes)", tags["followers-only"]), "Followers-only off")
+	describe("subs-only", "0", "Subscribers-only", "Subscribers-only off")
+	describe("emote-only", "0", "Emote-only", "Emote-only off")
+	describe("r9k", "0", "Unique chat", "Unique chat off")
+	return strings.Join(ret, ", ")
+}
+
+func (self *Client) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.conn == nil {
+		return nil
+	}
+	return self.conn.Close()
+}
+
+// Connects, joins channel and reads until ctx is done
+func Listen(ctx context.Context, client *Client, channel string, messages chan<- Message) error {
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.Join(channel); err != nil {
+		return err
+	}
+	return client.Run(ctx, messages)
+}
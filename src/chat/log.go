@@ -0,0 +1,375 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+const (
+	FormatJsonl = "jsonl"
+	FormatTxt = "txt"
+	FormatAss = "ass"
+)
+
+var FORMATS = []string{FormatJsonl, FormatTxt, FormatAss}
+
+// Day files are split once they pass this size
+const DEFAULT_LOG_MAX_BYTES = 16 * 1024 * 1024
+
+// What we write per line of a .jsonl file
+type log_entry struct {
+	Id             string    `json:"id,omitempty"`
+	Time           time.Time `json:"time"`
+	Offset_seconds float64   `json:"offset_seconds,omitempty"`
+	Channel        string    `json:"channel"`
+	User           string    `json:"user"`
+	User_id        string    `json:"user_id,omitempty"`
+	Display_name   string    `json:"display_name"`
+	Color          string    `json:"color,omitempty"`
+	Text           string    `json:"text"`
+	Is_action      bool      `json:"is_action,omitempty"`
+}
+
+func Write_jsonl(output io.Writer, msg Message) error {
+	data, err := json.Marshal(log_entry{
+		Id: msg.Id,
+		Time: msg.Time.UTC(),
+		Offset_seconds: msg.Offset.Seconds(),
+		Channel: msg.Channel,
+		User: msg.User,
+		User_id: msg.User_id,
+		Display_name: msg.Display_name,
+		Color: msg.Color,
+		Text: msg.Text,
+		Is_action: msg.Is_action,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(output, "%s\n", data)
+	return err
+}
+
+func Read_jsonl(input io.Reader) ([]Message, error) {
+	var ret []Message
+	dec := json.NewDecoder(input)
+	for dec.More() {
+		var x log_entry
+		if err := dec.Decode(&x); err != nil {
+			return ret, err
+		}
+		ret = append(ret, Message{
+			Id: x.Id,
+			Time: x.Time,
+			Offset: time.Duration(x.Offset_seconds * float64(time.Second)),
+			Channel: x.Channel,
+			User: x.User,
+			User_id: x.User_id,
+			Display_name: x.Display_name,
+			Color: x.Color,
+			Text: x.Text,
+			Is_action: x.Is_action,
+		})
+	}
+	return ret, nil
+}
+
+// IRC client log style, e.g. "[2024-01-02 15:04:05] <Foo> hello"
+// VOD comments are stamped with their offset into the VOD instead. Control
+// characters are replaced, since these logs get cat'ed.
+func Write_txt(output io.Writer, msg Message) error {
+	var stamp string
+	if msg.Offset > 0 || msg.Time.IsZero() {
+		stamp = src.Format_clock(msg.Offset)
+	} else {
+		stamp = msg.Time.UTC().Format(time.DateTime)
+	}
+	var err error
+	if msg.Is_action {
+		_, err = fmt.Fprintf(output, "[%s] * %s %s\n", stamp, src.Printable(msg.Display_name), src.Printable(msg.Text))
+	} else {
+		_, err = fmt.Fprintf(output, "[%s] <%s> %s\n", stamp, src.Printable(msg.Display_name), src.Printable(msg.Text))
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Export
+
+// Where messages go, a file in one format or the logger's day files
+type Sink interface {
+	Write(msg Message) error
+	Close() error
+}
+
+type line_sink struct {
+	output io.Writer
+	write  func(io.Writer, Message) error
+}
+
+func (self line_sink) Write(msg Message) error {
+	return self.write(self.output, msg)
+}
+
+func (self line_sink) Close() error {
+	return nil
+}
+
+// Writes messages to output in one of FORMATS. Close has to be called, some
+// formats are only written out then.
+func New_exporter(output io.Writer, format string, ass AssOptions) (Sink, error) {
+	switch format {
+	case FormatJsonl: return line_sink{ output, Write_jsonl }, nil
+	case FormatTxt: return line_sink{ output, Write_txt }, nil
+	case FormatAss: return &ass_sink{ output: output, options: ass }, nil
+	default: return nil, fmt.Errorf("Unknown chat format %q, expected one of %s", format, strings.Join(FORMATS, ", "))
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Logger
+
+// Appends chat to <Dir>/<channel>/<YYYY-MM-DD>.jsonl and .txt, by the UTC
+// day the message was sent. Messages whose Id is already in the day's files
+// are skipped, so that fetching the same VOD again does not log it twice. A day that passes Max_bytes continues in
+// <YYYY-MM-DD>.1.jsonl and so on, and days older than Keep_days are deleted.
+type Logger struct {
+	Dir       string
+	Max_bytes int64
+	Keep_days int // 0 keeps everything
+
+	days map[string]*log_day // By channel directory
+}
+
+// Only the day a channel was last logged to is kept open, a message for
+// another day closes its files
+type log_day struct {
+	base  string // Path without the part number and extension
+	files map[string]*log_file // By extension
+	ids   map[string]bool // Of the messages in the day's files
+}
+
+// A line cut off by a crash only loses the ids after it
+func (self *log_day) read_ids() {
+	for part := 0; ; part += 1 {
+		fh, err := os.Open(part_path(self.base, ".jsonl", part))
+		if err != nil {
+			return
+		}
+		messages, _ := Read_jsonl(fh)
+		fh.Close()
+		for _, msg := range messages {
+			if msg.Id != "" {
+				self.ids[msg.Id] = true
+			}
+		}
+	}
+}
+
+type log_file struct {
+	fh   *os.File
+	part int
+	size int64
+}
+
+func New_logger(dir string) *Logger {
+	return &Logger{ Dir: dir, Max_bytes: DEFAULT_LOG_MAX_BYTES, days: make(map[string]*log_day) }
+}
+
+func (self *Logger) Write(msg Message) error {
+	if msg.Channel == "" || strings.ContainsAny(msg.Channel, `/\.`) {
+		return fmt.Errorf("Cannot log chat for channel %q", msg.Channel)
+	}
+	dir := filepath.Join(self.Dir, strings.ToLower(msg.Channel))
+	base := filepath.Join(dir, msg.Time.UTC().Format(time.DateOnly))
+	day, ok := self.days[dir]
+	var closed error
+	if !ok || day.base != base {
+		if ok {
+			closed = day.close()
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		day = &log_day{ base: base, files: make(map[string]*log_file), ids: make(map[string]bool) }
+		day.read_ids()
+		self.days[dir] = day
+	}
+	if msg.Id != "" && day.ids[msg.Id] {
+		return closed
+	}
+	if err := self.append(day, ".jsonl", msg, Write_jsonl); err != nil {
+		return err
+	}
+	if msg.Id != "" {
+		day.ids[msg.Id] = true
+	}
+	return errors.Join(closed, self.append(day, ".txt", msg, Write_txt))
+}
+
+func (self *Logger) append(day *log_day, ext string, msg Message, write func(io.Writer, Message) error) error {
+	base := day.base
+	file, ok := day.files[ext]
+	if !ok {
+		file = &log_file{}
+		// Continue in the last part that still has room
+		for {
+			info, err := os.Stat(part_path(base, ext, file.part))
+			if err != nil || !self.is_full(info.Size()) {
+				break
+			}
+			file.part += 1
+		}
+		day.files[ext] = file
+	}
+	if file.fh == nil {
+		fh, err := os.OpenFile(part_path(base, ext, file.part), os.O_CREATE | os.O_APPEND | os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		info, err := fh.Stat()
+		if err != nil {
+			fh.Close()
+			return err
+		}
+		file.fh, file.size = fh, info.Size()
+	}
+
+	counter := &counting_writer{ output: file.fh }
+	if err := write(counter, msg); err != nil {
+		return err
+	}
+	file.size += counter.count
+	if self.is_full(file.size) {
+		err := file.fh.Close()
+		file.fh = nil
+		file.part += 1
+		return err
+	}
+	return nil
+}
+
+func (self *Logger) is_full(size int64) bool {
+	return self.Max_bytes > 0 && size >= self.Max_bytes
+}
+
+func part_path(base string, ext string, part int) string {
+	if part == 0 {
+		return base + ext
+	}
+	return fmt.Sprintf("%s.%d%s", base, part, ext)
+}
+
+type counting_writer struct {
+	output io.Writer
+	count  int64
+}
+
+func (self *counting_writer) Write(p []byte) (int, error) {
+	n, err := self.output.Write(p)
+	self.count += int64(n)
+	return n, err
+}
+
+func (self *Logger) Close() error {
+	var first error
+	for dir, day := range self.days {
+		if err := day.close(); err != nil && first == nil {
+			first = err
+		}
+		delete(self.days, dir)
+	}
+	return first
+}
+
+func (self *log_day) close() error {
+	var first error
+	for _, file := range self.files {
+		if file.fh != nil {
+			if err := file.fh.Close(); err != nil && first == nil {
+				first = err
+			}
+			file.fh = nil
+		}
+	}
+	return first
+}
+
+// Every logged message of user in channel, oldest first
+func (self *Logger) User_messages(channel string, user string) ([]Message, error) {
+	var ret []Message
+	paths, err := filepath.Glob(filepath.Join(self.Dir, strings.ToLower(channel), "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		fh, err := os.Open(path)
+		if err != nil {
+			return ret, err
+		}
+		messages, err := Read_jsonl(fh)
+		fh.Close()
+		if err != nil {
+			return ret, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, msg := range messages {
+			if strings.EqualFold(msg.User, user) {
+				ret = append(ret, msg)
+			}
+		}
+	}
+	// Parts of a day do not sort by name, e.g. "2024-01-02.1.jsonl"
+	slices.SortStableFunc(ret, func(a, b Message) int {
+		return a.Time.Compare(b.Time)
+	})
+	return ret, nil
+}
+
+// Deletes day files older than Keep_days
+func (self *Logger) Prune(now time.Time) error {
+	if self.Keep_days <= 0 {
+		return nil
+	}
+	cutoff := now.UTC().AddDate(0, 0, -self.Keep_days).Format(time.DateOnly)
+	channels, err := os.ReadDir(self.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, channel := range channels {
+		if !channel.IsDir() {
+			continue
+		}
+		dir := filepath.Join(self.Dir, channel.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !slices.Contains([]string{".jsonl", ".txt"}, filepath.Ext(name)) || len(name) < len(time.DateOnly) {
+				continue
+			}
+			if _, err := time.Parse(time.DateOnly, name[:len(time.DateOnly)]); err != nil {
+				continue
+			}
+			if name[:len(time.DateOnly)] < cutoff {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+var FOO = Message{
+	Id: "c1",
+	Time: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+	Offset: 3 * time.Second,
+	Channel: "tsoding",
+	User: "foo",
+	Display_name: "Foo",
+	Color: "#FF4500",
+	Text: "hello Kappa",
+}
+
+func TestVideoId(t *testing.T) {
+	for _, x := range []string{"2000000000", "https://www.twitch.tv/videos/2000000000", "twitch.tv/videos/2000000000?t=1h2m"} {
+		id, err := Video_id(x)
+		a.AssertEqual(t, nil, err)
+		a.AssertEqual(t, "2000000000", id)
+	}
+	_, err := Video_id("https://www.twitch.tv/tsoding")
+	a.AssertEqual(t, true, err != nil)
+}
+
+func TestParseComments(t *testing.T) {
+	fh, err := os.Open(filepath.Join("testdata", "comments.json"))
+	a.AssertEqual(t, nil, err)
+	defer fh.Close()
+
+	messages, cursor, err := parse_comments(fh)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "eyJpZCI6IjMifQ==", cursor)
+	a.AssertEqual(t, 2, len(messages))
+	a.AssertEqual(t, FOO, messages[0])
+	a.AssertEqual(t, "bar", messages[1].Display_name)
+	a.AssertEqual(t, time.Hour + 3 * time.Second, messages[1].Offset)
+
+	_, _, err = parse_comments(strings.NewReader(`[{"data":{"video":null}}]`))
+	a.AssertEqual(t, true, err != nil)
+	_, _, err = parse_comments(strings.NewReader(`[{"errors":[{"message":"failed integrity check"}]}]`))
+	a.AssertEqual(t, true, err != nil)
+}
+
+func TestFormats(t *testing.T) {
+	var output strings.Builder
+	a.AssertEqual(t, nil, Write_jsonl(&output, FOO))
+	a.AssertEqual(t, `{"id":"c1","time":"2024-01-02T15:04:05Z","offset_seconds":3,"channel":"tsoding","user":"foo","display_name":"Foo","color":"#FF4500","text":"hello Kappa"}` + "\n", output.String())
+	messages, err := Read_jsonl(strings.NewReader(output.String()))
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, []Message{FOO}, messages)
+
+	output.Reset()
+	live := FOO
+	live.Offset = 0
+	a.AssertEqual(t, nil, Write_txt(&output, FOO))
+	a.AssertEqual(t, nil, Write_txt(&output, live))
+	live.Is_action = true
+	a.AssertEqual(t, nil, Write_txt(&output, live))
+	a.AssertEqual(t, "[0:00:03] <Foo> hello Kappa\n[2024-01-02 15:04:05] <Foo> hello Kappa\n[2024-01-02 15:04:05] * Foo hello Kappa\n", output.String())
+
+	// Escape sequences in chat must not reach the terminal of whoever cats the log
+	output.Reset()
+	evil := FOO
+	evil.Display_name, evil.Text = "Foo\x1B[2J", "hi\x1B]0;pwned\x07\u009b31m\nthere"
+	a.AssertEqual(t, nil, Write_txt(&output, evil))
+	a.AssertEqual(t, "[0:00:03] <Foo\uFFFD[2J> hi\uFFFD]0;pwned\uFFFD\uFFFD31m there\n", output.String())
+
+	_, err = New_exporter(&output, "srt", AssOptions{})
+	a.AssertEqual(t, true, err != nil)
+}
+
+func TestAss(t *testing.T) {
+	a.AssertEqual(t, "1:01:03.25", ass_time(time.Hour + time.Minute + 3250 * time.Millisecond))
+	a.AssertEqual(t, "\\{\\\u200Bb1\\}", ass_escape("{\\b1}"))
+
+	var output strings.Builder
+	exporter, err := New_exporter(&output, FormatAss, AssOptions{})
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, nil, exporter.Write(FOO))
+	a.AssertEqual(t, "", output.String())
+	a.AssertEqual(t, nil, exporter.Close())
+	a.AssertEqual(t, true, strings.HasPrefix(output.String(), "[Script Info]\n"))
+	a.AssertEqual(t, true, strings.HasSuffix(output.String(), "Dialogue: 0,0:00:03.00,0:00:18.00,Chat,,0,0,0,,{\\an7\\q2\\pos(1420,1025)}{\\c&H0045FF&}Foo{\\c}: hello Kappa\n"))
+}
+
+func TestAssWrap(t *testing.T) {
+	msg := Message{ Display_name: "Foo", Text: "aaa bbbb ccccccccccccc" }
+	a.AssertEqual(t, ass_line{ "Foo: aaa\\Nbbbb\\Ncccccccccc\\Nccc", 4 }, ass_wrap(msg, 10))
+	msg.Text = ""
+	a.AssertEqual(t, ass_line{ "Foo: ", 1 }, ass_wrap(msg, 10))
+	msg.Text, msg.Is_action = "waves", true
+	a.AssertEqual(t, ass_line{ "Foo waves", 1 }, ass_wrap(msg, 10))
+}
+
+// Older messages move up when a new one arrives and disappear after Lifetime
+func TestAssLayout(t *testing.T) {
+	first := Message{ Display_name: "a", Text: "first", Offset: 0 }
+	second := Message{ Display_name: "b", Text: "second", Offset: 5 * time.Second }
+	var output strings.Builder
+	a.AssertEqual(t, nil, Write_ass(&output, []Message{second, first}, AssOptions{ Lifetime: 10 * time.Second }))
+	_, events, _ := strings.Cut(output.String(), "Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+	a.AssertEqual(t, strings.Join([]string{
+		"Dialogue: 0,0:00:00.00,0:00:05.00,Chat,,0,0,0,,{\\an7\\q2\\pos(1420,1025)}a: first",
+		"Dialogue: 0,0:00:05.00,0:00:10.00,Chat,,0,0,0,,{\\an7\\q2\\pos(1420,990)}a: first",
+		"Dialogue: 0,0:00:05.00,0:00:15.00,Chat,,0,0,0,,{\\an7\\q2\\pos(1420,1025)}b: second",
+		"",
+	}, "\n"), events)
+}
+
+// A long VOD has to be laid out in about linear time
+func TestAssMany(t *testing.T) {
+	const count = 100_000
+	messages := make([]Message, count)
+	for i := range messages {
+		messages[i] = Message{ Display_name: "a", Text: fmt.Sprintf("m%d", i), Offset: time.Duration(i) * 4 * time.Second }
+	}
+	var output strings.Builder
+	start := time.Now()
+	a.AssertEqual(t, nil, Write_ass(&output, messages, AssOptions{}))
+	a.AssertEqual(t, true, time.Since(start) < 10 * time.Second)
+
+	// Every message is shown, most of them once per position in the panel
+	events := strings.Count(output.String(), "\nDialogue: ")
+	a.AssertEqual(t, true, events >= count)
+	a.AssertEqual(t, true, strings.Contains(output.String(), fmt.Sprintf("}a: m%d\n", count - 1)))
+}
+
+func TestLogger(t *testing.T) {
+	dir := t.TempDir()
+	logger := New_logger(dir)
+	logger.Max_bytes = 300
+
+	with_id := func(id string) Message {
+		ret := FOO
+		ret.Id = id
+		return ret
+	}
+	next_day := with_id("c3")
+	next_day.Time = next_day.Time.Add(24 * time.Hour)
+	for _, msg := range []Message{FOO, with_id("c2"), next_day} {
+		a.AssertEqual(t, nil, logger.Write(msg))
+	}
+	// The previous day is closed once the next one starts
+	a.AssertEqual(t, 1, len(logger.days))
+	day := logger.days[filepath.Join(dir, "tsoding")]
+	a.AssertEqual(t, filepath.Join(dir, "tsoding", "2024-01-03"), day.base)
+	a.AssertEqual(t, 2, len(day.files))
+	// The jsonl line is ~170 bytes, so the second one rolls over
+	a.AssertEqual(t, nil, logger.Write(with_id("c4")))
+	a.AssertEqual(t, nil, logger.Close())
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, "tsoding", name))
+		a.AssertEqual(t, nil, err)
+		return string(data)
+	}
+	a.AssertEqual(t, 2, strings.Count(read("2024-01-02.jsonl"), "\n"))
+	a.AssertEqual(t, 1, strings.Count(read("2024-01-02.1.jsonl"), "\n"))
+	a.AssertEqual(t, 3, strings.Count(read("2024-01-02.txt"), "\n"))
+	a.AssertEqual(t, 1, strings.Count(read("2024-01-03.jsonl"), "\n"))
+
+	// Reopening continues in the part that has room
+	logger = New_logger(dir)
+	logger.Max_bytes = 300
+	a.AssertEqual(t, nil, logger.Write(with_id("c5")))
+	a.AssertEqual(t, nil, logger.Close())
+	a.AssertEqual(t, 2, strings.Count(read("2024-01-02.1.jsonl"), "\n"))
+
+	// Fetching a VOD again does not log its comments twice
+	logger = New_logger(dir)
+	for _, id := range []string{"c1", "c4", "c5", "c5"} {
+		a.AssertEqual(t, nil, logger.Write(with_id(id)))
+	}
+	a.AssertEqual(t, nil, logger.Close())
+	a.AssertEqual(t, 2, strings.Count(read("2024-01-02.jsonl"), "\n"))
+	a.AssertEqual(t, 2, strings.Count(read("2024-01-02.1.jsonl"), "\n"))
+	a.AssertEqual(t, 4, strings.Count(read("2024-01-02.txt"), "\n"))
+
+	logger.Keep_days = 1
+	a.AssertEqual(t, nil, logger.Prune(time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)))
+	entries, _ := os.ReadDir(filepath.Join(dir, "tsoding"))
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	a.AssertEqual(t, []string{"2024-01-03.jsonl", "2024-01-03.txt"}, names)
+
+	bad := FOO
+	bad.Channel = "../etc"
+	a.AssertEqual(t, true, logger.Write(bad) != nil)
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+// What moderators can do to a chat. IRC commands such as /ban no longer
+// work, so this goes through Helix, and tests use a fake.
+type Moderator interface {
+	Timeout(ctx context.Context, broadcaster_id string, user_id string, duration time.Duration, reason string) error
+	Ban(ctx context.Context, broadcaster_id string, user_id string, reason string) error
+	Unban(ctx context.Context, broadcaster_id string, user_id string) error
+	Delete(ctx context.Context, broadcaster_id string, message_id string) error
+}
+
+// Twitch caps timeouts at two weeks
+const MAX_TIMEOUT = 14 * 24 * time.Hour
+
+// https://dev.twitch.tv/docs/api/reference/#ban-user
+// Needs the moderator:manage:banned_users and moderator:manage:chat_messages
+// scopes
+type Helix_moderator struct {
+	Client_id    string // The app the token was issued to
+	Moderator_id func(ctx context.Context) (string, error) // The logged in user
+}
+
+func (self Helix_moderator) Timeout(ctx context.Context, broadcaster_id string, user_id string, duration time.Duration, reason string) error {
+	seconds := int(duration.Seconds())
+	if seconds < 1 || duration > MAX_TIMEOUT {
+		return fmt.Errorf("Timeouts have to be between 1s and %s", MAX_TIMEOUT)
+	}
+	return self.ban(ctx, broadcaster_id, map[string]any{ "user_id": user_id, "duration": seconds, "reason": reason })
+}
+
+func (self Helix_moderator) Ban(ctx context.Context, broadcaster_id string, user_id string, reason string) error {
+	return self.ban(ctx, broadcaster_id, map[string]any{ "user_id": user_id, "reason": reason })
+}
+
+func (self Helix_moderator) ban(ctx context.Context, broadcaster_id string, data map[string]any) error {
+	body, err := json.Marshal(map[string]any{ "data": data })
+	if err != nil {
+		return err
+	}
+	return self.request(ctx, "POST", "/moderation/bans", broadcaster_id, nil, string(body))
+}
+
+func (self Helix_moderator) Unban(ctx context.Context, broadcaster_id string, user_id string) error {
+	return self.request(ctx, "DELETE", "/moderation/bans", broadcaster_id, url.Values{ "user_id": {user_id} }, "")
+}
+
+func (self Helix_moderator) Delete(ctx context.Context, broadcaster_id string, message_id string) error {
+	return self.request(ctx, "DELETE", "/moderation/chat", broadcaster_id, url.Values{ "message_id": {message_id} }, "")
+}
+
+func (self Helix_moderator) request(ctx context.Context, method string, path string, broadcaster_id string, query url.Values, body string) error {
+	if broadcaster_id == "" {
+		return fmt.Errorf("The channel has not loaded yet")
+	}
+	moderator_id, err := self.Moderator_id(ctx)
+	if err != nil {
+		return err
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("broadcaster_id", broadcaster_id)
+	query.Set("moderator_id", moderator_id)
+
+	headers := map[string]string{
+		"Authorization": "Bearer",
+		"Client-Id": self.Client_id,
+	}
+	if body != "" {
+		headers["Content-Type"] = "application/json"
+	}
+	// A unique cache id, so that local shims never replay a moderation action
+	resp, err := src.Request(ctx, method, headers, strings.NewReader(body), src.HELIX_URL + path + "?" + query.Encode(), fmt.Sprintf("helix-mod-%d", time.Now().UnixNano()))
+	if err != nil {
+		return err
+	}
+	return resp.Close()
+}
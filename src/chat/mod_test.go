@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+func TestHelixModerator(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, fmt.Sprintf("%s %s?%s %s %s", r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), body))
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+	defer func(x string) { src.HELIX_URL = x }(src.HELIX_URL)
+	defer func(x func(context.Context) (string, error)) { src.Authorizer = x }(src.Authorizer)
+	src.HELIX_URL = server.URL
+	src.Authorizer = func(context.Context) (string, error) { return "secret", nil }
+
+	mod := Helix_moderator{ Client_id: "app", Moderator_id: func(context.Context) (string, error) { return "9", nil } }
+	ctx := context.Background()
+	a.AssertEqual(t, nil, mod.Timeout(ctx, "1", "2", 10 * time.Minute, "spam"))
+	a.AssertEqual(t, nil, mod.Ban(ctx, "1", "2", ""))
+	a.AssertEqual(t, nil, mod.Unban(ctx, "1", "2"))
+	a.AssertEqual(t, nil, mod.Delete(ctx, "1", "abc"))
+	a.AssertEqual(t, []string{
+		`POST /moderation/bans?broadcaster_id=1&moderator_id=9 Bearer secret {"data":{"duration":600,"reason":"spam","user_id":"2"}}`,
+		`POST /moderation/bans?broadcaster_id=1&moderator_id=9 Bearer secret {"data":{"reason":"","user_id":"2"}}`,
+		`DELETE /moderation/bans?broadcaster_id=1&moderator_id=9&user_id=2 Bearer secret `,
+		`DELETE /moderation/chat?broadcaster_id=1&message_id=abc&moderator_id=9 Bearer secret `,
+	}, requests)
+
+	a.AssertEqual(t, true, mod.Timeout(ctx, "1", "2", 15 * 24 * time.Hour, "") != nil)
+	a.AssertEqual(t, true, mod.Ban(ctx, "", "2", "") != nil)
+	a.AssertEqual(t, 4, len(requests))
+}
+
+func TestUserMessages(t *testing.T) {
+	logger := New_logger(t.TempDir())
+	for i, user := range []string{"foo", "bar", "Foo"} {
+		msg := FOO
+		msg.User = user
+		msg.Time = FOO.Time.AddDate(0, 0, -i)
+		a.AssertEqual(t, nil, logger.Write(msg))
+	}
+	a.AssertEqual(t, nil, logger.Close())
+	messages, err := logger.User_messages("Tsoding", "foo")
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, 2, len(messages))
+	a.AssertEqual(t, true, messages[0].Time.Before(messages[1].Time))
+}
@@ -0,0 +1,193 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+var COMMENTS_GRAPHQL_QUERY = strings.ReplaceAll(`query comments($videoID: ID!, $cursor: Cursor) {
+    video(id: $videoID) {
+        id
+        owner {
+            login
+        }
+        comments(contentOffsetSeconds: 0, after: $cursor) {
+            edges {
+                cursor
+                node {
+                    id
+                    createdAt
+                    contentOffsetSeconds
+                    commenter {
+                        id
+                        login
+                        displayName
+                    }
+                    message {
+                        userColor
+                        fragments {
+                            text
+                        }
+                    }
+                }
+            }
+            pageInfo {
+                hasNextPage
+            }
+        }
+    }
+}`, "\n", "")
+
+// "https://www.twitch.tv/videos/123?t=1h" or "123" -> "123"
+func Video_id(vod string) (string, error) {
+	id := vod
+	if i := strings.Index(id, "/videos/"); i >= 0 {
+		id = id[i + len("/videos/"):]
+	}
+	if i := strings.IndexAny(id, "?#/"); i >= 0 {
+		id = id[:i]
+	}
+	if id == "" || strings.Trim(id, "0123456789") != "" {
+		return "", fmt.Errorf("%q is not a VOD URL or ID", vod)
+	}
+	return id, nil
+}
+
+type comments_page struct {
+	Data struct {
+		Video *struct {
+			Id    string `json:"id"`
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+			Comments struct {
+				Edges []struct {
+					Cursor string `json:"cursor"`
+					Node   struct {
+						Id             string    `json:"id"`
+						Created_at     time.Time `json:"createdAt"`
+						Offset_seconds int       `json:"contentOffsetSeconds"`
+						Commenter      *struct {
+							Id           string `json:"id"`
+							Login        string `json:"login"`
+							Display_name string `json:"displayName"`
+						} `json:"commenter"`
+						Message struct {
+							User_color string `json:"userColor"`
+							Fragments  []struct {
+								Text string `json:"text"`
+							} `json:"fragments"`
+						} `json:"message"`
+					} `json:"node"`
+				} `json:"edges"`
+				Page_info struct {
+					Has_next_page bool `json:"hasNextPage"`
+				} `json:"pageInfo"`
+			} `json:"comments"`
+		} `json:"video"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Parses one page of the comments query
+// Returns the cursor of the next page, empty on the last page
+func parse_comments(input io.Reader) ([]Message, string, error) {
+	var unmarshalled []comments_page
+	if err := json.NewDecoder(input).Decode(&unmarshalled); err != nil {
+		return nil, "", err
+	}
+	if len(unmarshalled) != 1 {
+		return nil, "", fmt.Errorf("Expected one GraphQL response, got %d", len(unmarshalled))
+	}
+	page := unmarshalled[0]
+	if len(page.Errors) > 0 {
+		return nil, "", fmt.Errorf("GraphQL: %s", page.Errors[0].Message)
+	}
+	video := page.Data.Video
+	if video == nil {
+		return nil, "", fmt.Errorf("VOD not found")
+	}
+
+	edges := video.Comments.Edges
+	messages := make([]Message, 0, len(edges))
+	for _, edge := range edges {
+		x := edge.Node
+		// Deleted accounts have no commenter
+		if x.Commenter == nil {
+			continue
+		}
+		var text strings.Builder
+		for _, f := range x.Message.Fragments {
+			text.WriteString(f.Text)
+		}
+		display := x.Commenter.Display_name
+		if display == "" {
+			display = x.Commenter.Login
+		}
+		messages = append(messages, Message{
+			Id: x.Id,
+			Time: x.Created_at,
+			Offset: time.Duration(x.Offset_seconds) * time.Second,
+			Channel: video.Owner.Login,
+			User: x.Commenter.Login,
+			User_id: x.Commenter.Id,
+			Display_name: display,
+			Color: x.Message.User_color,
+			Text: text.String(),
+		})
+	}
+
+	var cursor string
+	if video.Comments.Page_info.Has_next_page && len(edges) > 0 {
+		cursor = edges[len(edges) - 1].Cursor
+	}
+	return messages, cursor, nil
+}
+
+// Fetches every comment of a VOD in order, a page at a time
+func Fetch_comments(ctx context.Context, video_id string, on_page func([]Message) error) error {
+	cursor := ""
+	for page := 0; ; page += 1 {
+		variables := map[string]any{ "videoID": video_id, "cursor": nil }
+		if cursor != "" {
+			variables["cursor"] = cursor
+		}
+		query, err := json.Marshal([]map[string]any{{
+			"operationName": "comments",
+			"variables": variables,
+			"query": COMMENTS_GRAPHQL_QUERY,
+		}})
+		if err != nil {
+			return err
+		}
+
+		body, err := src.Request(ctx, "POST", map[string]string{
+			"Accept": "*/*",
+			"Content-Type": "text/plain; charset=UTF-8",
+			"Client-Id": src.CLIENT_ID,
+		}, strings.NewReader(string(query)), "https://gql.twitch.tv/gql#origin=twilight", fmt.Sprintf("graph-%s-comments-%d", video_id, page))
+		if err != nil {
+			return err
+		}
+		messages, next, err := parse_comments(body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+		if err := on_page(messages); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
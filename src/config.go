@@ -0,0 +1,108 @@
+package src
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// User settings, read from config.json next to the other persisted files
+// Every field is optional, the zero value means "use the default"
+type Config struct {
+	// Screen name ("global", "follow", "channel", "queue", "chat", "search", "category") -> key sequence -> action
+	// e.g. {"global": {"<C-n>": "select_next", "q": "none"}}
+	Keys map[string]map[string]string `json:"keys"`
+	Chat ChatConfig `json:"chat"`
+	Auth AuthConfig `json:"auth"`
+	Follows FollowsConfig `json:"follows"`
+	// Categories (games) to browse the top live streams of, by the name shown
+	// on twitch.tv, e.g. ["Software and Game Development", "Factorio"]
+	Categories []string `json:"categories"`
+	Serve ServeConfig `json:"serve"`
+}
+
+type ServeConfig struct {
+	// host:port for "serve", defaults to 127.0.0.1:8420. --listen overrides it.
+	Listen string `json:"listen"`
+	// How often the followed channels are refreshed, 0 for 5 minutes
+	Refresh_seconds int `json:"refresh_seconds"`
+}
+
+type FollowsConfig struct {
+	// How "channels sync" merges Twitch follows into the channel list, one of
+	// SYNC_MODES, defaults to "union"
+	Sync_mode string `json:"sync_mode"`
+}
+
+type AuthConfig struct {
+	// The app that logs in, defaults to CLIENT_ID. Has to allow the device
+	// code flow as a public client.
+	Client_id string `json:"client_id"`
+	// Defaults to auth.DEFAULT_SCOPES
+	Scopes []string `json:"scopes"`
+	// Keeps the token in a keyring instead of token.json, by running commands
+	// that read the token on stdin (store) or print it (lookup)
+	// e.g. {"store": ["secret-tool", "store", "--label=streamsurf", "service", "streamsurf"],
+	//       "lookup": ["secret-tool", "lookup", "service", "streamsurf"],
+	//       "clear": ["secret-tool", "clear", "service", "streamsurf"]}
+	Keyring *KeyringConfig `json:"keyring"`
+}
+
+type KeyringConfig struct {
+	Store  []string `json:"store"`
+	Lookup []string `json:"lookup"`
+	Clear  []string `json:"clear"`
+}
+
+type ChatConfig struct {
+	// Messages kept per chat, 0 for the default
+	Scrollback int `json:"scrollback"`
+	// e.g. [{"user": "tsoding"}, {"keyword": "streamsurf"}, {"regex": "(?i)^!song"}]
+	Highlight []ChatRule `json:"highlight"`
+	// e.g. [{"user": "nightbot"}, {"user": "streamelements"}]
+	Hide []ChatRule `json:"hide"`
+	// How long the timeout action lasts, 0 for 10 minutes
+	Timeout_seconds int `json:"timeout_seconds"`
+
+	// Opt-in, writes live chat and fetched VOD comments to per-channel day files
+	Log bool `json:"log"`
+	// Defaults to "logs" in the config directory
+	Log_dir string `json:"log_dir"`
+	// Day files are split past this size, 0 for the default
+	Log_max_mb int `json:"log_max_mb"`
+	// Older day files are deleted on startup, 0 keeps everything
+	Log_keep_days int `json:"log_keep_days"`
+
+	// VOD chat rendered as subtitles, 0 for the defaults. The panel width is
+	// out of a 1920 pixel wide video.
+	Subtitle_font_size int `json:"subtitle_font_size"`
+	Subtitle_lifetime_seconds int `json:"subtitle_lifetime_seconds"`
+	Subtitle_panel_width int `json:"subtitle_panel_width"`
+}
+
+// Every field that is set has to match. User is compared case-insensitively
+// against the login and display name, and Keyword against whole words.
+type ChatRule struct {
+	User    string `json:"user"`
+	Keyword string `json:"keyword"`
+	Regex   string `json:"regex"`
+}
+
+// A missing file is treated as an empty config
+func Read_config(path string) (Config, error) {
+	var ret Config
+	fh, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, nil
+		}
+		return ret, err
+	}
+	defer fh.Close()
+
+	dec := json.NewDecoder(fh)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ret); err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
@@ -1,6 +1,8 @@
 package src
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -14,6 +16,14 @@ var USER_AGENT = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (
 const RING_QUEUE_SIZE int = 10000
 const PAGE_SIZE = 20
 
+// The video cache has to fit a page of videos of every followed channel
+func Check_channel_limit(count int) error {
+	if count * PAGE_SIZE > RING_QUEUE_SIZE {
+		return fmt.Errorf("Cannot follow more than %d channels", RING_QUEUE_SIZE / PAGE_SIZE)
+	}
+	return nil
+}
+
 const ANSI_FG_RED = "\x1b[31m"
 const ANSI_FG_GREEN = "\x1b[32m"
 const ANSI_FG_CYAN = "\x1b[36m"
@@ -25,6 +35,39 @@ type VideoPacket struct {
 	Err  error
 }
 
+// Video.Broadcast_type of VODs, as Twitch names them
+const (
+	BroadcastArchive = "ARCHIVE" // Past broadcasts
+	BroadcastHighlight = "HIGHLIGHT"
+	BroadcastUpload = "UPLOAD"
+	BroadcastPremiere = "PAST_PREMIERE"
+)
+
+// The Broadcast* constants by their names on the command line, e.g. --type highlight
+var BROADCAST_TYPE_NAMES = map[string]string{
+	"archive": BroadcastArchive,
+	"highlight": BroadcastHighlight,
+	"upload": BroadcastUpload,
+	"premiere": BroadcastPremiere,
+}
+
+// Empty name for every type
+func Parse_broadcast_type(name string) (string, error) {
+	if name == "" || name == "all" {
+		return "", nil
+	}
+	if ty, ok := BROADCAST_TYPE_NAMES[strings.ToLower(name)]; ok {
+		return ty, nil
+	}
+	return "", fmt.Errorf("Unknown broadcast type %q, expected one of all, archive, highlight, upload or premiere", name)
+}
+
+// Parts of a VOD where the audio was muted for copyrighted music
+type MutedSegment struct {
+	Offset   time.Duration
+	Duration time.Duration
+}
+
 type Chapter struct {
 	Name     string
 	Position time.Duration
@@ -33,12 +76,23 @@ type Chapter struct {
 type Video struct {
 	Title         string
 	Channel       string
+	Channel_id    string // Twitch user ID, what third-party emote APIs key on
 	Thumbnail_URL []string
+	Avatar_URL    string
 	Start_time    time.Time
 	Duration      time.Duration
 	Is_live       bool
 	Url           string
 	Chapters      []Chapter
+	Viewers       int // Only for live streams
+
+	Id             string // Of the VOD, or of the stream while live
+	Broadcast_type string // One of the Broadcast* constants, empty for live streams
+	Game           string // The category it was (or is being) streamed in
+	Display_name   string // Channel as the streamer capitalises it, may not be ASCII
+	Tags           []string
+	Language       string // e.g. "en", empty if unknown
+	Muted_segments []MutedSegment
 }
 
 func Sort_videos_by_latest(a, b Video) int {
@@ -61,6 +115,10 @@ func Sort_videos_by_latest(a, b Video) int {
 	}
 }
 
+// Most viewers first, for live streams of a category
+func Sort_videos_by_viewers(a, b Video) int {
+	return b.Viewers - a.Viewers
+}
 
 //func Is_start_time_before(a, b Video) int {
 //	if a.Start_time.Before(b.Start_time) {
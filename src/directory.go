@@ -0,0 +1,155 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The directory of a category (game), i.e. its top live streams
+
+const DIRECTORY_PAGE_SIZE = 30
+
+var DIRECTORY_GRAPHQL_QUERY = strings.ReplaceAll(`query directory($name: String!, $limit: Int) {
+    game(name: $name) {
+        id
+        name
+        displayName
+        streams(first: $limit, options: {sort: VIEWER_COUNT}) {
+            edges {
+                node {
+                    id
+                    title
+                    viewersCount
+                    createdAt
+                    previewImageURL(width: 320, height: 180)
+                    freeformTags {
+                        name
+                    }
+                    broadcaster {
+                        id
+                        login
+                        displayName
+                        profileImageURL(width: 50)
+                        broadcastSettings {
+                            language
+                        }
+                    }
+                }
+            }
+        }
+    }
+}`, "\n", " ")
+
+// Most viewers first. category is the name as shown on twitch.tv, e.g.
+// "Software and Game Development", and is matched case-insensitively.
+func Graph_directory(ctx context.Context, category string) ([]Video, error) {
+	body, err := json.Marshal([]map[string]any{{
+		"operationName": "directory",
+		"variables": map[string]any{ "name": category, "limit": DIRECTORY_PAGE_SIZE },
+		"query": DIRECTORY_GRAPHQL_QUERY,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := Request(ctx, "POST", map[string]string{
+		"Accept": "*/*",
+		"Content-Type": "text/plain; charset=UTF-8",
+		"Client-Id": CLIENT_ID,
+	}, strings.NewReader(string(body)), "https://gql.twitch.tv/gql#origin=twilight", "graph-directory-" + url.QueryEscape(category))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	return parse_directory(resp, category, time.Now())
+}
+
+func parse_directory(input io.Reader, category string, now time.Time) ([]Video, error) {
+	type Query struct {
+		Data struct {
+			Game *struct {
+				Name    string `json:"name"`
+				Streams struct {
+					Edges []struct {
+						Node struct {
+							Id            string    `json:"id"`
+							Title         string    `json:"title"`
+							Viewers_count int       `json:"viewersCount"`
+							Created_at    time.Time `json:"createdAt"`
+							Preview_URL   string    `json:"previewImageURL"`
+							Freeform_tags []struct {
+								Name string `json:"name"`
+							} `json:"freeformTags"`
+							Broadcaster *struct {
+								Id           string `json:"id"`
+								Login        string `json:"login"`
+								Display_name string `json:"displayName"`
+								Profile_URL  string `json:"profileImageURL"`
+								Broadcast_settings struct {
+									Language string `json:"language"`
+								} `json:"broadcastSettings"`
+							} `json:"broadcaster"`
+						} `json:"node"`
+					} `json:"edges"`
+				} `json:"streams"`
+			} `json:"game"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	var unmarshalled []Query
+	if err := json.NewDecoder(input).Decode(&unmarshalled); err != nil {
+		return nil, err
+	}
+	if len(unmarshalled) == 0 {
+		return nil, fmt.Errorf("Empty directory response")
+	}
+	if errs := unmarshalled[0].Errors; len(errs) > 0 {
+		return nil, fmt.Errorf("Directory of %s failed: %s", category, errs[0].Message)
+	}
+	game := unmarshalled[0].Data.Game
+	if game == nil {
+		return nil, fmt.Errorf("There is no category called %q", category)
+	}
+
+	ret := make([]Video, 0, len(game.Streams.Edges))
+	for _, edge := range game.Streams.Edges {
+		x := edge.Node
+		// Banned channels stay in the directory for a bit
+		if x.Broadcaster == nil {
+			continue
+		}
+		var tags []string
+		for _, tag := range x.Freeform_tags {
+			tags = append(tags, tag.Name)
+		}
+		ret = append(ret, Video{
+			Title: x.Title,
+			Channel: x.Broadcaster.Login,
+			Channel_id: x.Broadcaster.Id,
+			Thumbnail_URL: []string{x.Preview_URL},
+			Avatar_URL: x.Broadcaster.Profile_URL,
+			Start_time: x.Created_at,
+			Duration: now.Sub(x.Created_at),
+			Is_live: true,
+			Url: "https://www.twitch.tv/" + x.Broadcaster.Login,
+			Chapters: []Chapter{Chapter{game.Name, 0}},
+			Viewers: x.Viewers_count,
+			Id: x.Id,
+			Game: game.Name,
+			Display_name: x.Broadcaster.Display_name,
+			Tags: tags,
+			Language: strings.ToLower(x.Broadcaster.Broadcast_settings.Language),
+		})
+	}
+	slices.SortStableFunc(ret, Sort_videos_by_viewers)
+	return ret, nil
+}
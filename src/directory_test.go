@@ -0,0 +1,47 @@
+package src
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run Directory
+
+func TestParseDirectory(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	videos, err := parse_directory(strings.NewReader(`[{"data":{"game":{"id":"1","name":"Factorio","displayName":"Factorio","streams":{"edges":[
+		{"node":{"id":"10","title":"Megabase","viewersCount":120,"createdAt":"2024-05-01T10:00:00Z","previewImageURL":"https://example.com/a.jpg","freeformTags":[{"name":"English"},{"name":"Chill"}],"broadcaster":{"id":"5","login":"small","displayName":"Small","profileImageURL":"https://example.com/small.png","broadcastSettings":{"language":"EN"}}}},
+		{"node":{"id":"11","title":"Gone","viewersCount":9000,"createdAt":"2024-05-01T10:00:00Z","broadcaster":null}},
+		{"node":{"id":"12","title":"Speedrun","viewersCount":4000,"createdAt":"2024-05-01T11:30:00Z","previewImageURL":"https://example.com/b.jpg","broadcaster":{"id":"6","login":"big","displayName":"Big","profileImageURL":""}}}
+	]}}}}]`), "factorio", now)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, 2, len(videos))
+
+	a.AssertEqual(t, "big", videos[0].Channel)
+	a.AssertEqual(t, 4000, videos[0].Viewers)
+	a.AssertEqual(t, 30 * time.Minute, videos[0].Duration)
+	a.AssertEqual(t, Video{
+		Title: "Megabase",
+		Channel: "small",
+		Channel_id: "5",
+		Thumbnail_URL: []string{"https://example.com/a.jpg"},
+		Avatar_URL: "https://example.com/small.png",
+		Start_time: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Duration: 2 * time.Hour,
+		Is_live: true,
+		Url: "https://www.twitch.tv/small",
+		Chapters: []Chapter{{ "Factorio", 0 }},
+		Viewers: 120,
+		Id: "10",
+		Game: "Factorio",
+		Display_name: "Small",
+		Tags: []string{"English", "Chill"},
+		Language: "en",
+	}, videos[1])
+
+	_, err = parse_directory(strings.NewReader(`[{"data":{"game":null}}]`), "Nope", now)
+	a.AssertEqual(t, `There is no category called "Nope"`, err.Error())
+}
@@ -0,0 +1,212 @@
+// Third-party emotes (BetterTTV, FrankerFaceZ, 7TV) for rendering chat
+package emotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+//run: go test
+
+const (
+	ProviderFFZ int = iota
+	ProviderBTTV
+	Provider7TV
+	PROVIDER_COUNT
+)
+
+var PROVIDER_NAMES = [PROVIDER_COUNT]string{"ffz", "bttv", "7tv"}
+
+// Emote sets change rarely, so a day old set is good enough
+const CACHE_EXPIRY = 24 * time.Hour
+
+type Emote struct {
+	Code     string
+	Url      string // Smallest image, we only ever draw at text size
+	Provider int
+	Animated bool
+}
+
+// Emote codes are case-sensitive and matched against whole words
+type EmoteSet map[string]Emote
+
+type Registry struct {
+	// Where downloaded sets live. Empty disables the disk cache.
+	Cache_dir string
+	Expiry    time.Duration
+	// Swapped out in tests to read fixtures
+	Fetch func(url string) (io.ReadCloser, error)
+
+	mutex    sync.RWMutex
+	global   EmoteSet
+	channels map[string]EmoteSet // Keyed by Twitch user ID
+}
+
+func New_registry() *Registry {
+	var cache_dir string
+	if x, err := os.UserCacheDir(); err != nil {
+		src.L_DEBUG.Printf("Not caching emotes: %s", err)
+	} else {
+		cache_dir = filepath.Join(x, "streamsurf", "emotes")
+	}
+	return &Registry{
+		Cache_dir: cache_dir,
+		Expiry: CACHE_EXPIRY,
+		Fetch: fetch,
+		global: make(EmoteSet),
+		channels: make(map[string]EmoteSet),
+	}
+}
+
+func fetch(url string) (io.ReadCloser, error) {
+	return src.Request(context.TODO(), "GET", nil, nil, url, "emotes-" + filepath.Base(url))
+}
+
+// Loads the global sets of every provider
+// A provider that fails is skipped, the others are still loaded
+func (self *Registry) Load_global() error {
+	set, err := self.load("global", global_sources())
+	self.mutex.Lock()
+	self.global = set
+	self.mutex.Unlock()
+	return err
+}
+
+// Loads the sets of every provider for channel_id (see Video.Channel_id)
+func (self *Registry) Load_channel(channel_id string) error {
+	if channel_id == "" {
+		return fmt.Errorf("Cannot load emotes without a channel ID")
+	}
+	set, err := self.load(channel_id, channel_sources(channel_id))
+	self.mutex.Lock()
+	self.channels[channel_id] = set
+	self.mutex.Unlock()
+	return err
+}
+
+// Channel emotes take precedence over global ones. Between providers, later
+// ones in the Provider* order win, matching what the browser extensions do.
+func (self *Registry) Lookup(channel_id string, code string) (Emote, bool) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	if e, ok := self.channels[channel_id][code]; ok {
+		return e, true
+	}
+	e, ok := self.global[code]
+	return e, ok
+}
+
+func (self *Registry) load(key string, sources [PROVIDER_COUNT]source) (EmoteSet, error) {
+	set := make(EmoteSet)
+	var errs []error
+	for provider, x := range sources {
+		emotes, err := self.load_source(key, provider, x)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s emotes for %s: %w", PROVIDER_NAMES[provider], key, err))
+			continue
+		}
+		for _, e := range emotes {
+			set[e.Code] = e
+		}
+	}
+	return set, errors.Join(errs...)
+}
+
+// Reads from the disk cache if fresh, otherwise downloads. If the download
+// fails we would rather show stale emotes than none.
+func (self *Registry) load_source(key string, provider int, x source) ([]Emote, error) {
+	var path string
+	var stale []byte
+	if self.Cache_dir != "" {
+		path = filepath.Join(self.Cache_dir, PROVIDER_NAMES[provider] + "-" + key + ".json")
+		if info, err := os.Stat(path); err == nil {
+			if data, err := os.ReadFile(path); err == nil {
+				if time.Since(info.ModTime()) < self.Expiry {
+					if emotes, err := x.parse(data); err == nil {
+						return emotes, nil
+					}
+				}
+				stale = data
+			}
+		}
+	}
+
+	data, err := func() ([]byte, error) {
+		body, err := self.Fetch(x.url)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}()
+	if err != nil {
+		if stale != nil {
+			src.L_DEBUG.Printf("Using stale %s: %s", path, err)
+			return x.parse(stale)
+		}
+		return nil, err
+	}
+
+	emotes, err := x.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		// A failed cache write only costs us a redownload
+		if err := os.MkdirAll(self.Cache_dir, 0o755); err != nil {
+			src.L_DEBUG.Printf("Could not cache %q: %s", path, err)
+		} else if err := os.WriteFile(path, data, 0o644); err != nil {
+			src.L_DEBUG.Printf("Could not cache %q: %s", path, err)
+		}
+	}
+	return emotes, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Tokenizer
+
+// A run of chat text, or a single emote if Emote is non-nil
+type Span struct {
+	Text  string
+	Emote *Emote
+}
+
+// Splits a chat message into text and emote spans. Whitespace stays in the
+// text spans, so joining every Text gives back the message.
+func (self *Registry) Tokenize(channel_id string, message string) []Span {
+	var spans []Span
+	text_start := 0
+	for i := 0; i < len(message); {
+		if message[i] == ' ' {
+			i += 1
+			continue
+		}
+		end := strings.IndexByte(message[i:], ' ')
+		if end == -1 {
+			end = len(message)
+		} else {
+			end += i
+		}
+		if e, ok := self.Lookup(channel_id, message[i:end]); ok {
+			if text_start < i {
+				spans = append(spans, Span{Text: message[text_start:i]})
+			}
+			spans = append(spans, Span{Text: message[i:end], Emote: &e})
+			text_start = end
+		}
+		i = end
+	}
+	if text_start < len(message) {
+		spans = append(spans, Span{Text: message[text_start:]})
+	}
+	return spans
+}
@@ -0,0 +1,123 @@
+package emotes
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+var FIXTURES = map[string]string{
+	"https://api.betterttv.net/3/cached/emotes/global":     "bttv_global.json",
+	"https://api.betterttv.net/3/cached/users/twitch/12345": "bttv_channel.json",
+	"https://api.frankerfacez.com/v1/set/global":           "ffz_global.json",
+	"https://api.frankerfacez.com/v1/room/id/12345":        "ffz_room.json",
+	"https://7tv.io/v3/emote-sets/global":                  "7tv_global.json",
+	"https://7tv.io/v3/users/twitch/12345":                  "7tv_user.json",
+}
+
+func fixture_registry(t *testing.T) (*Registry, *int) {
+	fetches := 0
+	registry := New_registry()
+	registry.Cache_dir = t.TempDir()
+	registry.Fetch = func(url string) (io.ReadCloser, error) {
+		fetches += 1
+		if name, ok := FIXTURES[url]; ok {
+			return os.Open(filepath.Join("testdata", name))
+		}
+		return nil, fmt.Errorf("No fixture for %s", url)
+	}
+	return registry, &fetches
+}
+
+func TestLoad(t *testing.T) {
+	registry, _ := fixture_registry(t)
+	a.AssertEqual(t, nil, registry.Load_global())
+	a.AssertEqual(t, nil, registry.Load_channel("12345"))
+
+	e, ok := registry.Lookup("12345", "catJAM")
+	a.AssertEqual(t, true, ok)
+	// Channel FFZ beats global BTTV
+	a.AssertEqual(t, Emote{"catJAM", "https://cdn.frankerfacez.com/emote/501/1", ProviderFFZ, false}, e)
+	e, _ = registry.Lookup("", "catJAM")
+	a.AssertEqual(t, Emote{"catJAM", "https://cdn.betterttv.net/emote/566ca1a365dbbdab32ec055b/1x", ProviderBTTV, true}, e)
+
+	// 7TV aliases beat BTTV, and we prefer webp
+	e, _ = registry.Lookup("12345", "D:")
+	a.AssertEqual(t, Emote{"D:", "https://cdn.7tv.app/emote/603cb219c20d020014423c34/1x.webp", Provider7TV, false}, e)
+
+	e, _ = registry.Lookup("12345", "ZreknarF")
+	a.AssertEqual(t, "https://cdn.frankerfacez.com/emote/9/1", e.Url)
+	e, _ = registry.Lookup("12345", "borkFFZ")
+	a.AssertEqual(t, true, e.Animated)
+	_, ok = registry.Lookup("12345", "borkDance")
+	a.AssertEqual(t, true, ok)
+	_, ok = registry.Lookup("12345", "Clap")
+	a.AssertEqual(t, true, ok)
+	_, ok = registry.Lookup("12345", "peepoHey")
+	a.AssertEqual(t, true, ok)
+
+	_, ok = registry.Lookup("12345", "Hidden")
+	a.AssertEqual(t, false, ok)
+	_, ok = registry.Lookup("", "borkDance")
+	a.AssertEqual(t, false, ok)
+}
+
+func TestLoadPartial(t *testing.T) {
+	registry, _ := fixture_registry(t)
+	// No fixtures for this channel, but the globals are still there
+	a.AssertEqual(t, true, registry.Load_channel("999") != nil)
+	a.AssertEqual(t, nil, registry.Load_global())
+	_, ok := registry.Lookup("999", ":tf:")
+	a.AssertEqual(t, true, ok)
+}
+
+func TestCache(t *testing.T) {
+	registry, fetches := fixture_registry(t)
+	a.AssertEqual(t, nil, registry.Load_global())
+	a.AssertEqual(t, 3, *fetches)
+	a.AssertEqual(t, nil, registry.Load_global())
+	a.AssertEqual(t, 3, *fetches)
+
+	// Expired, and the network is down: fall back to the stale copy
+	registry.Expiry = 0
+	fetch := registry.Fetch
+	registry.Fetch = func(url string) (io.ReadCloser, error) {
+		*fetches += 1
+		return nil, fmt.Errorf("Offline")
+	}
+	a.AssertEqual(t, nil, registry.Load_global())
+	a.AssertEqual(t, 6, *fetches)
+	_, ok := registry.Lookup("", "CatBag")
+	a.AssertEqual(t, true, ok)
+
+	registry.Fetch = fetch
+	registry.Expiry = time.Hour
+	a.AssertEqual(t, nil, registry.Load_global())
+	a.AssertEqual(t, 6, *fetches)
+}
+
+func TestTokenize(t *testing.T) {
+	registry, _ := fixture_registry(t)
+	a.AssertEqual(t, nil, registry.Load_global())
+	a.AssertEqual(t, nil, registry.Load_channel("12345"))
+
+	tf, _ := registry.Lookup("12345", ":tf:")
+	bork, _ := registry.Lookup("12345", "borkDance")
+	a.AssertEqual(t, []Span{
+		{Text: "hello "},
+		{Text: ":tf:", Emote: &tf},
+		{Text: " world  "},
+		{Text: "borkDance", Emote: &bork},
+		{Text: " "},
+		{Text: "borkDance", Emote: &bork},
+	}, registry.Tokenize("12345", "hello :tf: world  borkDance borkDance"))
+
+	// Codes only match whole words
+	a.AssertEqual(t, []Span{{Text: "x:tf: borkDancing"}}, registry.Tokenize("12345", "x:tf: borkDancing"))
+	a.AssertEqual(t, []Span(nil), registry.Tokenize("12345", ""))
+}
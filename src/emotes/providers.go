@@ -0,0 +1,198 @@
+package emotes
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Each provider has one endpoint for global emotes and one per channel
+type source struct {
+	url   string
+	parse func(data []byte) ([]Emote, error)
+}
+
+func global_sources() [PROVIDER_COUNT]source {
+	var ret [PROVIDER_COUNT]source
+	ret[ProviderFFZ] = source{"https://api.frankerfacez.com/v1/set/global", parse_ffz}
+	ret[ProviderBTTV] = source{"https://api.betterttv.net/3/cached/emotes/global", parse_bttv_global}
+	ret[Provider7TV] = source{"https://7tv.io/v3/emote-sets/global", parse_7tv_set}
+	return ret
+}
+
+func channel_sources(channel_id string) [PROVIDER_COUNT]source {
+	var ret [PROVIDER_COUNT]source
+	ret[ProviderFFZ] = source{"https://api.frankerfacez.com/v1/room/id/" + channel_id, parse_ffz}
+	ret[ProviderBTTV] = source{"https://api.betterttv.net/3/cached/users/twitch/" + channel_id, parse_bttv_channel}
+	ret[Provider7TV] = source{"https://7tv.io/v3/users/twitch/" + channel_id, parse_7tv_user}
+	return ret
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// BetterTTV
+
+type bttv_emote struct {
+	Id       string `json:"id"`
+	Code     string `json:"code"`
+	Animated bool   `json:"animated"`
+}
+
+func (self bttv_emote) emote() Emote {
+	return Emote{
+		Code: self.Code,
+		Url: "https://cdn.betterttv.net/emote/" + self.Id + "/1x",
+		Provider: ProviderBTTV,
+		Animated: self.Animated,
+	}
+}
+
+func parse_bttv_global(data []byte) ([]Emote, error) {
+	var unmarshalled []bttv_emote
+	if err := json.Unmarshal(data, &unmarshalled); err != nil {
+		return nil, err
+	}
+	ret := make([]Emote, len(unmarshalled))
+	for i, x := range unmarshalled {
+		ret[i] = x.emote()
+	}
+	return ret, nil
+}
+
+func parse_bttv_channel(data []byte) ([]Emote, error) {
+	var unmarshalled struct {
+		Channel_emotes []bttv_emote `json:"channelEmotes"`
+		Shared_emotes  []bttv_emote `json:"sharedEmotes"`
+	}
+	if err := json.Unmarshal(data, &unmarshalled); err != nil {
+		return nil, err
+	}
+	ret := make([]Emote, 0, len(unmarshalled.Channel_emotes) + len(unmarshalled.Shared_emotes))
+	for _, x := range unmarshalled.Shared_emotes {
+		ret = append(ret, x.emote())
+	}
+	// Channel emotes override shared ones with the same code
+	for _, x := range unmarshalled.Channel_emotes {
+		ret = append(ret, x.emote())
+	}
+	return ret, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// FrankerFaceZ
+
+// The global and room endpoints share a shape: a list of sets, and which of
+// them apply (default_sets for global, room.set for a channel)
+func parse_ffz(data []byte) ([]Emote, error) {
+	var unmarshalled struct {
+		Default_sets []int `json:"default_sets"`
+		Room *struct {
+			Set int `json:"set"`
+		} `json:"room"`
+		Sets map[string]struct {
+			Emoticons []struct {
+				Id       int               `json:"id"`
+				Name     string            `json:"name"`
+				Urls     map[string]string `json:"urls"`
+				Animated map[string]string `json:"animated"`
+			} `json:"emoticons"`
+		} `json:"sets"`
+	}
+	if err := json.Unmarshal(data, &unmarshalled); err != nil {
+		return nil, err
+	}
+
+	active := unmarshalled.Default_sets
+	if unmarshalled.Room != nil {
+		active = append(active, unmarshalled.Room.Set)
+	}
+	keys := make([]string, 0, len(unmarshalled.Sets))
+	for k := range unmarshalled.Sets {
+		keys = append(keys, k)
+	}
+	// Deterministic order when two sets share a code
+	slices.Sort(keys)
+
+	var ret []Emote
+	for _, k := range keys {
+		if id, err := strconv.Atoi(k); err != nil || !slices.Contains(active, id) {
+			continue
+		}
+		for _, x := range unmarshalled.Sets[k].Emoticons {
+			url := x.Urls["1"]
+			if url == "" {
+				url = "https://cdn.frankerfacez.com/emote/" + strconv.Itoa(x.Id) + "/1"
+			} else if strings.HasPrefix(url, "//") {
+				url = "https:" + url
+			}
+			ret = append(ret, Emote{
+				Code: x.Name,
+				Url: url,
+				Provider: ProviderFFZ,
+				Animated: len(x.Animated) > 0,
+			})
+		}
+	}
+	return ret, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// 7TV
+
+type seventv_set struct {
+	Emotes []struct {
+		Name string `json:"name"`
+		Data struct {
+			Animated bool `json:"animated"`
+			Host     struct {
+				Url   string `json:"url"`
+				Files []struct {
+					Name string `json:"name"`
+				} `json:"files"`
+			} `json:"host"`
+		} `json:"data"`
+	} `json:"emotes"`
+}
+
+func (self seventv_set) emotes() []Emote {
+	ret := make([]Emote, len(self.Emotes))
+	for i, x := range self.Emotes {
+		// Host URLs are protocol relative, "//cdn.7tv.app/emote/<id>"
+		// Files are listed smallest first, and we cannot decode avif
+		file := "1x.webp"
+		for _, f := range x.Data.Host.Files {
+			if strings.HasSuffix(f.Name, ".webp") {
+				file = f.Name
+				break
+			}
+		}
+		ret[i] = Emote{
+			Code: x.Name, // The set's alias, not the emote's original name
+			Url: "https:" + x.Data.Host.Url + "/" + file,
+			Provider: Provider7TV,
+			Animated: x.Data.Animated,
+		}
+	}
+	return ret
+}
+
+func parse_7tv_set(data []byte) ([]Emote, error) {
+	var unmarshalled seventv_set
+	if err := json.Unmarshal(data, &unmarshalled); err != nil {
+		return nil, err
+	}
+	return unmarshalled.emotes(), nil
+}
+
+func parse_7tv_user(data []byte) ([]Emote, error) {
+	var unmarshalled struct {
+		Emote_set *seventv_set `json:"emote_set"`
+	}
+	if err := json.Unmarshal(data, &unmarshalled); err != nil {
+		return nil, err
+	}
+	if unmarshalled.Emote_set == nil {
+		return nil, nil
+	}
+	return unmarshalled.Emote_set.emotes(), nil
+}
@@ -0,0 +1,150 @@
+package src
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// An Atom (RFC 4287) feed of the VODs we have cached, for feed readers
+
+// Newest entries kept in the feed
+const FEED_SIZE = 50
+
+// Never changes, so that readers see the same feed across exports
+const FEED_ID = "tag:streamsurf,2024:vods"
+
+type atom_link struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atom_text struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atom_person struct {
+	Name string `xml:"name"`
+	Uri  string `xml:"uri,omitempty"`
+}
+
+type atom_category struct {
+	Term string `xml:"term,attr"`
+}
+
+type atom_entry struct {
+	Id         string          `xml:"id"`
+	Title      string          `xml:"title"`
+	Published  string          `xml:"published"`
+	Updated    string          `xml:"updated"`
+	Author     atom_person     `xml:"author"`
+	Links      []atom_link     `xml:"link"`
+	Categories []atom_category `xml:"category"`
+	Content    atom_text       `xml:"content"`
+}
+
+type atom_feed struct {
+	XMLName   xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Id        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Updated   string       `xml:"updated"`
+	Generator string       `xml:"generator"`
+	Links     []atom_link  `xml:"link"`
+	Entries   []atom_entry `xml:"entry"`
+}
+
+// Live streams are left out, they are not VODs yet. Entry IDs are the VOD
+// URLs and the timestamps are when the stream started, so the same VOD is
+// the same entry every time. self_url is where the feed is served, if it is.
+func Write_atom(output io.Writer, videos []Video, self_url string) error {
+	var vods []Video
+	for _, vid := range videos {
+		if !vid.Is_live && vid.Url != "" && !vid.Start_time.IsZero() {
+			vods = append(vods, vid)
+		}
+	}
+	slices.SortStableFunc(vods, func(a, b Video) int {
+		if x := b.Start_time.Compare(a.Start_time); x != 0 {
+			return x
+		}
+		return strings.Compare(a.Url, b.Url)
+	})
+	vods = vods[:min(len(vods), FEED_SIZE)]
+
+	// Nothing to base it on without entries, the epoch at least stays put
+	updated := time.Unix(0, 0)
+	if len(vods) > 0 {
+		updated = vods[0].Start_time
+	}
+	feed := atom_feed{
+		Id: FEED_ID,
+		Title: "New VODs",
+		Updated: updated.UTC().Format(time.RFC3339),
+		Generator: "streamsurf",
+	}
+	if self_url != "" {
+		feed.Links = append(feed.Links, atom_link{ Rel: "self", Type: "application/atom+xml", Href: self_url })
+	}
+	for _, vid := range vods {
+		feed.Entries = append(feed.Entries, atom_entry_of(vid))
+	}
+
+	if _, err := io.WriteString(output, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(output)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return err
+	}
+	_, err := io.WriteString(output, "\n")
+	return err
+}
+
+func atom_entry_of(vid Video) atom_entry {
+	name := vid.Display_name
+	if name == "" {
+		name = vid.Channel
+	}
+	start := vid.Start_time.UTC().Format(time.RFC3339)
+	entry := atom_entry{
+		Id: vid.Url,
+		Title: fmt.Sprintf("%s: %s", name, vid.Title),
+		Published: start,
+		Updated: start,
+		Author: atom_person{ name, "https://www.twitch.tv/" + vid.Channel },
+		Links: []atom_link{{ Rel: "alternate", Type: "text/html", Href: vid.Url }},
+		Content: atom_text{ Type: "html", Body: atom_content(vid) },
+	}
+	if vid.Game != "" {
+		entry.Categories = append(entry.Categories, atom_category{ vid.Game })
+	}
+	if len(vid.Thumbnail_URL) > 0 && vid.Thumbnail_URL[0] != "" {
+		entry.Links = append(entry.Links, atom_link{ Rel: "enclosure", Type: "image/jpeg", Href: vid.Thumbnail_URL[0] })
+	}
+	return entry
+}
+
+// The thumbnail, duration and chapters as HTML, which the encoder escapes
+// once more for the XML
+func atom_content(vid Video) string {
+	var ret strings.Builder
+	if len(vid.Thumbnail_URL) > 0 && vid.Thumbnail_URL[0] != "" {
+		fmt.Fprintf(&ret, `<p><a href="%s"><img src="%s" alt="Thumbnail"/></a></p>`, html.EscapeString(vid.Url), html.EscapeString(vid.Thumbnail_URL[0]))
+	}
+	fmt.Fprintf(&ret, "<p>Duration: %s</p>", Format_clock(vid.Duration))
+	if len(vid.Chapters) > 0 {
+		ret.WriteString("<ul>")
+		for _, x := range vid.Chapters {
+			fmt.Fprintf(&ret, "<li>%s %s</li>", Format_clock(x.Position), html.EscapeString(x.Name))
+		}
+		ret.WriteString("</ul>")
+	}
+	return ret.String()
+}
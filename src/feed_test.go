@@ -0,0 +1,52 @@
+package src
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run Atom
+
+func TestWriteAtom(t *testing.T) {
+	older := Video{ Channel: "tsoding", Display_name: "Tsoding", Title: "Compiler <again>", Url: "https://www.twitch.tv/videos/1",
+		Thumbnail_URL: []string{"https://example.com/1.jpg"}, Start_time: time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC), Duration: 2 * time.Hour + 5 * time.Minute,
+		Game: "Software and Game Development", Chapters: []Chapter{{ "Software and Game Development", 0 }, { "Factorio", 90 * time.Minute }} }
+	newer := Video{ Channel: "j_blow", Title: "Game", Url: "https://www.twitch.tv/videos/2", Start_time: time.Date(2024, 5, 2, 18, 0, 0, 0, time.UTC), Duration: time.Hour }
+	live := Video{ Channel: "tsoding", Title: "Live", Url: "https://www.twitch.tv/tsoding", Is_live: true, Start_time: time.Date(2024, 5, 3, 18, 0, 0, 0, time.UTC) }
+
+	var out strings.Builder
+	a.AssertEqual(t, nil, Write_atom(&out, []Video{older, live, newer}, "http://127.0.0.1:8420/feed.xml"))
+	a.AssertEqual(t, true, strings.HasPrefix(out.String(), `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<feed xmlns="http://www.w3.org/2005/Atom">`))
+
+	var feed atom_feed
+	a.AssertEqual(t, nil, xml.Unmarshal([]byte(out.String()), &feed))
+	a.AssertEqual(t, FEED_ID, feed.Id)
+	a.AssertEqual(t, "2024-05-02T18:00:00Z", feed.Updated)
+	a.AssertEqual(t, []atom_link{{ "self", "application/atom+xml", "http://127.0.0.1:8420/feed.xml" }}, feed.Links)
+	a.AssertEqual(t, 2, len(feed.Entries))
+	a.AssertEqual(t, "https://www.twitch.tv/videos/2", feed.Entries[0].Id)
+	a.AssertEqual(t, "j_blow: Game", feed.Entries[0].Title)
+	a.AssertEqual(t, atom_entry{
+		Id: "https://www.twitch.tv/videos/1",
+		Title: "Tsoding: Compiler <again>",
+		Published: "2024-05-01T18:00:00Z",
+		Updated: "2024-05-01T18:00:00Z",
+		Author: atom_person{ "Tsoding", "https://www.twitch.tv/tsoding" },
+		Links: []atom_link{
+			{ "alternate", "text/html", "https://www.twitch.tv/videos/1" },
+			{ "enclosure", "image/jpeg", "https://example.com/1.jpg" },
+		},
+		Categories: []atom_category{{ "Software and Game Development" }},
+		Content: atom_text{ "html", `<p><a href="https://www.twitch.tv/videos/1"><img src="https://example.com/1.jpg" alt="Thumbnail"/></a></p>` +
+			`<p>Duration: 2:05:00</p><ul><li>0:00:00 Software and Game Development</li><li>1:30:00 Factorio</li></ul>` },
+	}, feed.Entries[1])
+
+	// The same videos give the same feed
+	var again strings.Builder
+	a.AssertEqual(t, nil, Write_atom(&again, []Video{newer, older}, "http://127.0.0.1:8420/feed.xml"))
+	a.AssertEqual(t, out.String(), again.String())
+}
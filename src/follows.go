@@ -0,0 +1,162 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Follows of the logged in user, and syncing them into the channel list
+
+// A variable so that tests can point it at a local server
+var HELIX_URL = "https://api.twitch.tv/helix"
+
+// https://dev.twitch.tv/docs/api/reference/#get-followed-channels
+// Needs the user:read:follows scope. client_id has to be the app the token
+// was issued to.
+func Fetch_follows(ctx context.Context, client_id string, user_id string) ([]string, error) {
+	var ret []string
+	cursor := ""
+	for page := 0; ; page += 1 {
+		query := url.Values{ "user_id": {user_id}, "first": {"100"} }
+		if cursor != "" {
+			query.Set("after", cursor)
+		}
+		body, err := Request(ctx, "GET", map[string]string{
+			"Authorization": "Bearer",
+			"Client-Id": client_id,
+		}, nil, HELIX_URL + "/channels/followed?" + query.Encode(), fmt.Sprintf("helix-%s-follows-%d", user_id, page))
+		if err != nil {
+			return ret, err
+		}
+		logins, next, err := parse_follows(body)
+		body.Close()
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, logins...)
+		if next == "" || len(logins) == 0 {
+			return ret, nil
+		}
+		cursor = next
+	}
+}
+
+// Returns the cursor of the next page, empty on the last page
+func parse_follows(input io.Reader) ([]string, string, error) {
+	var page struct {
+		Data []struct {
+			Broadcaster_login string `json:"broadcaster_login"`
+		} `json:"data"`
+		Pagination struct {
+			Cursor string `json:"cursor"`
+		} `json:"pagination"`
+	}
+	if err := json.NewDecoder(input).Decode(&page); err != nil {
+		return nil, "", err
+	}
+	logins := make([]string, len(page.Data))
+	for i, x := range page.Data {
+		logins[i] = x.Broadcaster_login
+	}
+	return logins, page.Pagination.Cursor, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Channel list
+
+// One channel per line, blank lines are skipped
+func Parse_channel_list(text string) []string {
+	var ret []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret
+}
+
+// Returns false if there is no such file
+func Read_channel_list(path string) ([]string, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return Parse_channel_list(string(data)), true, nil
+}
+
+func Write_channel_list(path string, channels []string) error {
+	return Write_file_atomic(path, []byte(strings.Join(channels, "\n") + "\n"), 0o644)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Sync
+
+const (
+	SyncUnion = "union" // Only ever adds follows
+	SyncMirror = "mirror" // Also removes channels unfollowed since the last sync
+	SyncReview = "review" // Like mirror, but asks about every change
+)
+
+var SYNC_MODES = []string{SyncUnion, SyncMirror, SyncReview}
+
+type SyncDiff struct {
+	Add    []string
+	Remove []string
+}
+
+// Merges the follows (remote) into the channel list (local). previous is the
+// follows as of the last sync, so that we know which channels came from
+// Twitch, channels that only ever were in the local list are always kept.
+// review is asked about every change in SyncReview mode.
+//
+// Returns the new list and the changes that were made
+func Sync_follows(local []string, remote []string, previous []string, mode string, review func(channel string, is_add bool) bool) ([]string, SyncDiff, error) {
+	var diff SyncDiff
+	if !slices.Contains(SYNC_MODES, mode) {
+		return local, diff, fmt.Errorf("Unknown sync mode %q, expected one of %s", mode, strings.Join(SYNC_MODES, ", "))
+	}
+	contains := func(list []string, channel string) bool {
+		return slices.ContainsFunc(list, func(x string) bool { return strings.EqualFold(x, channel) })
+	}
+
+	for _, channel := range remote {
+		if contains(local, channel) || contains(diff.Add, channel) {
+			continue
+		}
+		// Follows that were turned down in an earlier review stay turned down
+		if mode == SyncReview && contains(previous, channel) {
+			continue
+		}
+		if mode != SyncReview || review(channel, true) {
+			diff.Add = append(diff.Add, channel)
+		}
+	}
+	if mode != SyncUnion {
+		for _, channel := range local {
+			if !contains(previous, channel) || contains(remote, channel) {
+				continue
+			}
+			if mode != SyncReview || review(channel, false) {
+				diff.Remove = append(diff.Remove, channel)
+			}
+		}
+	}
+
+	ret := make([]string, 0, len(local) + len(diff.Add))
+	for _, channel := range local {
+		if !contains(diff.Remove, channel) {
+			ret = append(ret, channel)
+		}
+	}
+	ret = append(ret, diff.Add...)
+	return ret, diff, nil
+}
@@ -0,0 +1,82 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -v -run Follows
+
+func TestFetchFollows(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Client-Id") != "app" || r.URL.Query().Get("user_id") != "123" {
+			w.WriteHeader(401)
+			return
+		}
+		switch r.URL.Query().Get("after") {
+		case "":
+			fmt.Fprint(w, `{"total":3,"data":[{"broadcaster_id":"1","broadcaster_login":"tsoding","broadcaster_name":"Tsoding"},{"broadcaster_id":"2","broadcaster_login":"bar","broadcaster_name":"Bar"}],"pagination":{"cursor":"page2"}}`)
+		case "page2":
+			fmt.Fprint(w, `{"total":3,"data":[{"broadcaster_id":"3","broadcaster_login":"baz","broadcaster_name":"Baz"}],"pagination":{}}`)
+		}
+	}))
+	defer server.Close()
+	defer func(x string) { HELIX_URL = x }(HELIX_URL)
+	defer func(x func(context.Context) (string, error)) { Authorizer = x }(Authorizer)
+	HELIX_URL = server.URL
+	Authorizer = func(context.Context) (string, error) { return "secret", nil }
+
+	follows, err := Fetch_follows(context.Background(), "app", "123")
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, []string{"tsoding", "bar", "baz"}, follows)
+}
+
+func TestSyncFollows(t *testing.T) {
+	local := []string{"Tsoding", "local", "gone"}
+	remote := []string{"tsoding", "new"}
+	previous := []string{"tsoding", "gone", "declined"}
+
+	list, diff, err := Sync_follows(local, remote, previous, SyncUnion, nil)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, []string{"Tsoding", "local", "gone", "new"}, list)
+	a.AssertEqual(t, SyncDiff{ Add: []string{"new"} }, diff)
+
+	// "local" was never followed on Twitch, so it stays
+	list, diff, err = Sync_follows(local, remote, previous, SyncMirror, nil)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, []string{"Tsoding", "local", "new"}, list)
+	a.AssertEqual(t, SyncDiff{ Add: []string{"new"}, Remove: []string{"gone"} }, diff)
+
+	var asked []string
+	list, diff, err = Sync_follows(local, append(remote, "declined"), previous, SyncReview, func(channel string, is_add bool) bool {
+		asked = append(asked, fmt.Sprintf("%s %t", channel, is_add))
+		return is_add
+	})
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, []string{"new true", "gone false"}, asked)
+	a.AssertEqual(t, []string{"Tsoding", "local", "gone", "new"}, list)
+	a.AssertEqual(t, SyncDiff{ Add: []string{"new"} }, diff)
+
+	_, _, err = Sync_follows(local, remote, previous, "replace", nil)
+	a.AssertEqual(t, true, err != nil)
+}
+
+func TestChannelList(t *testing.T) {
+	a.AssertEqual(t, []string{"a", "b"}, Parse_channel_list("a\r\n\n  b\n"))
+
+	path := filepath.Join(t.TempDir(), "channel_list.txt")
+	_, ok, err := Read_channel_list(path)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, false, ok)
+	a.AssertEqual(t, nil, Write_channel_list(path, []string{"a", "b"}))
+	list, ok, err := Read_channel_list(path)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, true, ok)
+	a.AssertEqual(t, []string{"a", "b"}, list)
+}
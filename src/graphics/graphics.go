@@ -0,0 +1,129 @@
+// Inline images in the terminal, via the kitty graphics protocol or sixel
+package graphics
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+//run: go test
+
+const (
+	ProtocolNone int = iota
+	ProtocolSixel
+	ProtocolKitty
+)
+
+// Used to scale sixel images when the terminal does not report its pixel size
+const DEFAULT_CELL_WIDTH = 10
+const DEFAULT_CELL_HEIGHT = 20
+
+// Thumbnails and avatars are cached on disk by the hash of their URL
+func cache_path(url string) (string, error) {
+	var dir string
+	if x, err := os.UserCacheDir(); err != nil {
+		return "", err
+	} else {
+		dir = filepath.Join(x, "streamsurf", "images")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	hash := sha1.Sum([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(hash[:])), nil
+}
+
+// Thumbnails of VODs and avatars rarely change, so a week old copy is fine
+const CACHE_EXPIRY = 7 * 24 * time.Hour
+
+// Live previews keep the same URL and are redrawn every few minutes
+const LIVE_PREVIEW_EXPIRY = 5 * time.Minute
+
+func Cache_expiry(url string) time.Duration {
+	if strings.Contains(url, "/live_user_") {
+		return LIVE_PREVIEW_EXPIRY
+	}
+	return CACHE_EXPIRY
+}
+
+// Downloads (or reads from the disk cache if fresh) and decodes a JPEG, PNG
+// or WebP. If the download fails we would rather show a stale image than none.
+func Fetch(url string) (image.Image, error) {
+	path, err := cache_path(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []byte
+	if info, err := os.Stat(path); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			if time.Since(info.ModTime()) < Cache_expiry(url) {
+				return Decode(bytes.NewReader(data))
+			}
+			stale = data
+		}
+	}
+
+	data, err := func() ([]byte, error) {
+		body, err := src.Request(context.TODO(), "GET", nil, nil, url, "image-" + filepath.Base(path))
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}()
+	if err != nil {
+		if stale != nil {
+			src.L_DEBUG.Printf("Using stale %s: %s", path, err)
+			return Decode(bytes.NewReader(stale))
+		}
+		return nil, err
+	}
+	// A failed cache write only costs us a redownload
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		src.L_DEBUG.Printf("Could not cache %q: %s", url, err)
+	}
+	return Decode(bytes.NewReader(data))
+}
+
+func Decode(input io.Reader) (image.Image, error) {
+	img, format, err := image.Decode(input)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode image: %w", err)
+	}
+	src.L_TRACE.Printf("Decoded %s %v", format, img.Bounds())
+	return img, nil
+}
+
+// Scales to fit inside width x height pixels, keeping the aspect ratio
+func Fit(img image.Image, width int, height int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 || width <= 0 || height <= 0 {
+		return img
+	}
+	scale := min(float64(width) / float64(w), float64(height) / float64(h))
+	target := image.Rect(0, 0, max(int(float64(w) * scale), 1), max(int(float64(h) * scale), 1))
+	if target.Dx() == w && target.Dy() == h {
+		return img
+	}
+	ret := image.NewRGBA(target)
+	draw.ApproxBiLinear.Scale(ret, target, img, bounds, draw.Src, nil)
+	return ret
+}
@@ -0,0 +1,76 @@
+package graphics
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"strings"
+	"testing"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+func TestFitCells(t *testing.T) {
+	// 16:9 thumbnail in a 32x9 box of 10x20 cells is width bound
+	c, r := fit_cells(image.Rect(0, 0, 320, 180), 32, 9, 10, 20)
+	a.AssertEqual(t, [2]int{32, 9}, [2]int{c, r})
+
+	// Square avatar is height bound
+	c, r = fit_cells(image.Rect(0, 0, 50, 50), 6, 3, 10, 20)
+	a.AssertEqual(t, [2]int{6, 3}, [2]int{c, r})
+	c, r = fit_cells(image.Rect(0, 0, 50, 50), 32, 3, 10, 20)
+	a.AssertEqual(t, [2]int{6, 3}, [2]int{c, r})
+
+	c, r = fit_cells(image.Rect(0, 0, 0, 0), 4, 2, 10, 20)
+	a.AssertEqual(t, [2]int{4, 2}, [2]int{c, r})
+}
+
+func TestSixelEncode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for y := 0; y < 6; y += 1 {
+		for x := 0; x < 8; x += 1 {
+			img.Set(x, y, color.Black)
+		}
+	}
+	var output strings.Builder
+	a.AssertEqual(t, nil, Sixel_encode(&output, img))
+
+	got := output.String()
+	a.AssertEqual(t, true, strings.HasPrefix(got, "\x1BP0;1;0q\"1;1;8;6"))
+	a.AssertEqual(t, true, strings.HasSuffix(got, "#0!8~-\x1B\\"))
+}
+
+func TestCacheExpiry(t *testing.T) {
+	a.AssertEqual(t, LIVE_PREVIEW_EXPIRY, Cache_expiry("https://static-cdn.jtvnw.net/previews-ttv/live_user_tsoding-320x180.jpg"))
+	a.AssertEqual(t, CACHE_EXPIRY, Cache_expiry("https://static-cdn.jtvnw.net/cf_vods/abc/thumb/thumb0-320x180.jpg"))
+}
+
+// Replaced and left over kitty images are freed, not just taken off the screen
+func TestStoreFree(t *testing.T) {
+	url := "https://static-cdn.jtvnw.net/previews-ttv/live_user_tsoding-320x180.jpg"
+	store := New_store()
+	store.Protocol = ProtocolKitty
+	store.Add(Loaded{ Url: url, Img: image.NewRGBA(image.Rect(0, 0, 4, 4)) })
+	draw := func() string {
+		var output strings.Builder
+		a.AssertEqual(t, nil, store.Clear(&output))
+		a.AssertEqual(t, nil, store.Draw(&output, url, 2, 1))
+		return output.String()
+	}
+
+	a.AssertEqual(t, true, strings.Contains(draw(), "a=t,f=100,i=1,"))
+	a.AssertEqual(t, false, strings.Contains(draw(), "a=t,"))
+
+	store.Add(Loaded{ Url: url, Img: image.NewRGBA(image.Rect(0, 0, 4, 4)) })
+	output := draw()
+	a.AssertEqual(t, true, strings.Contains(output, "\x1B_Ga=d,d=I,i=1,q=2\x1B\\"))
+	a.AssertEqual(t, true, strings.Contains(output, "a=t,f=100,i=2,"))
+
+	// A failed refresh keeps the old image
+	store.Add(Loaded{ Url: url, Err: io.EOF })
+	a.AssertEqual(t, false, strings.Contains(draw(), "d=I"))
+
+	var closed strings.Builder
+	a.AssertEqual(t, nil, store.Close(&closed))
+	a.AssertEqual(t, Kitty_delete_placements + "\x1B_Ga=d,d=I,i=2,q=2\x1B\\", closed.String())
+}
@@ -0,0 +1,65 @@
+package graphics
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+)
+
+// https://sw.kovidgoyal.net/kitty/graphics-protocol/
+
+// Terminals that support the protocol reply with ESC _ G i=31;OK ESC \
+// Send this before the device attributes query so that the reply (if any)
+// arrives first.
+const Query_kitty_graphics = "\x1B_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1B\\"
+
+// Removes every placement from the screen but keeps the image data around
+// so that we can place it again without retransmitting
+const Kitty_delete_placements = "\x1B_Ga=d,d=a,q=2\x1B\\"
+
+const kitty_chunk_size = 4096
+
+// Uploads the image (as PNG) under id without displaying it
+func Kitty_transmit(output io.Writer, id uint32, img image.Image) error {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return err
+	}
+	payload := base64.StdEncoding.EncodeToString(encoded.Bytes())
+
+	for i := 0; i < len(payload); i += kitty_chunk_size {
+		close := min(i + kitty_chunk_size, len(payload))
+		more := 1
+		if close == len(payload) {
+			more = 0
+		}
+
+		var err error
+		if i == 0 {
+			// q=2 silences replies which would otherwise show up on stdin
+			_, err = fmt.Fprintf(output, "\x1B_Ga=t,f=100,i=%d,q=2,m=%d;%s\x1B\\", id, more, payload[i:close])
+		} else {
+			_, err = fmt.Fprintf(output, "\x1B_Gm=%d;%s\x1B\\", more, payload[i:close])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Displays a transmitted image at the cursor, scaled to columns x rows cells
+// C=1 keeps the cursor where it is
+func Kitty_place(output io.Writer, id uint32, columns int, rows int) error {
+	_, err := fmt.Fprintf(output, "\x1B_Ga=p,i=%d,c=%d,r=%d,C=1,q=2\x1B\\", id, columns, rows)
+	return err
+}
+
+// Frees the image data in the terminal
+func Kitty_free(output io.Writer, id uint32) error {
+	_, err := fmt.Fprintf(output, "\x1B_Ga=d,d=I,i=%d,q=2\x1B\\", id)
+	return err
+}
@@ -0,0 +1,99 @@
+package graphics
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"io"
+)
+
+// https://vt100.net/docs/vt3xx-gp/chapter14.html
+//
+// Sixel images are bands of 6 pixel rows. For each colour in a band, we
+// write one character per column whose bits mark which of the 6 rows have
+// that colour, then '$' to return to the start of the band. '-' moves on to
+// the next band.
+//
+// Unlike kitty, sixel has no notion of deleting an image. We draw it into
+// the text cells, so clearing or overwriting those cells removes it.
+func Sixel_encode(output io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette.Plan9)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), img, bounds.Min)
+
+	writer := bufio.NewWriter(output)
+	// P2=1: pixels without a colour stay transparent
+	fmt.Fprintf(writer, "\x1BP0;1;0q\"1;1;%d;%d", paletted.Rect.Dx(), paletted.Rect.Dy())
+
+	// Only declare colours that are used
+	used := make([]bool, len(paletted.Palette))
+	for _, idx := range paletted.Pix {
+		used[idx] = true
+	}
+	for i, c := range paletted.Palette {
+		if !used[i] {
+			continue
+		}
+		r, g, b, _ := color.RGBAModel.Convert(c).RGBA()
+		// Sixel colours are in percent
+		fmt.Fprintf(writer, "#%d;2;%d;%d;%d", i, r * 100 / 0xFFFF, g * 100 / 0xFFFF, b * 100 / 0xFFFF)
+	}
+
+	width, height := paletted.Rect.Dx(), paletted.Rect.Dy()
+	bits := make([]byte, width)
+	for top := 0; top < height; top += 6 {
+		band_used := make([]bool, len(paletted.Palette))
+		for y := top; y < min(top + 6, height); y += 1 {
+			for _, idx := range paletted.Pix[y * paletted.Stride:y * paletted.Stride + width] {
+				band_used[idx] = true
+			}
+		}
+
+		first := true
+		for colour, ok := range band_used {
+			if !ok {
+				continue
+			}
+			clear(bits)
+			for y := top; y < min(top + 6, height); y += 1 {
+				row := paletted.Pix[y * paletted.Stride:]
+				for x := 0; x < width; x += 1 {
+					if int(row[x]) == colour {
+						bits[x] |= 1 << (y - top)
+					}
+				}
+			}
+			if !first {
+				writer.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(writer, "#%d", colour)
+			sixel_run_length(writer, bits)
+		}
+		writer.WriteByte('-')
+	}
+	writer.WriteString("\x1B\\")
+	return writer.Flush()
+}
+
+// '!' <count> <char> repeats a character
+func sixel_run_length(writer *bufio.Writer, bits []byte) {
+	for i := 0; i < len(bits); {
+		j := i
+		for j < len(bits) && bits[j] == bits[i] {
+			j += 1
+		}
+		c := bits[i] + 63
+		if count := j - i; count > 3 {
+			fmt.Fprintf(writer, "!%d%c", count, c)
+		} else {
+			for ; i < j; i += 1 {
+				writer.WriteByte(c)
+			}
+		}
+		i = j
+	}
+}
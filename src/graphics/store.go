@@ -0,0 +1,186 @@
+package graphics
+
+import (
+	"image"
+	"io"
+	"strings"
+	"time"
+)
+
+type Loaded struct {
+	Url string
+	Img image.Image
+	Err error
+}
+
+type entry struct {
+	img       image.Image
+	err       error
+	requested time.Time         // When the download was started
+	kitty_id  uint32            // 0 until transmitted
+	sixel     map[[2]int]string // Encoded per size in cells, since encoding is slow
+}
+
+// Images of the session by URL
+// Only the main loop should touch this, downloads report back via Queue
+type Store struct {
+	Protocol    int
+	Cell_width  int
+	Cell_height int
+	Queue       chan Loaded
+
+	entries map[string]*entry
+	next_id uint32
+	freed   []uint32 // Kitty images that were replaced, deleted on the next Clear
+}
+
+func New_store() *Store {
+	return &Store{
+		Protocol: ProtocolNone,
+		Cell_width: DEFAULT_CELL_WIDTH,
+		Cell_height: DEFAULT_CELL_HEIGHT,
+		Queue: make(chan Loaded, 16),
+		entries: make(map[string]*entry),
+	}
+}
+
+func (self *Store) Set_cell_size(width int, height int) {
+	if width > 0 && height > 0 {
+		self.Cell_width = width
+		self.Cell_height = height
+		for _, e := range self.entries {
+			clear(e.sixel)
+		}
+	}
+}
+
+// Starts downloading url in the background unless we already have it or are
+// getting it. Expired images, i.e. live previews, are downloaded again and the
+// old one is drawn until the new one arrives.
+func (self *Store) Request(url string) {
+	if self.Protocol == ProtocolNone || url == "" {
+		return
+	}
+	e, ok := self.entries[url]
+	if ok && time.Since(e.requested) < Cache_expiry(url) {
+		return
+	}
+	if !ok {
+		e = &entry{}
+		self.entries[url] = e
+	}
+	e.requested = time.Now()
+	queue := self.Queue
+	go func() {
+		img, err := Fetch(url)
+		queue <- Loaded{ url, img, err }
+	}()
+}
+
+func (self *Store) Add(loaded Loaded) {
+	e, ok := self.entries[loaded.Url]
+	if !ok {
+		e = &entry{}
+		self.entries[loaded.Url] = e
+	}
+	// Keep showing the old image if the new one failed
+	if loaded.Err != nil && e.img != nil {
+		return
+	}
+	if e.kitty_id != 0 {
+		self.freed = append(self.freed, e.kitty_id)
+		e.kitty_id = 0
+	}
+	clear(e.sixel)
+	e.img = loaded.Img
+	e.err = loaded.Err
+}
+
+// Removes images drawn by the previous frame, and frees the data of the kitty
+// images that have been replaced
+// Sixel images live in the text cells, so clearing the screen removes those
+func (self *Store) Clear(output io.Writer) error {
+	if self.Protocol != ProtocolKitty {
+		return nil
+	}
+	if _, err := io.WriteString(output, Kitty_delete_placements); err != nil {
+		return err
+	}
+	for _, id := range self.freed {
+		if err := Kitty_free(output, id); err != nil {
+			return err
+		}
+	}
+	self.freed = self.freed[:0]
+	return nil
+}
+
+// Frees every image we sent, the terminal would otherwise hold on to them
+// after we exit
+func (self *Store) Close(output io.Writer) error {
+	for _, e := range self.entries {
+		if e.kitty_id != 0 {
+			self.freed = append(self.freed, e.kitty_id)
+			e.kitty_id = 0
+		}
+	}
+	return self.Clear(output)
+}
+
+// Draws the image at the cursor inside columns x rows cells
+// Does nothing if the image has not loaded (yet) or we cannot draw images
+func (self *Store) Draw(output io.Writer, url string, columns int, rows int) error {
+	e, ok := self.entries[url]
+	if !ok || e.img == nil || columns <= 0 || rows <= 0 {
+		return nil
+	}
+
+	switch self.Protocol {
+	case ProtocolKitty:
+		if e.kitty_id == 0 {
+			self.next_id += 1
+			e.kitty_id = self.next_id
+			// No point sending more pixels than we will show
+			img := Fit(e.img, columns * self.Cell_width, rows * self.Cell_height)
+			if err := Kitty_transmit(output, e.kitty_id, img); err != nil {
+				return err
+			}
+		}
+		// Kitty stretches to fill c x r, so work out the box that keeps the
+		// aspect ratio
+		c, r := fit_cells(e.img.Bounds(), columns, rows, self.Cell_width, self.Cell_height)
+		return Kitty_place(output, e.kitty_id, c, r)
+
+	case ProtocolSixel:
+		size := [2]int{columns, rows}
+		if e.sixel == nil {
+			e.sixel = make(map[[2]int]string)
+		}
+		encoded, ok := e.sixel[size]
+		if !ok {
+			var buffer strings.Builder
+			img := Fit(e.img, columns * self.Cell_width, rows * self.Cell_height)
+			if err := Sixel_encode(&buffer, img); err != nil {
+				return err
+			}
+			encoded = buffer.String()
+			e.sixel[size] = encoded
+		}
+		_, err := io.WriteString(output, encoded)
+		return err
+	}
+	return nil
+}
+
+// The number of cells an image takes up when fit into columns x rows
+func fit_cells(bounds image.Rectangle, columns int, rows int, cell_width int, cell_height int) (int, int) {
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return columns, rows
+	}
+	box_w, box_h := float64(columns * cell_width), float64(rows * cell_height)
+	scale := min(box_w / float64(w), box_h / float64(h))
+	c := int(float64(w) * scale) / cell_width
+	r := int(float64(h) * scale) / cell_height
+	return max(min(c, columns), 1), max(min(r, rows), 1)
+}
@@ -0,0 +1,113 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A watch-later list of videos that are played one after the other.
+// Offset is in the same format as streamlink's --hls-start-offset (e.g. 1:00:00)
+type QueueItem struct {
+	Video  Video
+	Offset string
+}
+
+type Queue struct {
+	Path  string
+	Items []QueueItem
+}
+
+// A missing file is treated as an empty queue
+func Load_queue(path string) (Queue, error) {
+	ret := Queue{ Path: path }
+	fh, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, nil
+		}
+		return ret, err
+	}
+	defer fh.Close()
+
+	if err := json.NewDecoder(fh).Decode(&ret.Items); err != nil {
+		return ret, fmt.Errorf("Could not read the queue %s: %w", path, err)
+	}
+	return ret, nil
+}
+
+func (self *Queue) Save() error {
+	if self.Path == "" {
+		return nil
+	}
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(self.Items); err != nil {
+		return err
+	}
+	return Write_file_atomic(self.Path, data.Bytes(), 0o644)
+}
+
+// Returns false if the video is already queued, in which case we only update
+// the offset
+func (self *Queue) Add(video Video, offset string) bool {
+	for i, item := range self.Items {
+		if item.Video.Url == video.Url {
+			self.Items[i].Offset = offset
+			return false
+		}
+	}
+	self.Items = append(self.Items, QueueItem{ video, offset })
+	return true
+}
+
+func (self *Queue) Remove(idx int) {
+	if idx < 0 || idx >= len(self.Items) {
+		return
+	}
+	self.Items = append(self.Items[:idx], self.Items[idx + 1:]...)
+}
+
+// Swaps the item at idx with its neighbour in the direction of delta
+// Returns the new index of the item
+func (self *Queue) Move(idx int, delta int) int {
+	target := idx + delta
+	if idx < 0 || idx >= len(self.Items) || target < 0 || target >= len(self.Items) {
+		return idx
+	}
+	self.Items[idx], self.Items[target] = self.Items[target], self.Items[idx]
+	return target
+}
+
+// Lives are played from the channel page since the stream URL does not change
+// between broadcasts
+func Streamlink_args(video Video, offset string) []string {
+	if video.Is_live {
+		return []string{"https://www.twitch.tv/" + video.Channel}
+	} else if offset == "" {
+		return []string{video.Url}
+	} else {
+		return []string{"--hls-start-offset", offset, video.Url}
+	}
+}
+
+// Plays a VOD in mpv with sub_path as a subtitle track. mpv is handed the HLS
+// playlist instead of a pipe, so seeking works and the subtitles stay in sync.
+func Streamlink_args_with_subtitles(video Video, offset string, sub_path string) []string {
+	if video.Is_live {
+		return Streamlink_args(video, offset)
+	}
+	player_args := "--sub-file=" + shell_quote(sub_path)
+	if offset != "" {
+		player_args += " --start=" + shell_quote(offset)
+	}
+	return []string{"--player", "mpv", "--player-passthrough", "hls", "--player-args", player_args, video.Url}
+}
+
+// streamlink splits --player-args like a POSIX shell
+func shell_quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,53 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -v -run Queue
+
+func TestQueueOrder(t *testing.T) {
+	var queue Queue
+	a.AssertEqual(t, true, queue.Add(Video{ Url: "a" }, ""))
+	a.AssertEqual(t, true, queue.Add(Video{ Url: "b" }, "1:00"))
+	a.AssertEqual(t, true, queue.Add(Video{ Url: "c" }, ""))
+	a.AssertEqual(t, false, queue.Add(Video{ Url: "a" }, "2:00"))
+	a.AssertEqual(t, "2:00", queue.Items[0].Offset)
+
+	a.AssertEqual(t, 1, queue.Move(0, 1))
+	a.AssertEqual(t, 1, queue.Move(1, 5)) // Out of bounds does nothing
+	a.AssertEqual(t, "b", queue.Items[0].Video.Url)
+	a.AssertEqual(t, "a", queue.Items[1].Video.Url)
+
+	queue.Remove(0)
+	a.AssertEqual(t, 2, len(queue.Items))
+	a.AssertEqual(t, "a", queue.Items[0].Video.Url)
+	a.AssertEqual(t, "c", queue.Items[1].Video.Url)
+}
+
+func TestQueuePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	queue := Must(Load_queue(path))
+	a.AssertEqual(t, 0, len(queue.Items))
+
+	queue.Add(Video{ Url: "a", Channel: "tsoding" }, "1:00:00")
+	Must1(queue.Save())
+
+	loaded := Must(Load_queue(path))
+	a.AssertEqual(t, queue.Items, loaded.Items)
+
+	Must1(os.WriteFile(path, []byte("[{"), 0o644))
+	_, err := Load_queue(path)
+	a.AssertEqual(t, true, err != nil)
+}
+
+func TestStreamlinkArgsWithSubtitles(t *testing.T) {
+	vod := Video{ Url: "https://www.twitch.tv/videos/1" }
+	a.AssertEqual(t, []string{"--player", "mpv", "--player-passthrough", "hls", "--player-args", `--sub-file='/tmp/it'\''s.ass' --start='1:00:00'`, vod.Url}, Streamlink_args_with_subtitles(vod, "1:00:00", "/tmp/it's.ass"))
+	live := Video{ Channel: "tsoding", Is_live: true }
+	a.AssertEqual(t, Streamlink_args(live, ""), Streamlink_args_with_subtitles(live, "", "/tmp/x.ass"))
+}
@@ -0,0 +1,243 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The schedules that channels publish on twitch.tv/<channel>/schedule
+
+// How far ahead we ask for segments
+const SCHEDULE_DAYS = 14
+
+var SCHEDULE_GRAPHQL_QUERY = strings.ReplaceAll(`query schedule($login: String!, $startAt: RFC3339Time, $endAt: RFC3339Time) {
+    user(login: $login) {
+        id
+        login
+        displayName
+        channel {
+            schedule {
+                id
+                segments(startAt: $startAt, endAt: $endAt) {
+                    id
+                    startAt
+                    endAt
+                    title
+                    isCancelled
+                    categories {
+                        id
+                        name
+                    }
+                }
+            }
+        }
+    }
+}`, "\n", " ")
+
+// One planned stream. Recurring streams are one segment per occurrence.
+type ScheduleSegment struct {
+	Id           string
+	Channel      string
+	Display_name string
+	Title        string
+	Category     string
+	Start_time   time.Time
+	End_time     time.Time // Zero if the streamer did not give one
+	Is_cancelled bool
+}
+
+// Segments that have not ended yet, soonest first. Channels without a
+// schedule have no segments, that is not an error.
+func Graph_schedule(ctx context.Context, channel string) ([]ScheduleSegment, error) {
+	now := time.Now()
+	body, err := json.Marshal([]map[string]any{{
+		"operationName": "schedule",
+		"variables": map[string]any{
+			"login": channel,
+			"startAt": now.UTC().Format(time.RFC3339),
+			"endAt": now.AddDate(0, 0, SCHEDULE_DAYS).UTC().Format(time.RFC3339),
+		},
+		"query": SCHEDULE_GRAPHQL_QUERY,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := Request(ctx, "POST", map[string]string{
+		"Accept": "*/*",
+		"Content-Type": "text/plain; charset=UTF-8",
+		"Client-Id": CLIENT_ID,
+	}, strings.NewReader(string(body)), "https://gql.twitch.tv/gql#origin=twilight", fmt.Sprintf("graph-%s-schedule", channel))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+	return parse_schedule(resp, channel, now)
+}
+
+func parse_schedule(input io.Reader, channel string, now time.Time) ([]ScheduleSegment, error) {
+	type Query struct {
+		Data struct {
+			User *struct {
+				Login        string `json:"login"`
+				Display_name string `json:"displayName"`
+				Channel      struct {
+					Schedule *struct {
+						Segments []struct {
+							Id           string     `json:"id"`
+							Start_at     time.Time  `json:"startAt"`
+							End_at       *time.Time `json:"endAt"`
+							Title        string     `json:"title"`
+							Is_cancelled bool       `json:"isCancelled"`
+							Categories   []struct {
+								Name string `json:"name"`
+							} `json:"categories"`
+						} `json:"segments"`
+					} `json:"schedule"`
+				} `json:"channel"`
+			} `json:"user"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	var unmarshalled []Query
+	if err := json.NewDecoder(input).Decode(&unmarshalled); err != nil {
+		return nil, err
+	}
+	if len(unmarshalled) == 0 {
+		return nil, fmt.Errorf("Empty schedule response")
+	}
+	if errs := unmarshalled[0].Errors; len(errs) > 0 {
+		return nil, fmt.Errorf("Schedule of %s failed: %s", channel, errs[0].Message)
+	}
+	user := unmarshalled[0].Data.User
+	if user == nil {
+		return nil, fmt.Errorf("There is no channel called %q", channel)
+	}
+	if user.Channel.Schedule == nil {
+		return nil, nil
+	}
+
+	var ret []ScheduleSegment
+	for _, x := range user.Channel.Schedule.Segments {
+		segment := ScheduleSegment{
+			Id: x.Id,
+			Channel: user.Login,
+			Display_name: user.Display_name,
+			Title: x.Title,
+			Start_time: x.Start_at,
+			Is_cancelled: x.Is_cancelled,
+		}
+		if x.End_at != nil {
+			segment.End_time = *x.End_at
+		}
+		if len(x.Categories) > 0 {
+			segment.Category = x.Categories[0].Name
+		}
+		if segment.Ends_before(now) {
+			continue
+		}
+		ret = append(ret, segment)
+	}
+	slices.SortStableFunc(ret, func(a, b ScheduleSegment) int { return a.Start_time.Compare(b.Start_time) })
+	return ret, nil
+}
+
+// Segments without an end are taken to be over once they started
+func (self ScheduleSegment) Ends_before(t time.Time) bool {
+	if self.End_time.IsZero() {
+		return self.Start_time.Before(t)
+	}
+	return self.End_time.Before(t)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// iCalendar (RFC 5545) export
+
+const ICS_TIME_FORMAT = "20060102T150405Z"
+
+// Calendars that subscribe to the file update events by UID, so the same
+// occurrence keeps the same UID across exports
+func Write_ics(output io.Writer, segments []ScheduleSegment, now time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//streamsurf//schedule//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Twitch schedules",
+	}
+	for _, x := range segments {
+		name := x.Display_name
+		if name == "" {
+			name = x.Channel
+		}
+		summary := name
+		if x.Title != "" {
+			summary += ": " + x.Title
+		}
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:%s-%d@streamsurf", x.Id, x.Start_time.Unix()),
+			"DTSTAMP:" + now.UTC().Format(ICS_TIME_FORMAT),
+			"DTSTART:" + x.Start_time.UTC().Format(ICS_TIME_FORMAT),
+		)
+		if !x.End_time.IsZero() {
+			lines = append(lines, "DTEND:" + x.End_time.UTC().Format(ICS_TIME_FORMAT))
+		}
+		lines = append(lines,
+			"SUMMARY:" + ics_escape(summary),
+			"URL:https://www.twitch.tv/" + x.Channel,
+		)
+		if x.Category != "" {
+			lines = append(lines, "CATEGORIES:" + ics_escape(x.Category))
+			lines = append(lines, "DESCRIPTION:" + ics_escape(x.Category + "\nhttps://www.twitch.tv/" + x.Channel))
+		}
+		if x.Is_cancelled {
+			lines = append(lines, "STATUS:CANCELLED")
+		} else {
+			lines = append(lines, "STATUS:CONFIRMED")
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(output, ics_fold(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ics_escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// Lines are at most 75 bytes, continued on the next line after a space,
+// without splitting a UTF-8 character
+func ics_fold(line string) string {
+	var ret strings.Builder
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut -= 1
+		}
+		ret.WriteString(line[:cut])
+		ret.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74 // The leading space counts
+	}
+	ret.WriteString(line)
+	ret.WriteString("\r\n")
+	return ret.String()
+}
@@ -0,0 +1,84 @@
+package src
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run 'Schedule|Ics'
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	segments, err := parse_schedule(strings.NewReader(`[{"data":{"user":{"id":"1","login":"tsoding","displayName":"Tsoding","channel":{"schedule":{"id":"s","segments":[
+		{"id":"b","startAt":"2024-05-09T18:00:00Z","endAt":"2024-05-09T22:00:00Z","title":"Thursday","isCancelled":true,"categories":[{"id":"1","name":"Software and Game Development"}]},
+		{"id":"a","startAt":"2024-05-07T18:00:00Z","endAt":"2024-05-07T22:00:00Z","title":"Tuesday","isCancelled":false,"categories":null},
+		{"id":"old","startAt":"2024-05-06T08:00:00Z","endAt":"2024-05-06T10:00:00Z","title":"Over","isCancelled":false},
+		{"id":"open","startAt":"2024-05-06T11:00:00Z","endAt":null,"title":"Started","isCancelled":false}
+	]}}}}}]`), "tsoding", now)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, []ScheduleSegment{
+		{ Id: "a", Channel: "tsoding", Display_name: "Tsoding", Title: "Tuesday",
+			Start_time: time.Date(2024, 5, 7, 18, 0, 0, 0, time.UTC), End_time: time.Date(2024, 5, 7, 22, 0, 0, 0, time.UTC) },
+		{ Id: "b", Channel: "tsoding", Display_name: "Tsoding", Title: "Thursday", Category: "Software and Game Development", Is_cancelled: true,
+			Start_time: time.Date(2024, 5, 9, 18, 0, 0, 0, time.UTC), End_time: time.Date(2024, 5, 9, 22, 0, 0, 0, time.UTC) },
+	}, segments)
+
+	segments, err = parse_schedule(strings.NewReader(`[{"data":{"user":{"id":"1","login":"quiet","channel":{"schedule":null}}}}]`), "quiet", now)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, 0, len(segments))
+
+	_, err = parse_schedule(strings.NewReader(`[{"data":{"user":null}}]`), "nobody", now)
+	a.AssertEqual(t, `There is no channel called "nobody"`, err.Error())
+}
+
+func TestWriteIcs(t *testing.T) {
+	var out strings.Builder
+	start := time.Date(2024, 5, 7, 18, 0, 0, 0, time.UTC)
+	err := Write_ics(&out, []ScheduleSegment{
+		{ Id: "a", Channel: "tsoding", Display_name: "Tsoding", Title: "Making a compiler, again; part 2", Category: "Software and Game Development",
+			Start_time: start, End_time: start.Add(4 * time.Hour) },
+		{ Id: "b", Channel: "quiet", Start_time: start, Is_cancelled: true },
+	}, time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC))
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//streamsurf//schedule//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Twitch schedules",
+		"BEGIN:VEVENT",
+		"UID:a-1715104800@streamsurf",
+		"DTSTAMP:20240506T120000Z",
+		"DTSTART:20240507T180000Z",
+		"DTEND:20240507T220000Z",
+		`SUMMARY:Tsoding: Making a compiler\, again\; part 2`,
+		"URL:https://www.twitch.tv/tsoding",
+		"CATEGORIES:Software and Game Development",
+		`DESCRIPTION:Software and Game Development\nhttps://www.twitch.tv/tsoding`,
+		"STATUS:CONFIRMED",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:b-1715104800@streamsurf",
+		"DTSTAMP:20240506T120000Z",
+		"DTSTART:20240507T180000Z",
+		"SUMMARY:quiet",
+		"URL:https://www.twitch.tv/quiet",
+		"STATUS:CANCELLED",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n"), out.String())
+}
+
+func TestIcsFold(t *testing.T) {
+	a.AssertEqual(t, "short\r\n", ics_fold("short"))
+	folded := ics_fold("SUMMARY:" + strings.Repeat("ä", 50))
+	lines := strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n ")
+	a.AssertEqual(t, 2, len(lines))
+	a.AssertEqual(t, 74, len(lines[0])) // 75 would cut an "ä" in half
+	a.AssertEqual(t, "SUMMARY:" + strings.Repeat("ä", 50), strings.Join(lines, ""))
+}
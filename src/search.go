@@ -0,0 +1,257 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Search for channels, categories and live streams, what the search box on
+// twitch.tv shows as you type
+
+var SEARCH_GRAPHQL_QUERY = strings.ReplaceAll(`query search($query: String!, $platform: String!) {
+    searchFor(userQuery: $query, platform: $platform) {
+        channels {
+            edges {
+                item {
+                    ... on User {
+                        ...searchUser
+                    }
+                }
+            }
+        }
+        games {
+            edges {
+                item {
+                    ... on Game {
+                        id
+                        name
+                        displayName
+                        viewersCount
+                    }
+                }
+            }
+        }
+        relatedLiveChannels {
+            edges {
+                item {
+                    ... on Stream {
+                        broadcaster {
+                            ...searchUser
+                        }
+                    }
+                }
+            }
+        }
+    }
+}
+fragment searchUser on User {
+    id
+    login
+    displayName
+    description
+    profileImageURL(width: 50)
+    followers {
+        totalCount
+    }
+    stream {
+        viewersCount
+        createdAt
+        game {
+            name
+        }
+    }
+    broadcastSettings {
+        title
+    }
+}`, "\n", " ")
+
+type SearchChannel struct {
+	Id           string
+	Login        string
+	Display_name string
+	Description  string
+	Followers    int
+	Viewers      int // Only when live
+	Is_live      bool
+}
+
+type SearchCategory struct {
+	Id           string
+	Name         string
+	Display_name string
+	Viewers      int
+}
+
+type SearchResults struct {
+	Live       []Video // Live streams, most viewers first
+	Channels   []SearchChannel
+	Categories []SearchCategory
+}
+
+func Search(ctx context.Context, query string) (SearchResults, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return SearchResults{}, fmt.Errorf("Nothing to search for")
+	}
+	body, err := json.Marshal([]map[string]any{{
+		"operationName": "search",
+		"variables": map[string]any{ "query": query, "platform": "web" },
+		"query": SEARCH_GRAPHQL_QUERY,
+	}})
+	if err != nil {
+		return SearchResults{}, err
+	}
+
+	resp, err := Request(ctx, "POST", map[string]string{
+		"Accept": "*/*",
+		"Content-Type": "text/plain; charset=UTF-8",
+		"Client-Id": CLIENT_ID,
+	}, strings.NewReader(string(body)), "https://gql.twitch.tv/gql#origin=twilight", "graph-search-" + url.QueryEscape(query))
+	if err != nil {
+		return SearchResults{}, err
+	}
+	defer resp.Close()
+	return parse_search(resp)
+}
+
+func parse_search(input io.Reader) (SearchResults, error) {
+	type User struct {
+		Id           string `json:"id"`
+		Login        string `json:"login"`
+		Display_name string `json:"displayName"`
+		Description  string `json:"description"`
+		Profile_URL  string `json:"profileImageURL"`
+		Followers    struct {
+			Total_count int `json:"totalCount"`
+		} `json:"followers"`
+		Stream *struct {
+			Viewers_count int       `json:"viewersCount"`
+			Created_at    time.Time `json:"createdAt"`
+			Game *struct {
+				Name string `json:"name"`
+			} `json:"game"`
+		} `json:"stream"`
+		Broadcast_settings struct {
+			Title string `json:"title"`
+		} `json:"broadcastSettings"`
+	}
+	type Query struct {
+		Data struct {
+			Search_for *struct {
+				Channels struct {
+					Edges []struct {
+						Item *User `json:"item"`
+					} `json:"edges"`
+				} `json:"channels"`
+				Games struct {
+					Edges []struct {
+						Item *struct {
+							Id            string `json:"id"`
+							Name          string `json:"name"`
+							Display_name  string `json:"displayName"`
+							Viewers_count int    `json:"viewersCount"`
+						} `json:"item"`
+					} `json:"edges"`
+				} `json:"games"`
+				Related_live_channels struct {
+					Edges []struct {
+						Item *struct {
+							Broadcaster *User `json:"broadcaster"`
+						} `json:"item"`
+					} `json:"edges"`
+				} `json:"relatedLiveChannels"`
+			} `json:"searchFor"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	var unmarshalled []Query
+	if err := json.NewDecoder(input).Decode(&unmarshalled); err != nil {
+		return SearchResults{}, err
+	}
+	if len(unmarshalled) == 0 {
+		return SearchResults{}, fmt.Errorf("Empty search response")
+	}
+	if errs := unmarshalled[0].Errors; len(errs) > 0 {
+		return SearchResults{}, fmt.Errorf("Search failed: %s", errs[0].Message)
+	}
+	search := unmarshalled[0].Data.Search_for
+	if search == nil {
+		return SearchResults{}, nil
+	}
+
+	var ret SearchResults
+	seen_live := make(map[string]bool)
+	add_live := func(user User) {
+		if user.Stream == nil || seen_live[user.Login] {
+			return
+		}
+		seen_live[user.Login] = true
+		var game string
+		if user.Stream.Game != nil {
+			game = user.Stream.Game.Name
+		}
+		ret.Live = append(ret.Live, Video{
+			Title: user.Broadcast_settings.Title,
+			Channel: user.Login,
+			Channel_id: user.Id,
+			Thumbnail_URL: []string{"https://static-cdn.jtvnw.net/previews-ttv/live_user_" + user.Login + "-320x180.jpg"},
+			Avatar_URL: user.Profile_URL,
+			Start_time: user.Stream.Created_at,
+			Duration: time.Now().Sub(user.Stream.Created_at),
+			Is_live: true,
+			Url: "https://www.twitch.tv/" + user.Login,
+			Chapters: []Chapter{Chapter{game, 0}},
+			Viewers: user.Stream.Viewers_count,
+			Game: game,
+			Display_name: user.Display_name,
+		})
+	}
+
+	for _, edge := range search.Channels.Edges {
+		user := edge.Item
+		if user == nil || user.Login == "" {
+			continue
+		}
+		channel := SearchChannel{
+			Id: user.Id,
+			Login: user.Login,
+			Display_name: user.Display_name,
+			Description: user.Description,
+			Followers: user.Followers.Total_count,
+		}
+		if user.Stream != nil {
+			channel.Is_live = true
+			channel.Viewers = user.Stream.Viewers_count
+		}
+		ret.Channels = append(ret.Channels, channel)
+		add_live(*user)
+	}
+	for _, edge := range search.Related_live_channels.Edges {
+		if edge.Item != nil && edge.Item.Broadcaster != nil {
+			add_live(*edge.Item.Broadcaster)
+		}
+	}
+	for _, edge := range search.Games.Edges {
+		if x := edge.Item; x != nil && x.Name != "" {
+			display := x.Display_name
+			if display == "" {
+				display = x.Name
+			}
+			ret.Categories = append(ret.Categories, SearchCategory{ x.Id, x.Name, display, x.Viewers_count })
+		}
+	}
+
+	// Both sources are ordered by relevance, but for live streams the
+	// viewer count is what you want to pick by
+	slices.SortStableFunc(ret.Live, Sort_videos_by_viewers)
+	return ret, nil
+}
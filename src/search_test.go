@@ -0,0 +1,52 @@
+package src
+
+import (
+	"strings"
+	"testing"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run Search
+
+const SEARCH_RESPONSE = `[{"data":{"searchFor":{
+	"channels":{"edges":[
+		{"item":{"id":"1","login":"tsoding","displayName":"Tsoding","description":"Recreational programming","followers":{"totalCount":300000},"stream":null,"broadcastSettings":{"title":"old"}}},
+		{"item":{"id":"2","login":"tsodingdaily","displayName":"TsodingDaily","followers":{"totalCount":5000},"stream":{"viewersCount":40,"createdAt":"2024-05-01T10:00:00Z","game":{"name":"Software and Game Development"}},"broadcastSettings":{"title":"Daily"}}},
+		{"item":null}
+	]},
+	"games":{"edges":[{"item":{"id":"1469308723","name":"Software and Game Development","displayName":"Software and Game Development","viewersCount":12000}}]},
+	"relatedLiveChannels":{"edges":[
+		{"item":{"broadcaster":{"id":"3","login":"bigcoder","displayName":"BigCoder","followers":{"totalCount":1},"stream":{"viewersCount":900,"createdAt":"2024-05-01T09:00:00Z","game":null},"broadcastSettings":{"title":"Big"}}}},
+		{"item":{"broadcaster":{"id":"2","login":"tsodingdaily","displayName":"TsodingDaily","followers":{"totalCount":5000},"stream":{"viewersCount":40,"createdAt":"2024-05-01T10:00:00Z","game":null},"broadcastSettings":{"title":"Daily"}}}}
+	]}
+}}}]`
+
+func TestParseSearch(t *testing.T) {
+	results, err := parse_search(strings.NewReader(SEARCH_RESPONSE))
+	a.AssertEqual(t, nil, err)
+
+	a.AssertEqual(t, 2, len(results.Channels))
+	a.AssertEqual(t, SearchChannel{ "1", "tsoding", "Tsoding", "Recreational programming", 300000, 0, false }, results.Channels[0])
+	a.AssertEqual(t, true, results.Channels[1].Is_live)
+	a.AssertEqual(t, 40, results.Channels[1].Viewers)
+
+	// Deduplicated and by viewers
+	a.AssertEqual(t, 2, len(results.Live))
+	a.AssertEqual(t, "bigcoder", results.Live[0].Channel)
+	a.AssertEqual(t, "tsodingdaily", results.Live[1].Channel)
+	a.AssertEqual(t, "Daily", results.Live[1].Title)
+	a.AssertEqual(t, "https://www.twitch.tv/tsodingdaily", results.Live[1].Url)
+	a.AssertEqual(t, "Software and Game Development", results.Live[1].Chapters[0].Name)
+
+	a.AssertEqual(t, []SearchCategory{{ "1469308723", "Software and Game Development", "Software and Game Development", 12000 }}, results.Categories)
+}
+
+func TestParseSearchErrors(t *testing.T) {
+	_, err := parse_search(strings.NewReader(`[{"errors":[{"message":"service timeout"}]}]`))
+	a.AssertEqual(t, "Search failed: service timeout", err.Error())
+
+	results, err := parse_search(strings.NewReader(`[{"data":{"searchFor":null}}]`))
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, 0, len(results.Channels))
+}
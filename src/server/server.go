@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/tui"
+)
+
+// Serves the follow state over HTTP, so that status bars and dashboards can
+// share one refresh loop instead of each polling Twitch
+//
+// GET /channels                 every followed channel with its live stream and latest VOD
+// GET /channels/{name}/videos   the cached videos of a followed channel, newest first
+// GET /live                     the live streams
+// GET /events                   Server-Sent Events, "live" and "offline" as channels change
+// GET /feed.xml                 Atom feed of the cached VODs
+
+const DEFAULT_LISTEN = "127.0.0.1:8420"
+const DEFAULT_REFRESH = 5 * time.Minute
+
+// How often idle event streams get a comment, so that proxies keep them open
+const KEEPALIVE = 30 * time.Second
+
+type Server struct {
+	Refresh time.Duration
+
+	mutex sync.RWMutex // Guards ui and seen
+	ui    *tui.UIState
+	seen  map[string]bool // Channels whose live status we have had at least once
+
+	subscriber_mutex sync.Mutex
+	subscribers      map[chan Change]bool
+}
+
+// A channel going live or offline
+type Change struct {
+	Channel string     `json:"channel"`
+	Is_live bool       `json:"is_live"`
+	Live    *VideoJSON `json:"live"` // Only when live
+}
+
+type ChapterJSON struct {
+	Name             string `json:"name"`
+	Position_seconds int    `json:"position_seconds"`
+}
+
+// src.Video with stable field names for other tools
+type VideoJSON struct {
+	Id               string        `json:"id"`
+	Url              string        `json:"url"`
+	Channel          string        `json:"channel"`
+	Display_name     string        `json:"display_name"`
+	Title            string        `json:"title"`
+	Game             string        `json:"game"`
+	Is_live          bool          `json:"is_live"`
+	Viewers          int           `json:"viewers"`
+	Broadcast_type   string        `json:"broadcast_type"`
+	Start_time       time.Time     `json:"start_time"`
+	Duration_seconds int           `json:"duration_seconds"`
+	Thumbnail_url    string        `json:"thumbnail_url"`
+	Language         string        `json:"language"`
+	Tags             []string      `json:"tags"`
+	Chapters         []ChapterJSON `json:"chapters"`
+}
+
+type ChannelJSON struct {
+	Channel string     `json:"channel"`
+	Is_live bool       `json:"is_live"`
+	Live    *VideoJSON `json:"live"`
+	Latest  *VideoJSON `json:"latest"` // Latest VOD, null until the first refresh
+}
+
+func Video_json(vid src.Video) VideoJSON {
+	ret := VideoJSON{
+		Id: vid.Id,
+		Url: vid.Url,
+		Channel: vid.Channel,
+		Display_name: vid.Display_name,
+		Title: vid.Title,
+		Game: vid.Game,
+		Is_live: vid.Is_live,
+		Viewers: vid.Viewers,
+		Broadcast_type: vid.Broadcast_type,
+		Start_time: vid.Start_time,
+		Duration_seconds: int(vid.Duration.Seconds()),
+		Language: vid.Language,
+		Tags: vid.Tags,
+		Chapters: make([]ChapterJSON, len(vid.Chapters)),
+	}
+	if len(vid.Thumbnail_URL) > 0 {
+		ret.Thumbnail_url = vid.Thumbnail_URL[0]
+	}
+	for i, x := range vid.Chapters {
+		ret.Chapters[i] = ChapterJSON{ x.Name, int(x.Position.Seconds()) }
+	}
+	return ret
+}
+
+// ui has to have its config loaded, and is not to be used by anything else
+// while the server runs
+func New_server(ui *tui.UIState, refresh time.Duration) *Server {
+	if refresh <= 0 {
+		refresh = DEFAULT_REFRESH
+	}
+	return &Server{
+		Refresh: refresh,
+		ui: ui,
+		seen: make(map[string]bool),
+		subscribers: make(map[chan Change]bool),
+	}
+}
+
+// Refreshes every followed channel straight away and then every self.Refresh
+func (self *Server) Run(ctx context.Context) {
+	queue := make(chan src.VideoPacket, 100)
+	ticker := time.NewTicker(self.Refresh)
+	defer ticker.Stop()
+
+	self.mutex.RLock()
+	channels := slices.Clone(self.ui.Channel_list)
+	self.mutex.RUnlock()
+	tui.Refresh_channels(queue, channels...)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tui.Refresh_channels(queue, channels...)
+		case packet := <-queue:
+			self.Update(packet)
+		}
+	}
+}
+
+// Applies a refresh and tells the event streams about channels that went
+// live or offline. The first status of a channel is not a change.
+func (self *Server) Update(packet src.VideoPacket) {
+	if packet.Err != nil {
+		src.L_ERROR.Printf("%s", packet.Err)
+		return
+	}
+
+	var changes []Change
+	self.mutex.Lock()
+	if packet.Live {
+		vid := packet.Vids[0]
+		pair, is_followed := self.ui.Follow_latest[vid.Channel]
+		if is_followed && self.seen[vid.Channel] && pair.Live.Is_live != vid.Is_live {
+			change := Change{ Channel: vid.Channel, Is_live: vid.Is_live }
+			if vid.Is_live {
+				live := Video_json(vid)
+				change.Live = &live
+			}
+			changes = append(changes, change)
+		}
+		if is_followed {
+			self.seen[vid.Channel] = true
+		}
+	}
+	self.ui.Add_and_update_follow(packet)
+	self.mutex.Unlock()
+
+	for _, change := range changes {
+		self.publish(change)
+	}
+}
+
+func (self *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /channels", func(w http.ResponseWriter, r *http.Request) {
+		self.mutex.RLock()
+		defer self.mutex.RUnlock()
+		ret := make([]ChannelJSON, 0, len(self.ui.Channel_list))
+		for _, channel := range self.ui.Channel_list {
+			ret = append(ret, self.channel_json(channel))
+		}
+		write_json(w, r, ret)
+	})
+	mux.HandleFunc("GET /channels/{name}/videos", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		self.mutex.RLock()
+		defer self.mutex.RUnlock()
+		idx := slices.IndexFunc(self.ui.Channel_list, func(x string) bool { return strings.EqualFold(x, name) })
+		if idx < 0 {
+			http.Error(w, fmt.Sprintf("Not following %s", name), http.StatusNotFound)
+			return
+		}
+		channel := self.ui.Channel_list[idx]
+		ret := []VideoJSON{}
+		if live := self.ui.Follow_latest[channel].Live; live.Is_live {
+			ret = append(ret, Video_json(live))
+		}
+		var videos []src.Video
+		for _, vid := range self.ui.Cache.As_slice() {
+			if vid.Channel == channel {
+				videos = append(videos, vid)
+			}
+		}
+		slices.SortFunc(videos, src.Sort_videos_by_latest)
+		for _, vid := range videos {
+			ret = append(ret, Video_json(vid))
+		}
+		write_json(w, r, ret)
+	})
+	mux.HandleFunc("GET /live", func(w http.ResponseWriter, r *http.Request) {
+		self.mutex.RLock()
+		defer self.mutex.RUnlock()
+		ret := []VideoJSON{}
+		for _, channel := range self.ui.Channel_list {
+			if live := self.ui.Follow_latest[channel].Live; live.Is_live {
+				ret = append(ret, Video_json(live))
+			}
+		}
+		write_json(w, r, ret)
+	})
+	mux.HandleFunc("GET /feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		self.mutex.RLock()
+		var videos []src.Video
+		for _, vid := range self.ui.Cache.As_slice() {
+			if _, ok := self.ui.Follow_latest[vid.Channel]; ok {
+				videos = append(videos, vid)
+			}
+		}
+		self.mutex.RUnlock()
+		var body bytes.Buffer
+		if err := src.Write_atom(&body, videos, "http://" + r.Host + "/feed.xml"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		write_body(w, r, "application/atom+xml", body.Bytes())
+	})
+	mux.HandleFunc("GET /events", self.events)
+	return mux
+}
+
+func (self *Server) channel_json(channel string) ChannelJSON {
+	pair := self.ui.Follow_latest[channel]
+	ret := ChannelJSON{ Channel: channel, Is_live: pair.Live.Is_live }
+	if pair.Live.Is_live {
+		live := Video_json(pair.Live)
+		ret.Live = &live
+	}
+	// Load_config seeds a blank video until the first refresh
+	if pair.Latest.Url != "" {
+		latest := Video_json(pair.Latest)
+		ret.Latest = &latest
+	}
+	return ret
+}
+
+func write_json(w http.ResponseWriter, r *http.Request, value any) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	write_body(w, r, "application/json", body)
+}
+
+// The ETag is a hash of the body, so it changes exactly when the data does
+func write_body(w http.ResponseWriter, r *http.Request, content_type string, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etag_matches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", content_type)
+	_, _ = w.Write(body)
+}
+
+// If-None-Match is a comma separated list, weak tags match too
+func etag_matches(header string, etag string) bool {
+	for _, x := range strings.Split(header, ",") {
+		x = strings.TrimPrefix(strings.TrimSpace(x), "W/")
+		if x == etag || x == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Server-Sent Events
+
+func (self *Server) subscribe() chan Change {
+	ret := make(chan Change, 16)
+	self.subscriber_mutex.Lock()
+	self.subscribers[ret] = true
+	self.subscriber_mutex.Unlock()
+	return ret
+}
+
+func (self *Server) unsubscribe(subscriber chan Change) {
+	self.subscriber_mutex.Lock()
+	delete(self.subscribers, subscriber)
+	self.subscriber_mutex.Unlock()
+}
+
+// Clients that fall behind miss changes rather than hold up the refresh
+func (self *Server) publish(change Change) {
+	self.subscriber_mutex.Lock()
+	defer self.subscriber_mutex.Unlock()
+	for subscriber := range self.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+			src.L_DEBUG.Printf("Dropped a change of %s for a slow client", change.Channel)
+		}
+	}
+}
+
+func (self *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	subscriber := self.subscribe()
+	defer self.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(KEEPALIVE)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case change := <-subscriber:
+			event := "offline"
+			if change.Is_live {
+				event = "live"
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, src.Must(json.Marshal(change)))
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/tui"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test
+
+func test_server() *Server {
+	ui := &tui.UIState{}
+	ui.Load_config("tsoding\nquiet")
+	return New_server(ui, time.Hour)
+}
+
+func live_packet(channel string, is_live bool) src.VideoPacket {
+	vid := src.Video{ Channel: channel }
+	if is_live {
+		vid = src.Video{ Channel: channel, Url: "https://www.twitch.tv/" + channel, Is_live: true, Title: "Live", Start_time: time.Unix(1000, 0), Duration: time.Hour }
+	}
+	return src.VideoPacket{ Vids: []src.Video{vid}, Live: true }
+}
+
+func get(t *testing.T, handler http.Handler, path string, etag string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestEndpoints(t *testing.T) {
+	server := test_server()
+	handler := server.Handler()
+	server.Update(src.VideoPacket{ Vids: []src.Video{
+		{ Channel: "tsoding", Url: "https://www.twitch.tv/videos/1", Title: "Old", Start_time: time.Unix(100, 0), Duration: time.Hour, Chapters: []src.Chapter{{ Name: "Factorio", Position: time.Minute }} },
+		{ Channel: "tsoding", Url: "https://www.twitch.tv/videos/2", Title: "New", Start_time: time.Unix(500, 0), Duration: time.Hour },
+	}})
+	server.Update(live_packet("tsoding", true))
+
+	rec := get(t, handler, "/channels", "")
+	a.AssertEqual(t, http.StatusOK, rec.Code)
+	var channels []ChannelJSON
+	a.AssertEqual(t, nil, json.Unmarshal(rec.Body.Bytes(), &channels))
+	a.AssertEqual(t, 2, len(channels))
+	a.AssertEqual(t, true, channels[0].Is_live)
+	a.AssertEqual(t, "New", channels[0].Latest.Title)
+	a.AssertEqual(t, (*VideoJSON)(nil), channels[1].Latest)
+
+	// Unchanged data keeps its ETag
+	etag := rec.Header().Get("ETag")
+	a.AssertEqual(t, http.StatusNotModified, get(t, handler, "/channels", etag).Code)
+	a.AssertEqual(t, http.StatusNotModified, get(t, handler, "/channels", `"other", W/` + etag).Code)
+	server.Update(live_packet("tsoding", false))
+	a.AssertEqual(t, http.StatusOK, get(t, handler, "/channels", etag).Code)
+
+	var videos []VideoJSON
+	rec = get(t, handler, "/channels/Tsoding/videos", "")
+	a.AssertEqual(t, nil, json.Unmarshal(rec.Body.Bytes(), &videos))
+	a.AssertEqual(t, []string{"New", "Old"}, []string{videos[0].Title, videos[1].Title})
+	a.AssertEqual(t, []ChapterJSON{{ "Factorio", 60 }}, videos[1].Chapters)
+	a.AssertEqual(t, 3600, videos[1].Duration_seconds)
+	a.AssertEqual(t, http.StatusNotFound, get(t, handler, "/channels/nobody/videos", "").Code)
+
+	a.AssertEqual(t, "[]", get(t, handler, "/live", "").Body.String())
+
+	rec = get(t, handler, "/feed.xml", "")
+	a.AssertEqual(t, "application/atom+xml", rec.Header().Get("Content-Type"))
+	a.AssertEqual(t, 2, strings.Count(rec.Body.String(), "<entry>"))
+	a.AssertEqual(t, true, strings.Contains(rec.Body.String(), `<link rel="self" type="application/atom+xml" href="http://example.com/feed.xml"></link>`))
+	a.AssertEqual(t, http.StatusNotModified, get(t, handler, "/feed.xml", rec.Header().Get("ETag")).Code)
+}
+
+func TestEvents(t *testing.T) {
+	server := test_server()
+	http_server := httptest.NewServer(server.Handler())
+	defer http_server.Close()
+
+	resp, err := http.Get(http_server.URL + "/events")
+	a.AssertEqual(t, nil, err)
+	defer resp.Body.Close()
+	a.AssertEqual(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+	read_event := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			a.AssertEqual(t, nil, err)
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	a.AssertEqual(t, ": connected\n", read_event())
+
+	// The first status is not a change
+	server.Update(live_packet("tsoding", false))
+	server.Update(live_packet("tsoding", true))
+	server.Update(live_packet("tsoding", true))
+	server.Update(live_packet("tsoding", false))
+	live := Video_json(live_packet("tsoding", true).Vids[0])
+	a.AssertEqual(t, "event: live\ndata: " + string(src.Must(json.Marshal(Change{ "tsoding", true, &live }))) + "\n", read_event())
+	a.AssertEqual(t, "event: offline\ndata: {\"channel\":\"tsoding\",\"is_live\":false,\"live\":null}\n", read_event())
+}
@@ -43,6 +43,12 @@ const Move_cursor_fmt = "\x1B[{};{}H";
 const Enable_kitty_keyboard = "\x1B[>1u";
 const Disable_kitty_keyboard = "\x1B[<u";
 
+// Terminals that support the protocol answer the first with CSI ? flags u.
+// Every terminal answers the primary device attributes query, so if that
+// arrives first, the terminal does not support it.
+const Query_kitty_keyboard = "\x1B[?u";
+const Query_device_attributes = "\x1B[c";
+
 const Save_cursor_position = "\x1B[s";
 const Save_screen = "\x1B[?47h";
 const Enter_alt_buffer = "\x1B[?1049h";
@@ -55,6 +61,11 @@ const Clear = "\x1B[2J";
 
 const Reset_attributes = "\x1B[0m";
 
+// Bold and underline, ending it leaves colours untouched so it can be used
+// on top of the selection highlight
+const Start_highlight = "\x1B[1;4m";
+const End_highlight = "\x1B[22;24m";
+
 const Overwrite_mode = "\x1B[4l";
 
 // Per https://espterm.github.io/docs/VT100%20escape%20codes.html
@@ -68,12 +79,10 @@ const End_sync = "\x1BP=2s\x1B\\";
 
 // 1000: just button tracking.
 // 1003: all events, including movement.
+// 1006: SGR encoding, i.e. CSI < button ; x ; y M (or m on release)
 //
-// This enables standard button tracking. This is limited to maximum XY values
-// of 255 - 32 = 223. If anyone complains, we can switch to SGR (1006) encoding,
-// however since that is more annoying to parse I decided to take the easy route
-// for now. Also this does not provide movement events. Can be enabled with 1003,
-// but I don't see that being useful, so it's also not included right not. Let's
-// see if anyone complains.
-const Enable_mouse_tracking = "\x1B[?1000h";
-const Disable_mouse_tracking = "\x1B[?1000l";
+// The default encoding of 1000 is limited to maximum XY values of
+// 255 - 32 = 223, so we always pair it with SGR encoding. Movement events
+// (1003) are not enabled, since we do not have a use for hover.
+const Enable_mouse_tracking = "\x1B[?1000h\x1B[?1006h";
+const Disable_mouse_tracking = "\x1B[?1006l\x1B[?1000l";
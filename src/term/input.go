@@ -17,6 +17,10 @@
 package term
 
 import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -41,6 +45,12 @@ const (
 	TyPause
 	TyFunction
 	TyCodepoint
+	TyMouse // X is the column, Y the row (both 1-based), Button a MouseBtn
+
+	// Replies to queries rather than key presses
+	TyKeyboardFlags    // Reply to Query_kitty_keyboard, X is the flags
+	TyDeviceAttributes // Reply to Query_device_attributes, X is 1 if sixel is supported
+	TyGraphicsReply    // Reply to a kitty graphics command, X is 1 on OK
 )
 
 const (
@@ -55,32 +65,331 @@ const (
 type Event struct {
 	Ty int8
 	X_len int8
-	X rune
+	X rune // The codepoint for TyCodepoint, 1-12 for TyFunction, column for TyMouse
 	Y uint32
-	Button uint
+	Button int8
 
 	Mod_alt bool
 	Mod_ctrl bool
 	Mod_super bool
+	Mod_shift bool
 }
+
+// Bytes read from the terminal. Incomplete sequences (e.g. split across two
+// reads) are left in the buffer until more bytes are appended or Flush is
+// called.
 type InputParser []byte;
 
+// Sequences longer than this without a final byte are garbage
+const MAX_SEQUENCE_LEN = 64
+
+// How long to wait for the rest of an escape sequence before treating the
+// bytes as typed, e.g. a lone Escape press
+const ESCAPE_TIMEOUT = 25 * time.Millisecond
+
+// Returns nil if the buffer is empty or only holds an incomplete sequence
 func (self *InputParser) Next() *Event {
 	if 0 == len(*self) {
 		return nil
 	} else if '\x1B' == (*self)[0] {
-		self.advance(int8(len(*self)))
-		return &Event{ Ty: TyUnknown }
+		return self.escape()
+	} else if !utf8.FullRune(*self) {
+		return nil
+	} else {
+		x := self.utf8()
+		return &x
+	}
+}
+
+// Decodes whatever is left as if no more bytes are coming
+// The reader calls this once ESCAPE_TIMEOUT passes without new input
+func (self *InputParser) Flush() *Event {
+	buf := *self
+	if len(buf) == 0 {
+		return nil
+	} else if evt := self.Next(); evt != nil {
+		return evt
+	} else if len(buf) == 1 && buf[0] == '\x1B' {
+		self.advance(1)
+		return &Event{ Ty: TyEscape }
+	} else if len(buf) == 2 && buf[0] == '\x1B' {
+		// e.g. Alt-[ which we could not tell apart from the start of a CSI
+		self.advance(2)
+		return &Event{ Ty: TyCodepoint, X: rune(buf[1]), Mod_alt: true }
 	} else {
+		self.advance(len(buf))
+		return &Event{ Ty: TyUnknown }
+	}
+}
+
+func (self *InputParser) escape() *Event {
+	buf := *self
+	if len(buf) < 2 {
+		return nil
+	}
+	switch buf[1] {
+	case '[': return self.csi()
+	case 'O': return self.ss3()
+	case '_': return self.apc()
+	case '\x1B':
+		// Escape followed by another sequence
+		self.advance(1)
+		return &Event{ Ty: TyEscape }
+	default:
+		// Alt-prefixed key
+		if !utf8.FullRune(buf[1:]) {
+			return nil
+		}
+		self.advance(1)
 		x := self.utf8()
+		x.Mod_alt = true
 		return &x
 	}
-	
+}
+
+// Control Sequence Introducer, i.e. ESC [ <params> <final byte>
+func (self *InputParser) csi() *Event {
+	buf := *self
+	for i := 2; i < len(buf); i += 1 {
+		b := buf[i]
+		if 0x40 <= b && b <= 0x7E {
+			self.advance(i + 1)
+			x := decode_csi(string(buf[2:i]), b)
+			return &x
+		} else if b < 0x20 || b > 0x3F {
+			// Not a valid parameter or intermediate byte
+			self.advance(i)
+			return &Event{ Ty: TyUnknown }
+		}
+	}
+	if len(buf) > MAX_SEQUENCE_LEN {
+		self.advance(len(buf))
+		return &Event{ Ty: TyUnknown }
+	}
+	return nil
+}
+
+// Application Program Command, i.e. ESC _ <data> ESC \
+// Only kitty graphics replies (ESC _ G <keys> ; <message> ESC \) come this way
+func (self *InputParser) apc() *Event {
+	buf := *self
+	close := bytes.Index(buf[2:], []byte("\x1B\\"))
+	if close < 0 {
+		if len(buf) > MAX_SEQUENCE_LEN {
+			self.advance(len(buf))
+			return &Event{ Ty: TyUnknown }
+		}
+		return nil
+	}
+	data := string(buf[2:2 + close])
+	self.advance(2 + close + 2)
+
+	if reply, ok := strings.CutPrefix(data, "G"); ok {
+		_, message, _ := strings.Cut(reply, ";")
+		if message == "OK" {
+			return &Event{ Ty: TyGraphicsReply, X: 1 }
+		}
+		return &Event{ Ty: TyGraphicsReply }
+	}
+	return &Event{ Ty: TyUnknown }
+}
+
+// Single Shift 3, i.e. ESC O <final byte>, sent by F1-F4 and by arrows in
+// application cursor mode
+func (self *InputParser) ss3() *Event {
+	buf := *self
+	if len(buf) < 3 {
+		return nil
+	}
+	self.advance(3)
+	x := decode_final(buf[2])
+	return &x
+}
+
+// xterm sends modifiers as the second parameter, e.g. ESC [ 1 ; 5 A is Ctrl-Up
+func decode_csi(params string, final byte) Event {
+	var nums [2]int
+	if private, ok := strings.CutPrefix(params, "?"); ok {
+		switch final {
+		case 'u':
+			flags, _ := strconv.Atoi(private)
+			return Event{ Ty: TyKeyboardFlags, X: rune(flags) }
+		case 'c':
+			// The first parameter is the terminal class, 4 means sixel support
+			ret := Event{ Ty: TyDeviceAttributes }
+			for i, attr := range strings.Split(private, ";") {
+				if i > 0 && attr == "4" {
+					ret.X = 1
+				}
+			}
+			return ret
+		default:
+			return Event{ Ty: TyUnknown }
+		}
+	} else if mouse, ok := strings.CutPrefix(params, "<"); ok {
+		return decode_sgr_mouse(mouse, final)
+	} else if len(params) > 0 && (params[0] < '0' || params[0] > '9') && params[0] != ';' {
+		// Private sequences like ESC [ > ... we do not know about
+		return Event{ Ty: TyUnknown }
+	} else if final == 'u' {
+		return decode_kitty(params)
+	}
+	for i, part := range strings.SplitN(params, ";", 3) {
+		if i >= len(nums) {
+			break
+		}
+		// Ignore kitty-style sub-parameters, e.g. 1:3
+		part, _, _ = strings.Cut(part, ":")
+		if x, err := strconv.Atoi(part); err == nil {
+			nums[i] = x
+		}
+	}
+
+	var ret Event
+	if final == '~' {
+		ret = decode_tilde(nums[0])
+	} else {
+		ret = decode_final(final)
+	}
+	apply_modifiers(&ret, nums[1])
+	return ret
+}
+
+func decode_final(final byte) Event {
+	switch final {
+	case 'A': return Event{ Ty: TyArrowUp }
+	case 'B': return Event{ Ty: TyArrowDown }
+	case 'C': return Event{ Ty: TyArrowRight }
+	case 'D': return Event{ Ty: TyArrowLeft }
+	case 'E': return Event{ Ty: TyBegin }
+	case 'F': return Event{ Ty: TyEnd }
+	case 'H': return Event{ Ty: TyHome }
+	case 'P': return Event{ Ty: TyFunction, X: 1 }
+	case 'Q': return Event{ Ty: TyFunction, X: 2 }
+	case 'R': return Event{ Ty: TyFunction, X: 3 }
+	case 'S': return Event{ Ty: TyFunction, X: 4 }
+	case 'Z': return Event{ Ty: TyCodepoint, X: '\t', Mod_shift: true }
+	default: return Event{ Ty: TyUnknown }
+	}
+}
+
+// ESC [ <number> ~
+func decode_tilde(number int) Event {
+	switch number {
+	case 1, 7: return Event{ Ty: TyHome }
+	case 2: return Event{ Ty: TyInsert }
+	case 3: return Event{ Ty: TyDelete }
+	case 4, 8: return Event{ Ty: TyEnd }
+	case 5: return Event{ Ty: TyPageUp }
+	case 6: return Event{ Ty: TyPageDown }
+	case 11, 12, 13, 14, 15: return Event{ Ty: TyFunction, X: rune(number - 10) }
+	case 17, 18, 19, 20, 21: return Event{ Ty: TyFunction, X: rune(number - 11) }
+	case 23, 24: return Event{ Ty: TyFunction, X: rune(number - 12) }
+	default: return Event{ Ty: TyUnknown }
+	}
+}
+
+// CSI < button ; column ; row M (press) or m (release)
+func decode_sgr_mouse(params string, final byte) Event {
+	var nums [3]int
+	parts := strings.Split(params, ";")
+	if len(parts) != 3 || (final != 'M' && final != 'm') {
+		return Event{ Ty: TyUnknown }
+	}
+	for i, part := range parts {
+		if x, err := strconv.Atoi(part); err != nil {
+			return Event{ Ty: TyUnknown }
+		} else {
+			nums[i] = x
+		}
+	}
+
+	code := nums[0]
+	ret := Event{
+		Ty: TyMouse,
+		X: rune(nums[1]),
+		Y: uint32(nums[2]),
+		Mod_shift: code & 4 != 0,
+		Mod_alt: code & 8 != 0,
+		Mod_ctrl: code & 16 != 0,
+	}
+	switch {
+	case final == 'm': ret.Button = MouseRelease
+	case code & 64 != 0 && code & 1 == 0: ret.Button = MouseScrollUp
+	case code & 64 != 0: ret.Button = MouseScrollDown
+	case code & 3 == 0: ret.Button = MouseBtn1
+	case code & 3 == 1: ret.Button = MouseBtn2
+	case code & 3 == 2: ret.Button = MouseBtn3
+	default: ret.Button = MouseRelease
+	}
+	return ret
+}
+
+// https://sw.kovidgoyal.net/kitty/keyboard-protocol/
+// CSI key-code[:shifted-key[:base-key]] ; modifiers[:event-type] ; text u
+// We only enable "disambiguate escape codes", so there are no release events
+// and plain text still arrives as UTF-8.
+func decode_kitty(params string) Event {
+	fields := strings.Split(params, ";")
+	keys := strings.Split(fields[0], ":")
+	code, err := strconv.Atoi(keys[0])
+	if err != nil {
+		return Event{ Ty: TyUnknown }
+	}
+	var shifted int
+	if len(keys) >= 2 {
+		shifted, _ = strconv.Atoi(keys[1])
+	}
+	var mods int
+	if len(fields) >= 2 {
+		mod_str, _, _ := strings.Cut(fields[1], ":")
+		mods, _ = strconv.Atoi(mod_str)
+	}
+
+	var ret Event
+	switch {
+	case code == 27: ret = Event{ Ty: TyEscape }
+	case code == 13: ret = Event{ Ty: TyCodepoint, X: '\n' }
+	case code == 9: ret = Event{ Ty: TyCodepoint, X: '\t' }
+	case code == 127: ret = Event{ Ty: TyCodepoint, X: 127 }
+	case 57376 <= code && code <= 57398: ret = Event{ Ty: TyFunction, X: rune(code - 57376 + 13) }
+	case 57399 <= code && code <= 57408: ret = Event{ Ty: TyCodepoint, X: rune('0' + code - 57399) } // Keypad digits
+	case code >= 57344 && code <= 63743:
+		// Other private use area keys, e.g. modifier keys on their own
+		ret = Event{ Ty: TyUnknown }
+	default:
+		ret = Event{ Ty: TyCodepoint, X: rune(code) }
+	}
+	apply_modifiers(&ret, mods)
+
+	// Match legacy parsing where Shift-g is 'G' rather than 'g' with a modifier
+	if ret.Ty == TyCodepoint && ret.Mod_shift {
+		if shifted != 0 {
+			ret.X = rune(shifted)
+			ret.Mod_shift = false
+		} else if 'a' <= ret.X && ret.X <= 'z' {
+			ret.X = ret.X - 'a' + 'A'
+			ret.Mod_shift = false
+		}
+	}
+	return ret
+}
+
+// The parameter is 1 + a bitmask of shift (1), alt (2), ctrl (4), super (8)
+func apply_modifiers(evt *Event, param int) {
+	if param < 2 {
+		return
+	}
+	mask := param - 1
+	evt.Mod_shift = evt.Mod_shift || mask & 1 != 0
+	evt.Mod_alt = mask & 2 != 0
+	evt.Mod_ctrl = mask & 4 != 0
+	evt.Mod_super = mask & 8 != 0
 }
 
 func (self *InputParser) utf8() Event {
-	var advance int8 = 1
-	defer self.advance(advance)
+	advance := 1
+	defer func() { self.advance(advance) }()
 
 	switch (*self)[0] {
 	case 'a' & '\x1F': return Event{ Ty: TyCodepoint, X: 'a', Mod_ctrl: true }
@@ -111,7 +420,7 @@ func (self *InputParser) utf8() Event {
 	case 'z' & '\x1F': return Event{ Ty: TyCodepoint, X: 'z', Mod_ctrl: true }
 	default:
 		char, size := utf8.DecodeRune(*self)
-		advance = int8(size) // for defer
+		advance = size // for defer
 		if char == utf8.RuneError {
 			return Event{ Ty: TyUnknown }
 		} else {
@@ -121,9 +430,9 @@ func (self *InputParser) utf8() Event {
 
 }
 
-func (self *InputParser) advance(byte_count int8) {
+func (self *InputParser) advance(byte_count int) {
 	length := len(*self)
-	if int(byte_count) < length {
+	if byte_count < length {
 		*self = (*self)[byte_count:]
 	} else {
 		*self = (*self)[length:length]
@@ -131,3 +440,40 @@ func (self *InputParser) advance(byte_count int8) {
 }
 
 
+
+// Reads stdin forever, decoding events into the channel
+// Run this in its own goroutine since reading blocks
+func Read_events(fd int, events chan<- Event) {
+	chunks := make(chan []byte, 32)
+	go func() {
+		for {
+			buffer := make([]byte, 64)
+			if n, err := Sys_read(fd, buffer); err != nil || n < 1 {
+				continue
+			} else {
+				chunks <- buffer[:n]
+			}
+		}
+	}()
+
+	var parser InputParser
+	for {
+		if len(parser) == 0 {
+			parser = append(parser, <-chunks...)
+		} else {
+			// We are holding on to a partial sequence
+			select {
+			case chunk := <-chunks:
+				parser = append(parser, chunk...)
+			case <-time.After(ESCAPE_TIMEOUT):
+				for evt := parser.Flush(); evt != nil; evt = parser.Flush() {
+					events <- *evt
+				}
+				continue
+			}
+		}
+		for evt := parser.Next(); evt != nil; evt = parser.Next() {
+			events <- *evt
+		}
+	}
+}
@@ -4,6 +4,8 @@ package term
 import (
 	"fmt"
 	"testing"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
 )
 
 //run: go test
@@ -15,3 +17,137 @@ func TestA(t *testing.T) {
 	t.Log(parser)
 }
 
+
+func parse_all(input string) []Event {
+	var parser InputParser = []byte(input)
+	ret := []Event{}
+	for evt := parser.Flush(); evt != nil; evt = parser.Flush() {
+		ret = append(ret, *evt)
+	}
+	return ret
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  []Event
+	}{
+		{ "ascii", "ab", []Event{{ Ty: TyCodepoint, X: 'a' }, { Ty: TyCodepoint, X: 'b' }} },
+		{ "unicode", "○", []Event{{ Ty: TyCodepoint, X: '○' }} },
+		{ "ctrl", "\x04", []Event{{ Ty: TyCodepoint, X: 'd', Mod_ctrl: true }} },
+		{ "enter", "\r", []Event{{ Ty: TyCodepoint, X: '\n' }} },
+		{ "lone escape", "\x1B", []Event{{ Ty: TyEscape }} },
+		{ "double escape", "\x1B\x1B", []Event{{ Ty: TyEscape }, { Ty: TyEscape }} },
+		{ "alt", "\x1Bj", []Event{{ Ty: TyCodepoint, X: 'j', Mod_alt: true }} },
+		{ "alt unicode", "\x1B○", []Event{{ Ty: TyCodepoint, X: '○', Mod_alt: true }} },
+		{ "alt bracket", "\x1B[", []Event{{ Ty: TyCodepoint, X: '[', Mod_alt: true }} },
+		{ "shift tab", "\x1B[Z", []Event{{ Ty: TyCodepoint, X: '\t', Mod_shift: true }} },
+
+		{ "up", "\x1B[A", []Event{{ Ty: TyArrowUp }} },
+		{ "down", "\x1B[B", []Event{{ Ty: TyArrowDown }} },
+		{ "right", "\x1B[C", []Event{{ Ty: TyArrowRight }} },
+		{ "left", "\x1B[D", []Event{{ Ty: TyArrowLeft }} },
+		{ "ss3 up", "\x1BOA", []Event{{ Ty: TyArrowUp }} },
+		{ "home", "\x1B[H", []Event{{ Ty: TyHome }} },
+		{ "end", "\x1B[F", []Event{{ Ty: TyEnd }} },
+		{ "ss3 home", "\x1BOH", []Event{{ Ty: TyHome }} },
+		{ "vt home", "\x1B[1~", []Event{{ Ty: TyHome }} },
+		{ "vt end", "\x1B[4~", []Event{{ Ty: TyEnd }} },
+		{ "rxvt home", "\x1B[7~", []Event{{ Ty: TyHome }} },
+		{ "insert", "\x1B[2~", []Event{{ Ty: TyInsert }} },
+		{ "delete", "\x1B[3~", []Event{{ Ty: TyDelete }} },
+		{ "page up", "\x1B[5~", []Event{{ Ty: TyPageUp }} },
+		{ "page down", "\x1B[6~", []Event{{ Ty: TyPageDown }} },
+
+		{ "F1", "\x1BOP", []Event{{ Ty: TyFunction, X: 1 }} },
+		{ "F4", "\x1BOS", []Event{{ Ty: TyFunction, X: 4 }} },
+		{ "F1 vt", "\x1B[11~", []Event{{ Ty: TyFunction, X: 1 }} },
+		{ "F5", "\x1B[15~", []Event{{ Ty: TyFunction, X: 5 }} },
+		{ "F6", "\x1B[17~", []Event{{ Ty: TyFunction, X: 6 }} },
+		{ "F10", "\x1B[21~", []Event{{ Ty: TyFunction, X: 10 }} },
+		{ "F11", "\x1B[23~", []Event{{ Ty: TyFunction, X: 11 }} },
+		{ "F12", "\x1B[24~", []Event{{ Ty: TyFunction, X: 12 }} },
+
+		{ "shift up", "\x1B[1;2A", []Event{{ Ty: TyArrowUp, Mod_shift: true }} },
+		{ "alt up", "\x1B[1;3A", []Event{{ Ty: TyArrowUp, Mod_alt: true }} },
+		{ "ctrl up", "\x1B[1;5A", []Event{{ Ty: TyArrowUp, Mod_ctrl: true }} },
+		{ "ctrl shift left", "\x1B[1;6D", []Event{{ Ty: TyArrowLeft, Mod_ctrl: true, Mod_shift: true }} },
+		{ "super home", "\x1B[1;9H", []Event{{ Ty: TyHome, Mod_super: true }} },
+		{ "ctrl delete", "\x1B[3;5~", []Event{{ Ty: TyDelete, Mod_ctrl: true }} },
+		{ "ctrl alt F5", "\x1B[15;7~", []Event{{ Ty: TyFunction, X: 5, Mod_ctrl: true, Mod_alt: true }} },
+		{ "shift F1", "\x1B[1;2P", []Event{{ Ty: TyFunction, X: 1, Mod_shift: true }} },
+
+		{ "sequence then key", "\x1B[Aj", []Event{{ Ty: TyArrowUp }, { Ty: TyCodepoint, X: 'j' }} },
+		{ "two sequences", "\x1B[6~\x1B[5~", []Event{{ Ty: TyPageDown }, { Ty: TyPageUp }} },
+		{ "escape then sequence", "\x1B\x1B[B", []Event{{ Ty: TyEscape }, { Ty: TyArrowDown }} },
+		{ "unknown csi", "\x1B[99~q", []Event{{ Ty: TyUnknown }, { Ty: TyCodepoint, X: 'q' }} },
+		{ "private csi", "\x1B[?1h", []Event{{ Ty: TyUnknown }} },
+		{ "truncated csi", "\x1B[1;5", []Event{{ Ty: TyUnknown }} },
+
+		{ "kitty flags", "\x1B[?1u", []Event{{ Ty: TyKeyboardFlags, X: 1 }} },
+		{ "kitty no flags", "\x1B[?0u", []Event{{ Ty: TyKeyboardFlags }} },
+		{ "device attributes", "\x1B[?62;22c", []Event{{ Ty: TyDeviceAttributes }} },
+		{ "sixel attributes", "\x1B[?62;4;22c", []Event{{ Ty: TyDeviceAttributes, X: 1 }} },
+		{ "graphics ok", "\x1B_Gi=31;OK\x1B\\", []Event{{ Ty: TyGraphicsReply, X: 1 }} },
+		{ "graphics error", "\x1B_Gi=31;ENOENT:bad\x1B\\q", []Event{{ Ty: TyGraphicsReply }, { Ty: TyCodepoint, X: 'q' }} },
+		{ "kitty escape", "\x1B[27u", []Event{{ Ty: TyEscape }} },
+		{ "kitty enter", "\x1B[13u", []Event{{ Ty: TyCodepoint, X: '\n' }} },
+		{ "kitty ctrl c", "\x1B[99;5u", []Event{{ Ty: TyCodepoint, X: 'c', Mod_ctrl: true }} },
+		{ "kitty ctrl i", "\x1B[105;5u", []Event{{ Ty: TyCodepoint, X: 'i', Mod_ctrl: true }} },
+		{ "kitty alt shift", "\x1B[106;4u", []Event{{ Ty: TyCodepoint, X: 'J', Mod_alt: true }} },
+		{ "kitty shifted key", "\x1B[49:33;6u", []Event{{ Ty: TyCodepoint, X: '!', Mod_ctrl: true }} },
+		{ "kitty super", "\x1B[107;9u", []Event{{ Ty: TyCodepoint, X: 'k', Mod_super: true }} },
+		{ "kitty ctrl super", "\x1B[113;13u", []Event{{ Ty: TyCodepoint, X: 'q', Mod_ctrl: true, Mod_super: true }} },
+		{ "kitty super up", "\x1B[1;9A", []Event{{ Ty: TyArrowUp, Mod_super: true }} },
+		{ "kitty F3", "\x1B[13~", []Event{{ Ty: TyFunction, X: 3 }} },
+		{ "kitty F13", "\x1B[57376u", []Event{{ Ty: TyFunction, X: 13 }} },
+		{ "kitty keypad", "\x1B[57400u", []Event{{ Ty: TyCodepoint, X: '1' }} },
+		{ "kitty lone shift", "\x1B[57441;2u", []Event{{ Ty: TyUnknown, Mod_shift: true }} },
+		{ "mouse click", "\x1B[<0;12;5M", []Event{{ Ty: TyMouse, X: 12, Y: 5, Button: MouseBtn1 }} },
+		{ "mouse release", "\x1B[<0;12;5m", []Event{{ Ty: TyMouse, X: 12, Y: 5, Button: MouseRelease }} },
+		{ "mouse right", "\x1B[<2;1;1M", []Event{{ Ty: TyMouse, X: 1, Y: 1, Button: MouseBtn3 }} },
+		{ "mouse far", "\x1B[<0;300;400M", []Event{{ Ty: TyMouse, X: 300, Y: 400, Button: MouseBtn1 }} },
+		{ "mouse ctrl click", "\x1B[<16;3;4M", []Event{{ Ty: TyMouse, X: 3, Y: 4, Button: MouseBtn1, Mod_ctrl: true }} },
+		{ "scroll up", "\x1B[<64;3;4M", []Event{{ Ty: TyMouse, X: 3, Y: 4, Button: MouseScrollUp }} },
+		{ "scroll down", "\x1B[<65;3;4M", []Event{{ Ty: TyMouse, X: 3, Y: 4, Button: MouseScrollDown }} },
+		{ "mouse garbage", "\x1B[<0;3M", []Event{{ Ty: TyUnknown }} },
+		{ "kitty event type", "\x1B[97;5:1u", []Event{{ Ty: TyCodepoint, X: 'a', Mod_ctrl: true }} },
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a.AssertEqual(t, c.want, parse_all(c.input))
+		})
+	}
+}
+
+// Reads can end at any byte, the parser should hold on to the partial
+// sequence until the rest arrives
+func TestParseSplit(t *testing.T) {
+	inputs := []string{"\x1B[1;5A", "\x1BOP", "\x1B[24~", "○", "\x1Bj", "\x1B_Gi=31;OK\x1B\\"}
+	for _, input := range inputs {
+		want := parse_all(input)
+		for split := 1; split < len(input); split += 1 {
+			var parser InputParser = []byte(input[:split])
+			got := []Event{}
+			for evt := parser.Next(); evt != nil; evt = parser.Next() {
+				got = append(got, *evt)
+			}
+			parser = append(parser, input[split:]...)
+			for evt := parser.Next(); evt != nil; evt = parser.Next() {
+				got = append(got, *evt)
+			}
+			a.AssertEqual(t, want, got)
+			a.AssertEqual(t, 0, len(parser))
+		}
+	}
+}
+
+func TestParsePending(t *testing.T) {
+	var parser InputParser = []byte("\x1B")
+	a.AssertEqual(t, (*Event)(nil), parser.Next())
+	a.AssertEqual(t, 1, len(parser))
+	a.AssertEqual(t, Event{ Ty: TyEscape }, *parser.Flush())
+	a.AssertEqual(t, (*Event)(nil), parser.Flush())
+}
@@ -2,7 +2,13 @@
 
 package term
 
-import "syscall"
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
 
 func Sys_read(fd int, p []byte) (int, error) {
 	return syscall.Read(fd, p)
@@ -10,3 +16,17 @@ func Sys_read(fd int, p []byte) (int, error) {
 func Sys_set_nonblock(fd int, nonblock bool) error {
 	return syscall.SetNonblock(fd, nonblock)
 }
+
+// Delivers on the channel whenever the terminal is resized
+func Sys_notify_resize(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGWINCH)
+}
+
+// Pixel size of a character cell, 0 if the terminal does not report it
+func Sys_cell_size(fd int) (int, int) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 0, 0
+	}
+	return int(ws.Xpixel) / int(ws.Col), int(ws.Ypixel) / int(ws.Row)
+}
@@ -3,6 +3,7 @@
 package term
 
 import (
+	"os"
 	"syscall"
 )
 
@@ -12,3 +13,11 @@ func Sys_read(fd int, p []byte) (int, error) {
 func Sys_set_nonblock(fd int, nonblock bool) error {
 	return syscall.SetNonblock(syscall.Handle(uintptr(fd)), nonblock)
 }
+
+// Windows has no SIGWINCH, resizes are only picked up on startup
+func Sys_notify_resize(c chan os.Signal) {
+}
+
+func Sys_cell_size(fd int) (int, int) {
+	return 0, 0
+}
@@ -0,0 +1,133 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// Usual stream times guessed from past broadcasts, for channels that do not
+// publish a schedule
+
+type HistoryReply struct {
+	Channel string
+	Videos  []src.Video
+	Err     error
+}
+
+// Pages of past broadcasts fetched when a channel is opened, on top of the
+// one page the refresh gets
+const HISTORY_PAGES = 5
+
+// How far ahead a usual stream time counts as soon
+const LIKELY_SOON = 3 * time.Hour
+
+// Less confident guesses are only shown on the channel screen, not as hints
+const LIKELY_MIN_CONFIDENCE = 0.5
+
+// Only the first time a channel is opened
+func (self *UIState) history_fetch(channel string) {
+	key := channel + " history"
+	if self.Channel_fetched[key] {
+		return
+	}
+	if self.Channel_fetched == nil {
+		self.Channel_fetched = make(map[string]bool)
+	}
+	self.Channel_fetched[key] = true
+	queue := self.History_queue
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 60 * time.Second)
+		defer cancel()
+		videos, err := src.Graph_vod_history(ctx, channel, HISTORY_PAGES)
+		queue <- HistoryReply{ channel, videos, err }
+	}()
+}
+
+// Pages that did arrive are still used
+func (self *UIState) history_update(reply HistoryReply) {
+	if reply.Err != nil {
+		_, _ = self.Message.WriteString(reply.Err.Error() + "\n")
+	}
+	if self.History == nil {
+		self.History = make(map[string][]src.Video)
+	}
+	self.History[reply.Channel] = reply.Videos
+	self.activity_update(reply.Channel)
+}
+
+// Build_activity skips the hours it has already seen, so the same stream in
+// the cache, the history and as the live stream only counts once
+func (self *UIState) activity_update(channel string) {
+	var videos []src.Video
+	for _, vid := range self.Cache.As_slice() {
+		if vid.Channel == channel {
+			videos = append(videos, vid)
+		}
+	}
+	videos = append(videos, self.History[channel]...)
+	if live, ok := self.live_stream(channel); ok {
+		videos = append(videos, live)
+	}
+	if self.Activity == nil {
+		self.Activity = make(map[string]src.Activity)
+	}
+	self.Activity[channel] = src.Build_activity(videos, time.Now(), time.Local)
+}
+
+func (self UIState) live_stream(channel string) (src.Video, bool) {
+	if pair, ok := self.Follow_latest[channel]; ok {
+		return pair.Live, pair.Live.Is_live
+	}
+	return self.Channel_live, self.Channel_live.Channel == channel && self.Channel_live.Is_live
+}
+
+// The start of the channel's usual stream if it is within LIKELY_SOON, or
+// has started without the channel going live yet
+func (self UIState) likely_soon(channel string, now time.Time) (time.Time, src.LiveWindow, bool) {
+	if _, is_live := self.live_stream(channel); is_live {
+		return time.Time{}, src.LiveWindow{}, false
+	}
+	start, window, ok := self.Activity[channel].Next_window(now, time.Local)
+	if !ok || start.Sub(now) > LIKELY_SOON || window.Confidence < LIKELY_MIN_CONFIDENCE {
+		return time.Time{}, src.LiveWindow{}, false
+	}
+	return start, window, true
+}
+
+// e.g. "Likely live soon (Tue 18:00, in 2h), usually live Tue/Thu 18:00–22:00 (80%)"
+func (self UIState) activity_hint(channel string, now time.Time) string {
+	summary := self.Activity[channel].Summary()
+	if summary == "" {
+		return ""
+	}
+	if start, _, ok := self.likely_soon(channel, now); ok {
+		return fmt.Sprintf("Likely live soon (%s), %s", format_when(start.Local(), now), summary)
+	}
+	return strings.ToUpper(summary[:1]) + summary[1:]
+}
+
+// Followed channels without a scheduled stream that are likely live soon, as
+// rows for the upcoming section
+func (self UIState) predicted(now time.Time, scheduled []src.ScheduleSegment) []src.ScheduleSegment {
+	var ret []src.ScheduleSegment
+	for _, channel := range self.Channel_list {
+		if slices.ContainsFunc(scheduled, func(x src.ScheduleSegment) bool { return x.Channel == channel }) {
+			continue
+		}
+		if start, window, ok := self.likely_soon(channel, now); ok {
+			ret = append(ret, src.ScheduleSegment{
+				Channel: channel,
+				Title: fmt.Sprintf("Likely live soon, usually %s (%.0f%%)", window, window.Confidence * 100),
+				Start_time: start,
+				End_time: start.Add(time.Duration(window.Hours) * time.Hour),
+			})
+		}
+	}
+	return ret
+}
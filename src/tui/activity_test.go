@@ -0,0 +1,54 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run 'Activity|Predicted'
+
+// Streams every week for the past four weeks, starting two hours after now
+func weekly_streams(channel string, now time.Time) []src.Video {
+	local := now.Local()
+	start := time.Date(local.Year(), local.Month(), local.Day(), local.Hour() + 2, 0, 0, 0, time.Local)
+	var ret []src.Video
+	for week := 1; week <= 4; week += 1 {
+		x := start.AddDate(0, 0, -7 * week)
+		ret = append(ret, src.Video{ Channel: channel, Url: x.String(), Start_time: x, Duration: 2 * time.Hour, Broadcast_type: src.BroadcastArchive })
+	}
+	return ret
+}
+
+func TestActivityHint(t *testing.T) {
+	now := time.Now()
+	ui := &UIState{}
+	ui.Load_config("a\nb\nc")
+	for _, channel := range ui.Channel_list {
+		for _, vid := range weekly_streams(channel, now) {
+			ui.Cache.Push(vid)
+		}
+		ui.activity_update(channel)
+	}
+	live := ui.Follow_latest["b"]
+	live.Live = src.Video{ Channel: "b", Is_live: true, Start_time: now, Duration: time.Minute }
+	ui.Follow_latest["b"] = live
+
+	hint := ui.activity_hint("a", now)
+	a.AssertEqual(t, true, strings.HasPrefix(hint, "Likely live soon ("))
+	a.AssertEqual(t, true, strings.HasSuffix(hint, "in 1h), usually live " + ui.Activity["a"].Windows()[0].String() + " (100%)"))
+	// Already live
+	a.AssertEqual(t, true, strings.HasPrefix(ui.activity_hint("b", now), "Usually live "))
+	a.AssertEqual(t, "", ui.activity_hint("nobody", now))
+
+	// A schedule beats a guess
+	ui.schedule_update(ScheduleReply{ Channel: "c", Segments: []src.ScheduleSegment{{ Channel: "c", Title: "Planned", Start_time: now.Add(time.Hour) }} })
+	upcoming := ui.upcoming(now)
+	a.AssertEqual(t, 2, len(upcoming))
+	a.AssertEqual(t, "Planned", upcoming[0].Title)
+	a.AssertEqual(t, "a", upcoming[1].Channel)
+	a.AssertEqual(t, true, strings.HasPrefix(upcoming[1].Title, "Likely live soon, usually "))
+}
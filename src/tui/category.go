@@ -0,0 +1,179 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/term"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// Category screen, the top live streams of a category (game)
+
+type CategoryReply struct {
+	Category string
+	Videos   []src.Video
+	Err      error
+}
+
+const CATEGORY_FOOTER_ROWS = 3 + MESSAGE_ROWS
+
+// Shows what we last fetched for the category straight away, and only
+// fetches it the first time
+func (self *UIState) category_open(category string) {
+	self.Filter.Clear()
+	if self.Screen != ScreenCategory {
+		self.Category_return = self.Screen
+	}
+	self.Screen = ScreenCategory
+	self.Category = category
+	self.Category_selection = 0
+	self.Category_viewport.Offset = 0
+	videos, ok := self.Category_latest[category]
+	self.Category_videos = videos
+	self.Filter.Update(self.Category_videos)
+	if !ok {
+		self.category_refresh()
+	}
+}
+
+func (self *UIState) category_refresh() {
+	category, queue := self.Category, self.Category_queue
+	_, _ = self.Message.WriteString(fmt.Sprintf("Loading %s\n", category))
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+		defer cancel()
+		videos, err := src.Graph_directory(ctx, category)
+		queue <- CategoryReply{ category, videos, err }
+	}()
+}
+
+func (self *UIState) category_update(reply CategoryReply) {
+	if reply.Err != nil {
+		_, _ = self.Message.WriteString(reply.Err.Error() + "\n")
+		return
+	}
+	if self.Category_latest == nil {
+		self.Category_latest = make(map[string][]src.Video)
+	}
+	self.Category_latest[reply.Category] = reply.Videos
+	if self.Screen != ScreenCategory || self.Category != reply.Category {
+		return
+	}
+
+	// Viewer counts reorder the list, so keep the selection on the same stream
+	var selected string
+	if vid, ok := self.category_selected(); ok {
+		selected = vid.Url
+	}
+	self.Category_videos = reply.Videos
+	self.Filter.Update(self.Category_videos)
+	idx := max(slices.IndexFunc(self.Category_videos, func(x src.Video) bool { return x.Url == selected }), 0)
+	self.Category_selection = uint16(self.Filter.Settle(len(self.Category_videos), idx))
+	_, _ = self.Message.WriteString(fmt.Sprintf("Refreshed %s\n", reply.Category))
+}
+
+// Cycles through the categories from the config
+func (self *UIState) category_step(delta int) {
+	count := len(self.Categories)
+	if count == 0 {
+		_, _ = self.Message.WriteString("No categories to browse, add some to \"categories\" in config.json\n")
+		return
+	}
+	idx := slices.Index(self.Categories, self.Category)
+	if idx < 0 {
+		idx = 0
+	} else {
+		idx = ((idx + delta) % count + count) % count
+	}
+	self.category_open(self.Categories[idx])
+}
+
+func (self UIState) category_selected() (src.Video, bool) {
+	idx := int(self.Category_selection)
+	if idx >= len(self.Category_videos) || !self.Filter.Includes(idx) {
+		return src.Video{}, false
+	}
+	return self.Category_videos[idx], true
+}
+
+func (self *UIState) category_input(event term.Event, cancel context.CancelFunc) bool {
+	self.Message.Reset()
+	length := len(self.Category_videos)
+	if self.filter_input(event, self.Category_videos, &self.Category_selection) {
+		return false
+	}
+	if event.Ty == term.TyMouse {
+		if list_scroll(event, length, self.Filter, &self.Category_selection) {
+		} else if self.list_click(event, length, self.Filter, self.Category_viewport, &self.Category_selection) {
+			self.category_play()
+		}
+		return false
+	}
+
+	action := self.resolve_key(event)
+	switch action {
+	case ActionBack:
+		self.Filter.Clear()
+		self.Screen = self.Category_return
+	case ActionRefresh:
+		self.category_refresh()
+	case ActionCategory_next:
+		self.category_step(1)
+	case ActionCategory_prev:
+		self.category_step(-1)
+	case ActionPlay:
+		self.category_play()
+	case ActionOpen:
+		if vid, ok := self.category_selected(); ok {
+			self.Filter.Clear()
+			self.Channel_live = vid
+			self.Channel_return = ScreenCategory
+			self.Channel_selection = 0
+			self.Channel_viewport.Offset = 0
+			Refresh_channels(self.Refresh_queue, vid.Channel)
+			self.channel_swap(vid.Channel)
+		}
+	case ActionChat:
+		if vid, ok := self.category_selected(); ok {
+			self.chat_open(vid)
+		}
+	default:
+		return self.list_action(action, cancel, length, self.Category_viewport, &self.Category_selection)
+	}
+	return false
+}
+
+func (self *UIState) category_play() {
+	vid, ok := self.category_selected()
+	if !ok {
+		return
+	}
+	_, _ = self.Message.WriteString(fmt.Sprintf("Playing %s\n", vid.Url))
+	go streamlink(context.Background(), self.Log_queue, src.Streamlink_args(vid, "")...)
+}
+
+func (self UIState) category_render(writer *bufio.Writer) {
+	rows := visible_rows(len(self.Category_videos), self.Filter)
+	fmt.Fprintf(writer, "Category %s", self.Category)
+	if idx := slices.Index(self.Categories, self.Category); idx >= 0 {
+		fmt.Fprintf(writer, " (%d/%d)", idx + 1, len(self.Categories))
+	}
+	fmt.Fprintf(writer, " %s", self.Category_viewport.Indicator(len(rows)))
+	render_video_list(writer, self.Width, self.Category_selection, self.Category_videos, self.Filter, self.Category_viewport)
+
+	render_footer_start(writer, self.Height, CATEGORY_FOOTER_ROWS)
+	self.filter_render(writer)
+	fmt.Fprint(writer, "\r\n")
+	self.render_hints(writer, ActionQuit, ActionBack, ActionPlay, ActionOpen, ActionChat, ActionCategory_next, ActionFilter, ActionHelp)
+	fmt.Fprint(writer, "\r\n")
+	if vid, ok := self.category_selected(); ok {
+		fmt.Fprintf(writer, "%s viewers | %s", format_count(vid.Viewers), vid.Url)
+	}
+	fmt.Fprint(writer, "\r\n")
+	render_message(writer, self.Message.String())
+}
@@ -0,0 +1,52 @@
+package tui
+
+import (
+	"testing"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/term"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run Category
+
+func TestCategoryScreen(t *testing.T) {
+	ui := &UIState{}
+	ui.Load_config("tsoding")
+	ui.Keymap = src.Must(New_keymap(nil))
+	ui.Categories = []string{"Factorio", "Celeste"}
+	key := func(r rune) {
+		ui.category_input(term.Event{ Ty: term.TyCodepoint, X: r }, nil)
+	}
+	stream := func(channel string, viewers int) src.Video {
+		return src.Video{ Channel: channel, Url: "https://www.twitch.tv/" + channel, Is_live: true, Viewers: viewers }
+	}
+
+	ui.follow_input(term.Event{ Ty: term.TyCodepoint, X: 'b' }, nil)
+	a.AssertEqual(t, ScreenCategory, ui.Screen)
+	a.AssertEqual(t, "Factorio", ui.Category)
+	ui.category_update(CategoryReply{ Category: "Factorio", Videos: []src.Video{stream("a", 30), stream("b", 20)} })
+	a.AssertEqual(t, 2, len(ui.Category_videos))
+
+	// The selection follows the stream when a refresh reorders the list
+	key('j')
+	ui.category_update(CategoryReply{ Category: "Factorio", Videos: []src.Video{stream("b", 50), stream("a", 30)} })
+	a.AssertEqual(t, uint16(0), ui.Category_selection)
+
+	// Replies for other categories are kept for later
+	key(']')
+	a.AssertEqual(t, "Celeste", ui.Category)
+	ui.category_update(CategoryReply{ Category: "Factorio", Videos: []src.Video{stream("c", 1)} })
+	a.AssertEqual(t, 0, len(ui.Category_videos))
+	key('[')
+	a.AssertEqual(t, []src.Video{stream("c", 1)}, ui.Category_videos)
+
+	// Streams of channels we do not follow open like search results
+	key('o')
+	a.AssertEqual(t, ScreenChannel, ui.Screen)
+	a.AssertEqual(t, []src.Video{stream("c", 1)}, ui.Channel_videos.As_slice())
+	ui.channel_input(term.Event{ Ty: term.TyCodepoint, X: 'h' }, nil)
+	a.AssertEqual(t, ScreenCategory, ui.Screen)
+	key('h')
+	a.AssertEqual(t, ScreenFollow, ui.Screen)
+}
@@ -0,0 +1,496 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/auth"
+	"github.com/yueleshia/streamsurf/src/chat"
+	"github.com/yueleshia/streamsurf/src/term"
+)
+
+const (
+	CHAT_FOOTER_ROWS = 2 + MESSAGE_ROWS
+	// Messages shown on either side of a focused message
+	CHAT_CONTEXT_ROWS = 3
+	CHAT_TIME_FORMAT = "15:04"
+)
+
+// Typed with '/', then n and N (by default) step through the matches
+type ChatSearch struct {
+	Is_typing bool
+	Query     []rune
+}
+
+// Connects to the chat of vid's channel, keeping the scrollback if it is the
+// chat we already have open
+func (self *UIState) chat_open(vid src.Video) {
+	if vid.Channel == "" {
+		return
+	}
+	if self.Screen != ScreenChat {
+		self.Chat_return = self.Screen
+	}
+	self.Filter.Clear()
+	self.Screen = ScreenChat
+	if self.Chat_cancel != nil && strings.EqualFold(self.Chat_channel, vid.Channel) {
+		return
+	}
+
+	self.chat_close()
+	self.Chat.Clear()
+	self.Chat_channel = vid.Channel
+	self.Chat_channel_id = vid.Channel_id
+	self.Chat_selection = -1
+	self.Chat_focus = -1
+	self.Chat_search = ChatSearch{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	self.Chat_cancel = cancel
+	client := chat.New_client()
+	self.Chat_client = client
+	queue, errs, session := self.Chat_queue, self.Chat_error, self.Session
+	go func() {
+		// Logged out is fine, chat can be read anonymously
+		if session != nil {
+			if token, err := session.Token(ctx); err == nil {
+				client.Log_in(token.Login, token.Access_token)
+			} else if !errors.Is(err, auth.ErrNoToken) {
+				queue <- chat.Notice(vid.Channel, fmt.Sprintf("Reading chat anonymously: %s", err))
+			}
+		}
+		if err := chat.Listen(ctx, client, vid.Channel, queue); err != nil {
+			errs <- fmt.Errorf("Chat of %s: %w", vid.Channel, err)
+		}
+	}()
+
+	if self.Emotes != nil {
+		registry, loaded := self.Emotes, self.Emotes_loaded
+		go func() {
+			err := registry.Load_global()
+			// Placeholders for channels that have not loaded yet have no ID
+			if vid.Channel_id != "" {
+				err = errors.Join(err, registry.Load_channel(vid.Channel_id))
+			}
+			loaded <- err
+		}()
+	}
+}
+
+func (self *UIState) chat_close() {
+	if self.Chat_cancel != nil {
+		self.Chat_cancel()
+		self.Chat_cancel = nil
+	}
+	self.Chat_client = nil
+	self.Chat_compose.Is_typing = false
+	self.Chat_card = nil
+	self.Chat_confirm = nil
+}
+
+func (self *UIState) chat_push(msg chat.Message) {
+	// A closed chat may still have messages in flight
+	if !strings.EqualFold(msg.Channel, self.Chat_channel) {
+		return
+	}
+	if self.Chat_logger != nil && !msg.Is_notice {
+		if err := self.Chat_logger.Write(msg); err != nil {
+			_, _ = self.Message.WriteString(fmt.Sprintf("Could not log chat: %s\n", err))
+		}
+	}
+	dropped := self.Chat.Push(msg)
+	if dropped == 0 {
+		return
+	}
+	if self.Chat_selection >= 0 {
+		self.Chat_selection = max(self.Chat_selection - dropped, 0)
+	}
+	if self.Chat_focus >= 0 {
+		self.Chat_focus -= dropped
+		if self.Chat_focus < 0 {
+			_, _ = self.Message.WriteString("The focused message left the scrollback\n")
+			self.Chat_focus = -1
+		}
+	}
+}
+
+func (self UIState) chat_includes(idx int) bool {
+	return !self.Chat_rules.Is_hidden(self.Chat.Messages[idx])
+}
+
+// Positions of the messages not hidden by the rules
+func (self UIState) chat_rows() []int {
+	ret := make([]int, 0, len(self.Chat.Messages))
+	for i := range self.Chat.Messages {
+		if self.chat_includes(i) {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// The selected message, or the newest one if we are following
+func (self UIState) chat_current(rows []int) int {
+	if self.Chat_selection >= 0 || len(rows) == 0 {
+		return self.Chat_selection
+	}
+	return rows[len(rows) - 1]
+}
+
+// Rows above the list taken by the focused message and its context
+func (self UIState) chat_focus_rows() int {
+	if self.Chat_focus < 0 {
+		return 0
+	}
+	start, close := self.Chat.Context(self.Chat_focus, CHAT_CONTEXT_ROWS, CHAT_CONTEXT_ROWS, self.chat_includes)
+	count := 0
+	for i := start; i < close; i += 1 {
+		if self.chat_includes(i) || i == self.Chat_focus {
+			count += 1
+		}
+	}
+	return count + 1 // Separator
+}
+
+func (self *UIState) chat_layout() {
+	if self.Chat_card != nil {
+		self.chat_card_layout()
+	}
+	rows := self.chat_rows()
+	self.Chat_viewport.Rows = max(self.Height - 1 - CHAT_FOOTER_ROWS - self.chat_focus_rows(), 0)
+	if self.Chat_selection < 0 {
+		self.Chat_viewport.Scroll_to(len(rows) - 1, len(rows))
+	} else {
+		self.Chat_viewport.Scroll_to(max(slices.Index(rows, self.Chat_selection), 0), len(rows))
+	}
+}
+
+// Moving onto the newest message resumes following the chat
+func (self *UIState) chat_navigate(action Action) bool {
+	rows := self.chat_rows()
+	if len(rows) == 0 {
+		return slices.Contains([]Action{ActionSelect_next, ActionSelect_prev, ActionSelect_first, ActionSelect_last, ActionPage_down, ActionPage_up, ActionHalf_page_down, ActionHalf_page_up}, action)
+	}
+	pos := len(rows) - 1
+	if self.Chat_selection >= 0 {
+		pos = max(slices.Index(rows, self.Chat_selection), 0)
+	}
+	page := max(self.Chat_viewport.Rows, 1)
+	switch action {
+	case ActionSelect_next: pos += 1
+	case ActionSelect_prev: pos -= 1
+	case ActionSelect_first: pos = 0
+	case ActionSelect_last: pos = len(rows)
+	case ActionPage_down: pos += page
+	case ActionPage_up: pos -= page
+	case ActionHalf_page_down: pos += max(page / 2, 1)
+	case ActionHalf_page_up: pos -= max(page / 2, 1)
+	default: return false
+	}
+	if pos >= len(rows) - 1 {
+		self.Chat_selection = -1
+	} else {
+		self.Chat_selection = rows[max(pos, 0)]
+	}
+	return true
+}
+
+func (self *UIState) chat_search_step(dir int) {
+	query := string(self.Chat_search.Query)
+	if query == "" || len(self.Chat.Messages) == 0 {
+		return
+	}
+	// While following, the newest message is a candidate too
+	from := self.Chat_selection
+	if from < 0 {
+		from = len(self.Chat.Messages)
+	}
+	if idx := self.Chat.Search(query, from, dir, self.chat_includes); idx < 0 {
+		_, _ = self.Message.WriteString(fmt.Sprintf("No messages match %q\n", query))
+	} else {
+		self.Chat_selection = idx
+	}
+}
+
+// While typing the query, keys go to the search rather than the keymap
+// Returns true if the event was consumed
+func (self *UIState) chat_search_input(event term.Event) bool {
+	search := &self.Chat_search
+	if !search.Is_typing {
+		return false
+	}
+	if event.Ty == term.TyEscape {
+		*search = ChatSearch{}
+		return true
+	}
+
+	switch {
+	case event.Ty != term.TyCodepoint:
+	case event.X == '\n':
+		// Like vim's ?, since the newest messages are at the bottom
+		search.Is_typing = false
+		self.chat_search_step(-1)
+	case event.X == 127 || event.X == 'h' && event.Mod_ctrl:
+		if len(search.Query) > 0 {
+			search.Query = search.Query[:len(search.Query) - 1]
+		}
+	case event.X == 'c' && event.Mod_ctrl:
+		return false
+	case event.X == 'u' && event.Mod_ctrl:
+		search.Query = search.Query[:0]
+	case unicode.IsPrint(event.X) && !event.Mod_ctrl:
+		search.Query = append(search.Query, event.X)
+	}
+	return true
+}
+
+// Users who spoke recently whose name starts with prefix, newest first
+func (self UIState) chat_recent_users(prefix string) []string {
+	var ret []string
+	for i := len(self.Chat.Messages) - 1; i >= 0; i -= 1 {
+		msg := self.Chat.Messages[i]
+		name := msg.Display_name
+		// Display names in other scripts are hard to type
+		if !strings.EqualFold(name, msg.User) {
+			name = msg.User
+		}
+		if msg.Is_notice || name == "" || !has_prefix_fold(name, prefix) {
+			continue
+		}
+		if !slices.ContainsFunc(ret, func(x string) bool { return strings.EqualFold(x, name) }) {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+func has_prefix_fold(s string, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// While typing a message, keys go to the input line rather than the keymap
+// Returns true if the event was consumed
+func (self *UIState) chat_compose_input(event term.Event) bool {
+	compose := &self.Chat_compose
+	if !compose.Is_typing || event.Ty == term.TyMouse {
+		return false
+	}
+	if event.Ty == term.TyCodepoint && event.X == 'c' && event.Mod_ctrl {
+		return false
+	}
+	switch compose.Input(event, self.chat_recent_users) {
+	case LineCancel:
+		compose.Is_typing = false
+	case LineSubmit:
+		if self.Chat_client == nil {
+			break
+		}
+		text := string(compose.Text)
+		if err := self.Chat_client.Say(self.Chat_channel, text); err != nil {
+			_, _ = self.Message.WriteString(err.Error() + "\n")
+		} else {
+			compose.Submit()
+			self.Chat_selection = -1 // Follow to see the message arrive
+		}
+	}
+	return true
+}
+
+func (self *UIState) chat_input(event term.Event, cancel context.CancelFunc) bool {
+	self.Message.Reset()
+	if self.chat_confirm_input(event) || self.chat_search_input(event) || self.chat_compose_input(event) {
+		return false
+	}
+	if event.Ty == term.TyMouse {
+		action := Action("")
+		switch event.Button {
+		case term.MouseScrollDown: action = ActionSelect_next
+		case term.MouseScrollUp: action = ActionSelect_prev
+		case term.MouseBtn1:
+			rows := self.chat_rows()
+			row := int(event.Y) - LIST_TOP_ROW - self.chat_focus_rows()
+			if row >= 0 && row < self.Chat_viewport.Rows && self.Chat_viewport.Offset + row < len(rows) {
+				self.Chat_selection = rows[self.Chat_viewport.Offset + row]
+			}
+		}
+		for i := 0; action != "" && i < SCROLL_WHEEL_ROWS; i += 1 {
+			self.chat_navigate(action)
+		}
+		return false
+	}
+
+	action := self.resolve_key(event)
+	if self.chat_card_input(action) || self.chat_navigate(action) {
+		return false
+	}
+	switch action {
+	case ActionFilter:
+		self.Chat_search = ChatSearch{ Is_typing: true }
+	case ActionCompose:
+		self.Chat_compose.Is_typing = true
+	case ActionUser_card:
+		if idx := self.chat_current(self.chat_rows()); idx >= 0 {
+			self.chat_card_open(self.Chat.Messages[idx])
+		}
+	case ActionMod_timeout, ActionMod_ban, ActionMod_unban, ActionMod_delete:
+		self.chat_moderate(action)
+	case ActionSearch_older:
+		self.chat_search_step(-1)
+	case ActionSearch_newer:
+		self.chat_search_step(1)
+	case ActionFocus:
+		if idx := self.chat_current(self.chat_rows()); idx >= 0 {
+			self.Chat_focus = idx
+		}
+	case ActionFilter_clear:
+		self.Chat_search = ChatSearch{}
+		self.Chat_focus = -1
+	case ActionBack:
+		if self.Chat_focus >= 0 {
+			self.Chat_focus = -1
+		} else {
+			self.chat_close()
+			self.Chat_channel = ""
+			self.Screen = self.Chat_return
+		}
+	case ActionRefresh:
+		// Chat is pushed to us
+	default:
+		var none uint16
+		return self.list_action(action, cancel, 0, Viewport{}, &none)
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Render
+
+func (self UIState) chat_render(writer *bufio.Writer) {
+	rows := self.chat_rows()
+	fmt.Fprintf(writer, "Chat %s %s", self.Chat_channel, self.Chat_viewport.Indicator(len(rows)))
+	if hidden := len(self.Chat.Messages) - len(rows); hidden > 0 {
+		fmt.Fprintf(writer, " (%d hidden)", hidden)
+	}
+	if self.Chat_selection >= 0 {
+		fmt.Fprint(writer, " (paused)")
+	}
+
+	top := LIST_TOP_ROW
+	if self.Chat_card != nil {
+		self.chat_card_render(writer)
+	} else if self.Chat_focus >= 0 {
+		start, close := self.Chat.Context(self.Chat_focus, CHAT_CONTEXT_ROWS, CHAT_CONTEXT_ROWS, self.chat_includes)
+		for i := start; i < close; i += 1 {
+			if !self.chat_includes(i) && i != self.Chat_focus {
+				continue
+			}
+			fmt.Fprintf(writer, "\x1B[%d;1H", top)
+			if i == self.Chat_focus {
+				fmt.Fprint(writer, ">")
+			} else {
+				fmt.Fprint(writer, " ")
+			}
+			self.chat_render_message(writer, self.Chat.Messages[i], false)
+			top += 1
+		}
+		fmt.Fprintf(writer, "\x1B[%d;1H%s", top, strings.Repeat("─", max(self.Width - 1, 0)))
+		top += 1
+	}
+
+	// Not render_list, as nothing is selected while following
+	for i := self.Chat_viewport.Offset; self.Chat_card == nil && i < len(rows) && i - self.Chat_viewport.Offset < self.Chat_viewport.Rows; i += 1 {
+		idx := rows[i]
+		is_selected := idx == self.Chat_selection
+		fmt.Fprintf(writer, "\x1B[%d;1H", top + i - self.Chat_viewport.Offset)
+		if is_selected {
+			fmt.Fprintf(writer, "\x1B[0;%s%s;%s%sm", term.Part_foreground, term.Part_white, term.Part_background, term.Part_black)
+		}
+		if self.Chat_rules.Is_highlighted(self.Chat.Messages[idx]) {
+			fmt.Fprint(writer, "*")
+		} else {
+			fmt.Fprint(writer, " ")
+		}
+		self.chat_render_message(writer, self.Chat.Messages[idx], is_selected)
+		if is_selected {
+			fmt.Fprint(writer, term.Reset_attributes)
+		}
+	}
+
+	render_footer_start(writer, self.Height, CHAT_FOOTER_ROWS)
+	if self.Chat_confirm != nil {
+		fmt.Fprintf(writer, " %s (y/N)", self.Chat_confirm.Prompt)
+	} else if self.Chat_compose.Is_typing {
+		self.Chat_compose.Render(writer, " > ", self.Width)
+	} else if self.Chat_search.Is_typing {
+		fmt.Fprintf(writer, " /%s_ (enter) search (esc) clear", string(self.Chat_search.Query))
+	} else if len(self.Chat_search.Query) > 0 {
+		fmt.Fprintf(writer, " /%s (esc) clear", string(self.Chat_search.Query))
+	}
+	fmt.Fprint(writer, "\r\n")
+	if self.Chat_compose.Is_typing {
+		fmt.Fprint(writer, " (enter) send (tab) complete @name (esc) stop typing")
+	} else {
+		self.render_hints(writer, ActionQuit, ActionBack, ActionCompose, ActionUser_card, ActionFilter, ActionSearch_older, ActionSearch_newer, ActionFocus, ActionSelect_last, ActionHelp)
+	}
+	fmt.Fprint(writer, "\r\n")
+	render_message(writer, self.Message.String())
+}
+
+// e.g. "15:04 Foo: hello Kappa", colours are skipped on the selected row so
+// that they do not fight the selection highlight
+func (self UIState) chat_render_message(writer *bufio.Writer, msg chat.Message, is_selected bool) {
+	styled := !is_selected
+	fmt.Fprintf(writer, "%s ", msg.Time.Format(CHAT_TIME_FORMAT))
+	if msg.Is_notice {
+		if styled {
+			fmt.Fprintf(writer, "\x1B[2m-- %s --\x1B[22m", src.Printable(msg.Text))
+		} else {
+			fmt.Fprintf(writer, "-- %s --", src.Printable(msg.Text))
+		}
+		return
+	}
+
+	if styled && self.Chat_rules.Is_highlighted(msg) {
+		fmt.Fprintf(writer, "\x1B[%s%sm", term.Part_background, term.Part_yellow)
+		defer fmt.Fprint(writer, term.Reset_attributes)
+	}
+	if r, g, b, ok := chat.Parse_hex_color(msg.Color); ok && styled {
+		fmt.Fprintf(writer, "\x1B[38;2;%d;%d;%dm%s\x1B[39m", r, g, b, src.Printable(msg.Display_name))
+	} else {
+		fmt.Fprint(writer, src.Printable(msg.Display_name))
+	}
+	if msg.Is_action {
+		fmt.Fprint(writer, " ")
+	} else {
+		fmt.Fprint(writer, ": ")
+	}
+
+	marks, _ := chat.Search_match(string(self.Chat_search.Query), msg)
+	if self.Emotes == nil {
+		_ = print_marked(writer, msg.Text, marks)
+		return
+	}
+	offset := 0
+	for _, span := range self.Emotes.Tokenize(self.Chat_channel_id, msg.Text) {
+		var span_marks []int
+		for _, m := range marks {
+			if offset <= m && m < offset + len(span.Text) {
+				span_marks = append(span_marks, m - offset)
+			}
+		}
+		if span.Emote != nil && styled {
+			fmt.Fprintf(writer, "\x1B[%s%sm", term.Part_foreground, term.Part_cyan)
+			_ = print_marked(writer, span.Text, span_marks)
+			fmt.Fprint(writer, "\x1B[39m")
+		} else {
+			_ = print_marked(writer, span.Text, span_marks)
+		}
+		offset += len(span.Text)
+	}
+}
@@ -0,0 +1,201 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/chat"
+	"github.com/yueleshia/streamsurf/src/term"
+)
+
+// Moderation and user cards on the chat screen
+
+const DEFAULT_TIMEOUT = 10 * time.Minute
+
+// Every message of one user we know of, from this session and the chat logs
+type UserCard struct {
+	User         string
+	User_id      string
+	Display_name string
+	Messages     []chat.Message // Oldest first
+	Logged       int // How many of Messages came from the logs only
+	Viewport     Viewport
+}
+
+// A moderation action waiting for y/n
+type ChatConfirm struct {
+	Prompt string
+	Done   string // Shown once Run succeeds
+	Run    func(ctx context.Context) error
+}
+
+func (self *UIState) chat_card_open(msg chat.Message) {
+	if msg.Is_notice || msg.User == "" {
+		return
+	}
+	card := &UserCard{ User: msg.User, User_id: msg.User_id, Display_name: msg.Display_name }
+	seen := make(map[string]bool)
+	if self.Chat_logger != nil {
+		logged, err := self.Chat_logger.User_messages(self.Chat_channel, msg.User)
+		if err != nil {
+			_, _ = self.Message.WriteString(fmt.Sprintf("Could not read the chat logs: %s\n", err))
+		}
+		for _, x := range logged {
+			seen[x.Id] = x.Id != ""
+			card.Messages = append(card.Messages, x)
+		}
+	}
+	card.Logged = len(card.Messages)
+	for _, x := range self.Chat.Messages {
+		if x.Is_notice || !strings.EqualFold(x.User, msg.User) {
+			continue
+		}
+		// The logger already wrote what we received this session
+		if seen[x.Id] {
+			card.Logged -= 1
+			continue
+		}
+		card.Messages = append(card.Messages, x)
+	}
+	card.Viewport.Offset = len(card.Messages) // Scrolled to the bottom by layout
+	self.Chat_card = card
+}
+
+// Only moderators of the channel get past this
+func (self *UIState) chat_can_moderate() bool {
+	if self.Moderator == nil || self.Chat_client == nil || !self.Chat_client.Is_moderator(self.Chat_channel) {
+		_, _ = self.Message.WriteString(fmt.Sprintf("Only moderators of %s can do that\n", self.Chat_channel))
+		return false
+	}
+	return true
+}
+
+// Asks to confirm a moderation action on the user of the card, or on the
+// selected message otherwise
+func (self *UIState) chat_moderate(action Action) {
+	if !self.chat_can_moderate() {
+		return
+	}
+	var target chat.Message
+	if card := self.Chat_card; card != nil {
+		target = chat.Message{ User: card.User, User_id: card.User_id, Display_name: card.Display_name }
+	} else if idx := self.chat_current(self.chat_rows()); idx >= 0 {
+		target = self.Chat.Messages[idx]
+	}
+	if target.Is_notice || target.User_id == "" {
+		_, _ = self.Message.WriteString("Select a message from a user first\n")
+		return
+	}
+
+	mod, broadcaster, name := self.Moderator, self.Chat_channel_id, target.Display_name
+	timeout := self.Chat_timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	var confirm ChatConfirm
+	switch action {
+	case ActionMod_timeout:
+		confirm = ChatConfirm{
+			Prompt: fmt.Sprintf("Time out %s for %s?", name, timeout),
+			Done: fmt.Sprintf("Timed out %s for %s", name, timeout),
+			Run: func(ctx context.Context) error { return mod.Timeout(ctx, broadcaster, target.User_id, timeout, "") },
+		}
+	case ActionMod_ban:
+		confirm = ChatConfirm{
+			Prompt: fmt.Sprintf("Ban %s?", name),
+			Done: fmt.Sprintf("Banned %s", name),
+			Run: func(ctx context.Context) error { return mod.Ban(ctx, broadcaster, target.User_id, "") },
+		}
+	case ActionMod_unban:
+		confirm = ChatConfirm{
+			Prompt: fmt.Sprintf("Unban %s?", name),
+			Done: fmt.Sprintf("Unbanned %s", name),
+			Run: func(ctx context.Context) error { return mod.Unban(ctx, broadcaster, target.User_id) },
+		}
+	case ActionMod_delete:
+		if target.Id == "" {
+			_, _ = self.Message.WriteString("Select a message to delete\n")
+			return
+		}
+		confirm = ChatConfirm{
+			Prompt: fmt.Sprintf("Delete %s's message %q?", name, target.Text),
+			Done: fmt.Sprintf("Deleted %s's message", name),
+			Run: func(ctx context.Context) error { return mod.Delete(ctx, broadcaster, target.Id) },
+		}
+	}
+	self.Chat_confirm = &confirm
+}
+
+// Any key but y cancels. Returns true if the event was consumed
+func (self *UIState) chat_confirm_input(event term.Event) bool {
+	confirm := self.Chat_confirm
+	if confirm == nil || event.Ty == term.TyMouse {
+		return false
+	}
+	self.Chat_confirm = nil
+	if event.Ty != term.TyCodepoint || event.Mod_ctrl || (event.X != 'y' && event.X != 'Y') {
+		_, _ = self.Message.WriteString("Cancelled\n")
+		return true
+	}
+
+	queue, channel := self.Chat_queue, self.Chat_channel
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+		defer cancel()
+		text := confirm.Done
+		if err := confirm.Run(ctx); err != nil {
+			text = fmt.Sprintf("%s failed: %s", strings.TrimSuffix(confirm.Prompt, "?"), err)
+		}
+		queue <- chat.Notice(channel, text)
+	}()
+	return true
+}
+
+// The card replaces the message list, and scrolls rather than selects
+func (self *UIState) chat_card_input(action Action) bool {
+	card := self.Chat_card
+	if card == nil {
+		return false
+	}
+	page := max(card.Viewport.Rows, 1)
+	switch action {
+	case ActionSelect_next: card.Viewport.Offset += 1
+	case ActionSelect_prev: card.Viewport.Offset -= 1
+	case ActionSelect_first: card.Viewport.Offset = 0
+	case ActionSelect_last: card.Viewport.Offset = len(card.Messages)
+	case ActionPage_down: card.Viewport.Offset += page
+	case ActionPage_up: card.Viewport.Offset -= page
+	case ActionHalf_page_down: card.Viewport.Offset += max(page / 2, 1)
+	case ActionHalf_page_up: card.Viewport.Offset -= max(page / 2, 1)
+	case ActionBack, ActionFilter_clear, ActionUser_card: self.Chat_card = nil
+	default: return false
+	}
+	return true
+}
+
+func (self *UIState) chat_card_layout() {
+	card := self.Chat_card
+	card.Viewport.Rows = max(self.Height - 1 - CHAT_FOOTER_ROWS - 1, 0)
+	card.Viewport.Offset = max(min(card.Viewport.Offset, len(card.Messages) - card.Viewport.Rows), 0)
+}
+
+func (self UIState) chat_card_render(writer *bufio.Writer) {
+	card := self.Chat_card
+	fmt.Fprintf(writer, "\x1B[%d;1H\x1B[1m%s\x1B[22m", LIST_TOP_ROW, src.Printable(card.Display_name))
+	if !strings.EqualFold(card.Display_name, card.User) {
+		fmt.Fprintf(writer, " (%s)", src.Printable(card.User))
+	}
+	fmt.Fprintf(writer, " %d messages, %d this session", len(card.Messages), len(card.Messages) - card.Logged)
+	if self.Chat_logger == nil {
+		fmt.Fprint(writer, " (chat logging is off)")
+	}
+	for i := card.Viewport.Offset; i < len(card.Messages) && i - card.Viewport.Offset < card.Viewport.Rows; i += 1 {
+		msg := card.Messages[i]
+		fmt.Fprintf(writer, "\x1B[%d;1H %s ", LIST_TOP_ROW + 1 + i - card.Viewport.Offset, msg.Time.Format("2006-01-02"))
+		self.chat_render_message(writer, msg, false)
+	}
+}
@@ -0,0 +1,231 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/chat"
+	"github.com/yueleshia/streamsurf/src/term"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run Chat
+
+func chat_state(texts ...string) UIState {
+	ui := UIState{ Chat_channel: "tsoding", Chat_selection: -1, Chat_focus: -1 }
+	ui.Chat.Capacity = 4
+	ui.Chat_rules = src.Must(chat.Compile_rules(nil, []src.ChatRule{{ User: "nightbot" }}))
+	for _, text := range texts {
+		user := "viewer"
+		if strings.HasPrefix(text, "bot") {
+			user = "nightbot"
+		}
+		ui.chat_push(chat.Message{ Channel: "tsoding", User: user, Text: text })
+	}
+	return ui
+}
+
+func TestChatNavigate(t *testing.T) {
+	ui := chat_state("a", "bot", "b", "c")
+	a.AssertEqual(t, []int{0, 2, 3}, ui.chat_rows())
+
+	// Moving up from following starts at the newest message, skipping hidden
+	ui.chat_navigate(ActionSelect_prev)
+	a.AssertEqual(t, 2, ui.Chat_selection)
+	ui.chat_navigate(ActionSelect_prev)
+	a.AssertEqual(t, 0, ui.Chat_selection)
+	ui.chat_navigate(ActionSelect_next)
+	a.AssertEqual(t, 2, ui.Chat_selection)
+	ui.chat_navigate(ActionSelect_next)
+	a.AssertEqual(t, -1, ui.Chat_selection)
+}
+
+func TestChatRenderControl(t *testing.T) {
+	ui := chat_state()
+	var output strings.Builder
+	writer := bufio.NewWriter(&output)
+	msg := chat.Message{ Time: time.Date(2024, 1, 2, 15, 4, 0, 0, time.Local), Display_name: "Foo\x1B[31m", Text: "hi\x1B[2J\u009b1;1H" }
+	ui.chat_render_message(writer, msg, true)
+	ui.Chat_search.Query = []rune("hi")
+	ui.chat_render_message(writer, msg, true)
+	ui.chat_render_message(writer, chat.Message{ Time: msg.Time, Text: "slow\x1Bc", Is_notice: true }, true)
+	src.Must1(writer.Flush())
+	a.AssertEqual(t, "15:04 Foo\uFFFD[31m: hi\uFFFD[2J\uFFFD1;1H" +
+		"15:04 Foo\uFFFD[31m: " + term.Start_highlight + "h" + term.End_highlight + term.Start_highlight + "i" + term.End_highlight + "\uFFFD[2J\uFFFD1;1H" +
+		"15:04 -- slow\uFFFDc --", output.String())
+}
+
+func TestChatPush(t *testing.T) {
+	ui := chat_state("a", "b", "c", "d")
+	ui.Chat_selection = 2
+	ui.Chat_focus = 0
+
+	ui.chat_push(chat.Message{ Channel: "other", Text: "ignored" })
+	a.AssertEqual(t, 4, len(ui.Chat.Messages))
+
+	ui.chat_push(chat.Message{ Channel: "tsoding", Text: "e" })
+	a.AssertEqual(t, 1, ui.Chat_selection)
+	a.AssertEqual(t, -1, ui.Chat_focus)
+	a.AssertEqual(t, "c", ui.Chat.Messages[ui.Chat_selection].Text)
+}
+
+func TestChatSearch(t *testing.T) {
+	ui := chat_state("go", "bot go", "nope", "go")
+	ui.Chat_search.Query = []rune("go")
+	ui.chat_search_step(-1)
+	a.AssertEqual(t, 3, ui.Chat_selection)
+	ui.chat_search_step(-1)
+	a.AssertEqual(t, 0, ui.Chat_selection) // Skips the hidden bot
+	ui.chat_search_step(-1)
+	a.AssertEqual(t, 3, ui.Chat_selection)
+}
+
+func type_keys(editor *LineEditor, complete func(string) []string, keys string) int {
+	ret := LineNone
+	for _, key := range src.Must(Parse_keys(keys)) {
+		ret = editor.Input(term.Event{ Ty: key.Ty, X: key.X, Mod_ctrl: key.Ctrl }, complete)
+	}
+	return ret
+}
+
+func TestLineEditor(t *testing.T) {
+	var editor LineEditor
+	none := func(string) []string { return nil }
+
+	type_keys(&editor, none, "hello world<Left><Left><Left><Left><Left><BS>_")
+	a.AssertEqual(t, "hello_world", string(editor.Text))
+	a.AssertEqual(t, 6, editor.Cursor)
+	type_keys(&editor, none, "<End> again<C-w>")
+	a.AssertEqual(t, "hello_world ", string(editor.Text))
+	type_keys(&editor, none, "<Home><Del><C-k>")
+	a.AssertEqual(t, "", string(editor.Text))
+
+	a.AssertEqual(t, LineSubmit, type_keys(&editor, none, "first<Enter>"))
+	a.AssertEqual(t, "first", editor.Submit())
+	type_keys(&editor, none, "second<Enter>")
+	editor.Submit()
+
+	// Up goes back in the history and down returns to the unfinished line
+	type_keys(&editor, none, "draft<Up><Up>")
+	a.AssertEqual(t, "first", string(editor.Text))
+	type_keys(&editor, none, "<Up><Down>")
+	a.AssertEqual(t, "second", string(editor.Text))
+	type_keys(&editor, none, "<Down>")
+	a.AssertEqual(t, "draft", string(editor.Text))
+	a.AssertEqual(t, LineCancel, type_keys(&editor, none, "<Esc>"))
+}
+
+func TestChatMentions(t *testing.T) {
+	ui := chat_state()
+	for _, msg := range []chat.Message{
+		{ User: "foo", Display_name: "Foo" },
+		{ User: "fizz", Display_name: "フィズ" },
+		{ User: "bar", Display_name: "bar" },
+		{ User: "foo", Display_name: "Foo" },
+	} {
+		msg.Channel = "tsoding"
+		ui.chat_push(msg)
+	}
+	ui.chat_push(chat.Notice("tsoding", "Slow mode (30s)"))
+	a.AssertEqual(t, []string{"Foo", "fizz"}, ui.chat_recent_users("f"))
+
+	var editor LineEditor
+	type_keys(&editor, ui.chat_recent_users, "hi @f<Tab>")
+	a.AssertEqual(t, "hi @Foo", string(editor.Text))
+	type_keys(&editor, ui.chat_recent_users, "<Tab>")
+	a.AssertEqual(t, "hi @fizz", string(editor.Text))
+	type_keys(&editor, ui.chat_recent_users, "<Tab>")
+	a.AssertEqual(t, "hi @Foo", string(editor.Text))
+	type_keys(&editor, ui.chat_recent_users, "<Space>hi<Tab>")
+	a.AssertEqual(t, "hi @Foo hi", string(editor.Text))
+}
+
+type fake_moderator struct {
+	calls []string
+}
+
+func (self *fake_moderator) Timeout(ctx context.Context, broadcaster_id string, user_id string, duration time.Duration, reason string) error {
+	self.calls = append(self.calls, fmt.Sprintf("timeout %s %s %s", broadcaster_id, user_id, duration))
+	return nil
+}
+
+func (self *fake_moderator) Ban(ctx context.Context, broadcaster_id string, user_id string, reason string) error {
+	self.calls = append(self.calls, fmt.Sprintf("ban %s %s", broadcaster_id, user_id))
+	return nil
+}
+
+func (self *fake_moderator) Unban(ctx context.Context, broadcaster_id string, user_id string) error {
+	self.calls = append(self.calls, fmt.Sprintf("unban %s %s", broadcaster_id, user_id))
+	return nil
+}
+
+func (self *fake_moderator) Delete(ctx context.Context, broadcaster_id string, message_id string) error {
+	self.calls = append(self.calls, fmt.Sprintf("delete %s %s", broadcaster_id, message_id))
+	return fmt.Errorf("HTTP 403")
+}
+
+func TestChatModerate(t *testing.T) {
+	ui := chat_state()
+	mod := &fake_moderator{}
+	ui.Moderator, ui.Chat_client, ui.Chat_channel_id = mod, &chat.Client{}, "1"
+	ui.Chat_queue = make(chan chat.Message, 1)
+	ui.chat_push(chat.Message{ Id: "m1", Channel: "tsoding", User: "foo", User_id: "2", Display_name: "Foo", Text: "spam" })
+	yes := term.Event{ Ty: term.TyCodepoint, X: 'y' }
+
+	ui.chat_moderate(ActionMod_ban)
+	a.AssertEqual(t, (*ChatConfirm)(nil), ui.Chat_confirm)
+	ui.Chat_client.Set_moderator("Tsoding", true)
+
+	// Anything but y cancels
+	ui.chat_moderate(ActionMod_ban)
+	a.AssertEqual(t, "Ban Foo?", ui.Chat_confirm.Prompt)
+	a.AssertEqual(t, true, ui.chat_confirm_input(term.Event{ Ty: term.TyCodepoint, X: 'n' }))
+	a.AssertEqual(t, (*ChatConfirm)(nil), ui.Chat_confirm)
+
+	ui.chat_moderate(ActionMod_timeout)
+	a.AssertEqual(t, true, ui.chat_confirm_input(yes))
+	a.AssertEqual(t, "Timed out Foo for 10m0s", (<-ui.Chat_queue).Text)
+	a.AssertEqual(t, []string{"timeout 1 2 10m0s"}, mod.calls)
+
+	ui.chat_moderate(ActionMod_delete)
+	ui.chat_confirm_input(yes)
+	notice := <-ui.Chat_queue
+	a.AssertEqual(t, true, notice.Is_notice)
+	a.AssertEqual(t, `Delete Foo's message "spam" failed: HTTP 403`, notice.Text)
+
+	// From the user card, actions are on the user rather than a message
+	ui.chat_card_open(ui.Chat.Messages[0])
+	ui.Chat_selection = -1
+	ui.chat_moderate(ActionMod_unban)
+	ui.chat_confirm_input(yes)
+	<-ui.Chat_queue
+	a.AssertEqual(t, "unban 1 2", mod.calls[len(mod.calls) - 1])
+}
+
+func TestUserCard(t *testing.T) {
+	ui := chat_state()
+	ui.Chat_logger = chat.New_logger(t.TempDir())
+	old := chat.Message{ Id: "old", Time: time.Now().AddDate(0, 0, -1), Channel: "tsoding", User: "foo", Text: "yesterday" }
+	a.AssertEqual(t, nil, ui.Chat_logger.Write(old))
+	for _, msg := range []chat.Message{
+		{ Id: "new", Time: time.Now(), Channel: "tsoding", User: "foo", Text: "today" },
+		{ Id: "other", Time: time.Now(), Channel: "tsoding", User: "bar", Text: "hi" },
+	} {
+		ui.chat_push(msg)
+	}
+	a.AssertEqual(t, nil, ui.Chat_logger.Close())
+
+	ui.chat_card_open(ui.Chat.Messages[0])
+	card := ui.Chat_card
+	a.AssertEqual(t, 2, len(card.Messages))
+	a.AssertEqual(t, "yesterday", card.Messages[0].Text)
+	a.AssertEqual(t, "today", card.Messages[1].Text)
+	a.AssertEqual(t, 1, card.Logged)
+	a.AssertEqual(t, true, ui.chat_card_input(ActionBack))
+	a.AssertEqual(t, (*UserCard)(nil), ui.Chat_card)
+}
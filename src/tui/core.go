@@ -1,19 +1,30 @@
 package tui
 
 import (
+	"context"
 	"io"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/rivo/uniseg"
 
 	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/auth"
+	"github.com/yueleshia/streamsurf/src/chat"
+	"github.com/yueleshia/streamsurf/src/emotes"
+	"github.com/yueleshia/streamsurf/src/graphics"
+	"github.com/yueleshia/streamsurf/src/term"
 )
 
 const (
 	ScreenFollow int = iota
 	ScreenChannel
+	ScreenQueue
+	ScreenChat
+	ScreenSearch
+	ScreenCategory
 )
 
 type FollowPair struct {
@@ -24,7 +35,17 @@ type FollowPair struct {
 type UIState struct {
 	Height, Width int
 	Screen int
+	Kitty_keyboard bool // Whether the terminal is sending kitty protocol key events
+	Last_click time.Time
+	Last_click_idx int
+
+	Images *graphics.Store // nil outside of the TUI
+
+	Keymap Keymap
+	Key_pending []Key // Keys of a multi-key sequence typed so far, e.g. the first g of gg
+	Show_help bool
 	Channel_list []string
+	Channel_list_path string // Where follows added in the TUI are saved, empty to not save them
 
 	Cache LRU
 	Refresh_queue chan src.VideoPacket
@@ -34,12 +55,78 @@ type UIState struct {
 	Follow_latest map[string]FollowPair
 	Follow_selection uint16
 	Follow_videos []src.Video
+	Follow_viewport Viewport
+	Schedules map[string][]src.ScheduleSegment // Upcoming streams by channel
+	Schedule_queue chan ScheduleReply
+	Activity map[string]src.Activity // Usual stream times by channel
+	History map[string][]src.Video // Past broadcasts older than the cache has, by channel
+	History_queue chan HistoryReply
 
 	// Channel screen
 	Channel string
 	Channel_selection uint16
 	Channel_videos RingBuffer
 	Channel_command []byte
+	Channel_viewport Viewport
+	Channel_live src.Video // The live stream of Channel if we do not follow it
+	Channel_return int // Screen that opened the channel
+	Channel_tab int // Index into CHANNEL_TABS
+	Channel_fetched map[string]bool // "channel TYPE" pairs already fetched for the tabs
+
+	// Shared between the follow and channel screens, cleared on switching
+	Filter Filter
+
+	// Queue screen
+	Queue src.Queue
+	Queue_selection uint16
+	Queue_viewport Viewport
+	Queue_playing string // Url of the queue item being played, empty if stopped
+	Player_exit chan error
+
+	// Chat screen, the connection stays open while on other screens
+	Chat chat.Buffer
+	Chat_rules chat.Rules
+	Chat_channel string
+	Chat_channel_id string
+	Chat_selection int // Index into Chat.Messages, -1 follows the newest message
+	Chat_focus int // Message pinned with its context, -1 if none
+	Chat_search ChatSearch
+	Chat_viewport Viewport
+	Chat_return int // Screen that opened the chat
+	Chat_queue chan chat.Message
+	Chat_error chan error
+	Chat_cancel context.CancelFunc
+	Chat_client *chat.Client // The chat of Chat_channel
+	Chat_compose LineEditor
+	Chat_card *UserCard // Shown instead of the messages when set
+	Chat_confirm *ChatConfirm // Moderation action waiting for y/n
+	Chat_timeout time.Duration // 0 for DEFAULT_TIMEOUT
+	Moderator chat.Moderator
+	Chat_logger *chat.Logger // nil unless logging is enabled in the config
+	Chat_subtitles chat.AssOptions // For playing VODs with chat
+	Session *auth.Session // nil outside of the TUI
+	Emotes *emotes.Registry // nil outside of the TUI
+	Emotes_loaded chan error
+
+	// Search screen
+	Search_query LineEditor
+	Search_pending string // Query in flight, replies to older ones are dropped
+	Search_queue chan SearchReply
+	Search_results src.SearchResults
+	Search_rows []SearchRow
+	Search_selection uint16
+	Search_viewport Viewport
+	Search_return int // Screen that opened the search
+
+	// Category screen
+	Categories []string // From the config
+	Category string
+	Category_latest map[string][]src.Video // Last fetched streams of each category
+	Category_videos []src.Video
+	Category_selection uint16
+	Category_viewport Viewport
+	Category_queue chan CategoryReply
+	Category_return int // Screen that opened the category
 
 	Message strings.Builder
 }
@@ -77,15 +164,25 @@ func (self *UIState) Load_config(config string) {
 
 	// @TODO: Refactor this to work even when we run out of cache
 	//        Maybe this is resolved RingBuffer.Latest
-	src.Assert(count * src.PAGE_SIZE <= src.RING_QUEUE_SIZE)
+	src.Must1(src.Check_channel_limit(count))
 
 	self.Refresh_queue = make(chan src.VideoPacket, 100)
 	self.Log_queue = make(chan []byte, 100)
+	self.Player_exit = make(chan error, 1)
+	self.Chat_queue = make(chan chat.Message, 100)
+	self.Chat_error = make(chan error, 1)
+	self.Emotes_loaded = make(chan error, 1)
+	self.Search_queue = make(chan SearchReply, 4)
+	self.Category_queue = make(chan CategoryReply, 4)
+	self.Schedule_queue = make(chan ScheduleReply, 100)
+	self.History_queue = make(chan HistoryReply, 4)
+	self.Chat_selection = -1
+	self.Chat_focus = -1
 
 	self.Follow_videos = set_len(self.Follow_videos, count)
 
 	self.Channel_list = list[:count]
-	self.Channel_videos.Buffer = set_len(self.Channel_videos.Buffer, count)
+	self.Channel_videos.Buffer = set_len(self.Channel_videos.Buffer, src.RING_QUEUE_SIZE)
 	self.Channel_command = set_len(self.Channel_command, 100)
 
 	self.Cache.Buffer = set_len(self.Cache.Buffer, src.RING_QUEUE_SIZE)
@@ -96,6 +193,9 @@ func (self *UIState) Load_config(config string) {
 	if self.Cache.Exists == nil {
 		self.Cache.Exists = make(map[string]int, src.RING_QUEUE_SIZE * 2)
 	}
+	if self.Category_latest == nil {
+		self.Category_latest = make(map[string][]src.Video)
+	}
 	if self.Follow_latest == nil {
 		self.Follow_latest = make(map[string]FollowPair, count * 2)
 	}
@@ -115,21 +215,82 @@ const PACKETS_PER_REFRESH = 2
 
 func Refresh_channels(queue chan src.VideoPacket, channels ...string) {
 	for _, channel := range channels {
-		go func() {
-			vods, live := src.Graph_vods(channel)
-			if live.Is_live {
-				src.L_DEBUG.Printf("%s is live", live.Channel)
-			}
-			queue <- vods
-			queue <- src.VideoPacket{[]src.Video{live}, true, nil}
-		}()
+		Refresh_channel_type(queue, channel, "")
 		//go func() { queue <- src.Scrape_vods(channel) }()
 		//go func() { queue <- src.Scrape_live_status(channel) }()
 	}
 }
 
+// Only the VODs of one broadcast type, so that highlights and uploads are
+// not crowded out by past broadcasts. Empty for every type.
+func Refresh_channel_type(queue chan src.VideoPacket, channel string, broadcast_type string) {
+	go func() {
+		vods, live := src.Graph_vods(channel, broadcast_type)
+		if live.Is_live {
+			src.L_DEBUG.Printf("%s is live", live.Channel)
+		}
+		queue <- vods
+		queue <- src.VideoPacket{ Vids: []src.Video{live}, Live: true }
+	}()
+}
+
 func Print_formatted_line(output io.Writer, gap string, video src.Video) {
-	sizes := []int{10, 30, 9, 6}
+	print_formatted_line_marked(output, gap, video, [2][]int{}, 0)
+}
+
+// Widths of the channel, title, game, kind, time ago, and duration columns
+// The title takes whatever is left over, 0 width gives the CLI defaults
+// The game is left out (0 wide) on narrow terminals
+func Column_sizes(width int, gap string) []int {
+	if width <= 0 {
+		return []int{10, 30, 16, 5, 9, 6}
+	}
+	channel, game, gaps := 10, 0, 4
+	if width >= 120 {
+		channel, game, gaps = 16, 20, 5
+	} else if width >= 90 {
+		game, gaps = 12, 5
+	}
+	// -1 so that we never touch the last column, some terminals scroll then
+	title := width - 1 - channel - game - 5 - 9 - 6 - gaps * uniseg.StringWidth(gap)
+	if title < 10 {
+		channel = max(channel + title - 10, 3)
+		title = 10
+	}
+	return []int{channel, title, game, 5, 9, 6}
+}
+
+// Short labels for the kind column
+var BROADCAST_LABELS = map[string]string{
+	src.BroadcastArchive: "PAST",
+	src.BroadcastHighlight: "HL",
+	src.BroadcastUpload: "UP",
+	src.BroadcastPremiere: "PREM",
+}
+
+// Viewers for live streams, otherwise what sort of VOD it is
+func video_kind(video src.Video) string {
+	if video.Is_live {
+		if video.Viewers > 0 {
+			return format_count(video.Viewers)
+		}
+		return ""
+	}
+	return BROADCAST_LABELS[video.Broadcast_type]
+}
+
+// The display name if it only differs in case, so that filter marks (which
+// are offsets into the login) still line up
+func channel_name(video src.Video) string {
+	if len(video.Display_name) == len(video.Channel) && strings.EqualFold(video.Display_name, video.Channel) {
+		return video.Display_name
+	}
+	return video.Channel
+}
+
+// marks are the byte offsets into the channel and title to highlight
+func print_formatted_line_marked(output io.Writer, gap string, video src.Video, marks [2][]int, width int) {
+	sizes := Column_sizes(width, gap)
 
 	var s_ago, title, duration string
 	t_ago := time.Now().Sub(video.Start_time)
@@ -155,9 +316,12 @@ func Print_formatted_line(output io.Writer, gap string, video src.Video) {
 		}
 	}
 
-	print_line(output, gap, sizes, []string{video.Channel, title, s_ago, duration})
+	if video.Start_time == (time.Time{}) {
+		marks[1] = nil
+	}
+	print_line(output, gap, sizes, []string{channel_name(video), title, video.Game, video_kind(video), s_ago, duration}, marks[:])
 }
-func print_line(output io.Writer, gap string, sizes []int, cols []string) error {
+func print_line(output io.Writer, gap string, sizes []int, cols []string, marks [][]int) error {
 	if len(sizes) != len(cols) {
 		src.L_ERROR.Fatalf("Incorrect number of arguments")
 	}
@@ -165,6 +329,10 @@ func print_line(output io.Writer, gap string, sizes []int, cols []string) error
 	for i, width := range sizes {
 		str := cols[i]
 
+		// Columns that are left out on narrow terminals
+		if width == 0 {
+			continue
+		}
 		if (i != 0) {
 			if _, err := io.Copy(output, strings.NewReader(gap)); err != nil {
 				return err
@@ -203,7 +371,11 @@ func print_line(output io.Writer, gap string, sizes []int, cols []string) error
 			}
 		}
 
-		if _, err := io.Copy(output, strings.NewReader(to_print)); err != nil {
+		var col_marks []int
+		if i < len(marks) {
+			col_marks = marks[i]
+		}
+		if err := print_marked(output, to_print, col_marks); err != nil {
 			return err
 		}
 		if _, err := io.Copy(output, strings.NewReader(extra)); err != nil {
@@ -229,6 +401,30 @@ func print_line(output io.Writer, gap string, sizes []int, cols []string) error
 	return nil
 }
 
+func print_marked(output io.Writer, str string, marks []int) error {
+	if len(marks) == 0 {
+		_, err := io.WriteString(output, src.Printable(str))
+		return err
+	}
+	for i, r := range str {
+		is_marked := slices.Contains(marks, i)
+		if is_marked {
+			if _, err := io.WriteString(output, term.Start_highlight); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(output, string(src.Printable_rune(r))); err != nil {
+			return err
+		}
+		if is_marked {
+			if _, err := io.WriteString(output, term.End_highlight); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func break_unicode_before(max int, str string) (string, int) {
 	state := -1
 	width := 0
@@ -269,6 +465,8 @@ func (self *UIState) Add_and_update_follow(packet src.VideoPacket) {
 		vid := packet.Vids[0]
 		if las, ok := self.Follow_latest[vid.Channel]; ok {
 			self.Follow_latest[vid.Channel] = FollowPair{vid, las.Latest}
+		} else if vid.Channel == self.Channel {
+			self.Channel_live = vid
 		}
 	} else {
 		for _, vid := range packet.Vids {
@@ -1,9 +1,13 @@
 package tui
 
 import (
+	"bufio"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/term"
 	a "github.com/yueleshia/streamsurf/src/testify"
 )
 
@@ -12,7 +16,7 @@ import (
 
 func lru(size int) LRU {
 	return LRU {
-		Buffer: make([]src.Video, size),
+		RingBuffer: RingBuffer{ Buffer: make([]src.Video, size) },
 		Exists: make(map[string]int, size * 2),
 	}
 }
@@ -48,3 +52,92 @@ func TestWrap(t *testing.T) {
 	a.AssertEqual(t, src.Video{}, cache.Buffer[2])
 }
 
+
+func TestChannelTabs(t *testing.T) {
+	ui := &UIState{}
+	ui.Load_config("tsoding")
+	ui.Keymap = src.Must(New_keymap(nil))
+	key := func(r rune) {
+		ui.channel_input(term.Event{ Ty: term.TyCodepoint, X: r }, nil)
+	}
+	ui.Channel_fetched = map[string]bool{ "tsoding ARCHIVE": true, "tsoding HIGHLIGHT": true }
+	past := src.Video{ Channel: "tsoding", Url: "https://www.twitch.tv/videos/1", Broadcast_type: src.BroadcastArchive, Start_time: time.Unix(100, 0) }
+	clip := src.Video{ Channel: "tsoding", Url: "https://www.twitch.tv/videos/2", Broadcast_type: src.BroadcastHighlight, Start_time: time.Unix(200, 0) }
+	ui.Cache.Push(past)
+	ui.Cache.Push(clip)
+
+	ui.channel_swap("tsoding")
+	a.AssertEqual(t, []src.Video{clip, past}, ui.Channel_videos.As_slice())
+	key(']')
+	a.AssertEqual(t, []src.Video{past}, ui.Channel_videos.As_slice())
+	key(']')
+	a.AssertEqual(t, []src.Video{clip}, ui.Channel_videos.As_slice())
+
+	// Types we have not asked for yet are fetched once
+	key(']')
+	a.AssertEqual(t, 0, len(ui.Channel_videos.As_slice()))
+	a.AssertEqual(t, true, ui.Channel_fetched["tsoding UPLOAD"])
+	a.AssertEqual(t, "Loading uploads of tsoding\n", ui.Message.String())
+
+	// The buffer still holds the last tab's videos past its end
+	ui.Width, ui.Height = 80, 30
+	ui.layout()
+	key('1')
+	a.AssertEqual(t, "", string(ui.Channel_command))
+	key('c')
+	a.AssertEqual(t, ScreenChannel, ui.Screen)
+	var output strings.Builder
+	writer := bufio.NewWriter(&output)
+	ui.channel_render(writer)
+	src.Must1(writer.Flush())
+	a.AssertEqual(t, false, strings.Contains(output.String(), clip.Url))
+
+	key('[')
+	key('[')
+	key('[')
+	a.AssertEqual(t, 0, ui.Channel_tab)
+	key('[')
+	a.AssertEqual(t, "Past premieres", CHANNEL_TABS[ui.Channel_tab].Name)
+}
+
+func TestColumnSizes(t *testing.T) {
+	sum := func(sizes []int) int {
+		ret := 0
+		for _, x := range sizes {
+			ret += x
+		}
+		return ret
+	}
+	// The game column only shows when there is room for it
+	a.AssertEqual(t, 0, Column_sizes(80, " | ")[2])
+	a.AssertEqual(t, 80 - 1 - 4 * 3, sum(Column_sizes(80, " | ")))
+	a.AssertEqual(t, 12, Column_sizes(100, " | ")[2])
+	a.AssertEqual(t, 140 - 1 - 5 * 3, sum(Column_sizes(140, " | ")))
+}
+
+func TestFormattedLine(t *testing.T) {
+	var out strings.Builder
+	start := time.Now().Add(-3 * time.Hour - 5 * time.Minute)
+	Print_formatted_line(&out, "|", src.Video{ Channel: "tsoding", Display_name: "Tsoding", Title: "Clip", Game: "Factorio",
+		Broadcast_type: src.BroadcastHighlight, Start_time: start, Duration: 90 * time.Minute })
+	a.AssertEqual(t, "Tsoding   |Clip                          |Factorio        |HL   |3 hr ago |1h30m \n", out.String())
+
+	// Display names that are not just a recapitalisation stay as the login
+	out.Reset()
+	Print_formatted_line(&out, "|", src.Video{ Channel: "tsoding", Display_name: "ツォディング", Is_live: true, Viewers: 1234, Start_time: start })
+	a.AssertEqual(t, "tsoding   |                              |                |1.2k |○        |3h05m \n", out.String())
+}
+
+func TestDetailLines(t *testing.T) {
+	a.AssertEqual(t, []string{
+		"Factorio",
+		"Highlight",
+		"Language: en",
+		"Tags: English, Chill",
+		"Muted: 2 parts, 4m0s",
+		"  0:01:00-0:04:00",
+		"  1:00:00-1:01:00",
+	}, detail_lines(src.Video{ Game: "Factorio", Broadcast_type: src.BroadcastHighlight, Language: "en", Tags: []string{"English", "Chill"},
+		Muted_segments: []src.MutedSegment{{ Offset: time.Minute, Duration: 3 * time.Minute }, { Offset: time.Hour, Duration: time.Minute }} }))
+	a.AssertEqual(t, []string{"Live, 40 viewers"}, detail_lines(src.Video{ Is_live: true, Viewers: 40 }))
+}
@@ -0,0 +1,192 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/term"
+)
+
+// Incremental fuzzy filter over a video list, entered with '/'
+//
+// The query is split on spaces and every term has to fuzzy-match (as an
+// in-order subsequence) one of the channel name, title or chapter names.
+// We keep the list in its original order rather than sorting by score so
+// that the selection does not jump around as you type.
+type Filter struct {
+	Is_typing bool
+	Query     []rune
+	// Index into the video list -> byte offsets to highlight per column
+	// (channel, title), only the videos that match are present
+	Matches   map[int][2][]int
+}
+
+func (self *Filter) Is_active() bool {
+	return len(self.Query) > 0
+}
+
+func (self *Filter) Clear() {
+	self.Is_typing = false
+	self.Query = self.Query[:0]
+	clear(self.Matches)
+}
+
+func (self *Filter) Includes(idx int) bool {
+	if !self.Is_active() {
+		return true
+	}
+	_, ok := self.Matches[idx]
+	return ok
+}
+
+func (self *Filter) Update(videos []src.Video) {
+	if self.Matches == nil {
+		self.Matches = make(map[int][2][]int, len(videos))
+	}
+	clear(self.Matches)
+	if !self.Is_active() {
+		return
+	}
+
+	terms := strings.Fields(string(self.Query))
+	outer: for i, vid := range videos {
+		var marks [2][]int
+		for _, t := range terms {
+			if pos, ok := Fuzzy_match(t, vid.Channel); ok {
+				marks[0] = append(marks[0], pos...)
+			} else if pos, ok := Fuzzy_match(t, vid.Title); ok {
+				marks[1] = append(marks[1], pos...)
+			} else if !fuzzy_match_chapters(t, vid.Chapters) && !fuzzy_match_any(t, vid.Game) && !fuzzy_match_any(t, vid.Tags...) {
+				continue outer
+			}
+		}
+		self.Matches[i] = marks
+	}
+}
+
+func fuzzy_match_any(query string, targets ...string) bool {
+	for _, x := range targets {
+		if _, ok := Fuzzy_match(query, x); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func fuzzy_match_chapters(query string, chapters []src.Chapter) bool {
+	for _, c := range chapters {
+		if _, ok := Fuzzy_match(query, c.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Moves from selection by delta matching entries, stopping at the ends
+func (self *Filter) Step(length int, selection int, delta int) int {
+	dir := 1
+	if delta < 0 {
+		dir, delta = -1, -delta
+	}
+	ret := selection
+	for i := selection + dir; delta > 0 && 0 <= i && i < length; i += dir {
+		if self.Includes(i) {
+			ret = i
+			delta -= 1
+		}
+	}
+	return ret
+}
+
+// Keeps the selection if it still matches, otherwise picks the nearest match
+func (self *Filter) Settle(length int, selection int) int {
+	if self.Includes(selection) || length == 0 {
+		return selection
+	}
+	if x := self.Step(length, selection, 1); x != selection {
+		return x
+	}
+	return self.Step(length, selection, -1)
+}
+
+// Case-insensitive subsequence match, returns byte offsets of the matched
+// runes in target. We greedily take the earliest match, but restart at word
+// boundaries so that "gd" highlights "Game Dev" rather than "Game and"
+func Fuzzy_match(query string, target string) ([]int, bool) {
+	if query == "" {
+		return nil, true
+	}
+	if pos, ok := fuzzy_match_from(query, target, true); ok {
+		return pos, true
+	}
+	return fuzzy_match_from(query, target, false)
+}
+
+func fuzzy_match_from(query string, target string, word_start bool) ([]int, bool) {
+	positions := make([]int, 0, len(query))
+	q := []rune(strings.ToLower(query))
+	qi := 0
+	prev := ' '
+	for i, r := range target {
+		if qi >= len(q) {
+			break
+		}
+		is_boundary := !unicode.IsLetter(prev) && !unicode.IsDigit(prev) || unicode.IsUpper(r) && unicode.IsLower(prev)
+		is_consecutive := len(positions) > 0 && positions[len(positions) - 1] + utf8.RuneLen(prev) == i
+		if unicode.ToLower(r) == q[qi] && (!word_start || is_boundary || is_consecutive) {
+			positions = append(positions, i)
+			qi += 1
+		}
+		prev = r
+	}
+	return positions, qi >= len(q)
+}
+
+// While typing the query, keys go to the filter rather than the keymap
+// Returns true if the event was consumed by the filter
+func (self *UIState) filter_input(event term.Event, videos []src.Video, selection *uint16) bool {
+	filter := &self.Filter
+
+	if !filter.Is_typing {
+		return false
+	}
+	if event.Ty == term.TyEscape {
+		filter.Clear()
+		return true
+	}
+
+	switch {
+	case event.Ty != term.TyCodepoint:
+		return true
+	case event.X == '\n':
+		filter.Is_typing = false
+		return true
+	case event.X == 127 || event.X == 'h' && event.Mod_ctrl:
+		if len(filter.Query) > 0 {
+			filter.Query = filter.Query[:len(filter.Query) - 1]
+		}
+	case event.X == 'c' && event.Mod_ctrl:
+		return false
+	case event.X == 'u' && event.Mod_ctrl:
+		filter.Query = filter.Query[:0]
+	case unicode.IsPrint(event.X) && !event.Mod_ctrl:
+		filter.Query = append(filter.Query, event.X)
+	default:
+		return true
+	}
+	filter.Update(videos)
+	*selection = uint16(filter.Settle(len(videos), int(*selection)))
+	return true
+}
+
+func (self UIState) filter_render(writer *bufio.Writer) {
+	if self.Filter.Is_typing {
+		fmt.Fprintf(writer, " /%s_ (enter) accept (esc) clear", string(self.Filter.Query))
+	} else if self.Filter.Is_active() {
+		fmt.Fprintf(writer, " /%s (%d matches) (esc) clear", string(self.Filter.Query), len(self.Filter.Matches))
+	}
+}
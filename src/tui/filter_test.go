@@ -0,0 +1,63 @@
+package tui
+
+import (
+	"testing"
+
+	"github.com/yueleshia/streamsurf/src"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run Filter
+
+func TestFuzzyMatch(t *testing.T) {
+	pos, ok := Fuzzy_match("gd", "Game and Game Dev")
+	a.AssertEqual(t, true, ok)
+	a.AssertEqual(t, []int{0, 14}, pos)
+
+	pos, ok = Fuzzy_match("TSO", "tsoding")
+	a.AssertEqual(t, true, ok)
+	a.AssertEqual(t, []int{0, 1, 2}, pos)
+
+	// Falls back to matching in the middle of words
+	pos, ok = Fuzzy_match("din", "tsoding")
+	a.AssertEqual(t, true, ok)
+	a.AssertEqual(t, []int{3, 4, 5}, pos)
+
+	_, ok = Fuzzy_match("xyz", "tsoding")
+	a.AssertEqual(t, false, ok)
+
+	// Byte offsets, not rune offsets
+	pos, ok = Fuzzy_match("c", "Game Dev — C")
+	a.AssertEqual(t, true, ok)
+	a.AssertEqual(t, []int{13}, pos)
+}
+
+func TestFilterSelection(t *testing.T) {
+	videos := []src.Video{
+		{ Channel: "tsoding", Title: "Game Dev in C" },
+		{ Channel: "j_blow", Title: "Berries" },
+		{ Channel: "jonhoo", Title: "Rust", Chapters: []src.Chapter{{ Name: "Science & Technology" }} },
+		{ Channel: "andrewrok", Title: "Zig" },
+	}
+	var filter Filter
+	a.AssertEqual(t, 3, filter.Step(len(videos), 1, 5))
+
+	filter.Query = []rune("j")
+	filter.Update(videos)
+	a.AssertEqual(t, false, filter.Includes(0))
+	a.AssertEqual(t, true, filter.Includes(1))
+	a.AssertEqual(t, 2, filter.Step(len(videos), 1, 1))
+	a.AssertEqual(t, 2, filter.Step(len(videos), 2, 1))
+	a.AssertEqual(t, 1, filter.Settle(len(videos), 0))
+	a.AssertEqual(t, 2, filter.Settle(len(videos), 2))
+	a.AssertEqual(t, 2, filter.Settle(len(videos), 3))
+
+	// Every term has to match, chapters count without being highlighted
+	filter.Query = []rune("j science")
+	filter.Update(videos)
+	a.AssertEqual(t, 1, len(filter.Matches))
+	a.AssertEqual(t, [2][]int{{0}, nil}, filter.Matches[2])
+
+	filter.Clear()
+	a.AssertEqual(t, true, filter.Includes(0))
+}
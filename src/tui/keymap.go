@@ -0,0 +1,510 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/yueleshia/streamsurf/src/term"
+)
+
+type Action string
+
+const (
+	ActionNone Action = "none" // Unbinds a default
+	ActionQuit Action = "quit"
+	ActionHelp Action = "help"
+	ActionRefresh Action = "refresh"
+	ActionFilter Action = "filter"
+	ActionFilter_clear Action = "filter_clear"
+
+	ActionSelect_next Action = "select_next"
+	ActionSelect_prev Action = "select_prev"
+	ActionSelect_first Action = "select_first"
+	ActionSelect_last Action = "select_last"
+	ActionPage_down Action = "page_down"
+	ActionPage_up Action = "page_up"
+	ActionHalf_page_down Action = "half_page_down"
+	ActionHalf_page_up Action = "half_page_up"
+
+	ActionOpen Action = "open"
+	ActionBack Action = "back"
+	ActionPlay Action = "play"
+	ActionPlay_with_chat Action = "play_with_chat"
+	ActionTab_next Action = "tab_next"
+	ActionTab_prev Action = "tab_prev"
+
+	ActionQueue_screen Action = "queue_screen"
+	ActionQueue_add Action = "queue_add"
+	ActionQueue_remove Action = "queue_remove"
+	ActionQueue_move_down Action = "queue_move_down"
+	ActionQueue_move_up Action = "queue_move_up"
+
+	ActionChat Action = "chat"
+	ActionSearch_older Action = "search_older"
+	ActionSearch_newer Action = "search_newer"
+	ActionFocus Action = "focus"
+	ActionCompose Action = "compose"
+	ActionUser_card Action = "user_card"
+	ActionMod_timeout Action = "timeout"
+	ActionMod_ban Action = "ban"
+	ActionMod_unban Action = "unban"
+	ActionMod_delete Action = "delete_message"
+
+	ActionSearch Action = "search"
+	ActionFollow_add Action = "follow_add"
+
+	ActionCategories Action = "categories"
+	ActionCategory_next Action = "category_next"
+	ActionCategory_prev Action = "category_prev"
+)
+
+// Screen names as used in the config, "global" applies to every screen
+var SCREEN_NAMES = map[int]string{
+	ScreenFollow: "follow",
+	ScreenChannel: "channel",
+	ScreenQueue: "queue",
+	ScreenChat: "chat",
+	ScreenSearch: "search",
+	ScreenCategory: "category",
+}
+
+var DEFAULT_KEYMAP = map[string]map[string]Action{
+	"global": {
+		"q": ActionQuit,
+		"<C-c>": ActionQuit,
+		"?": ActionHelp,
+		"r": ActionRefresh,
+		"/": ActionFilter,
+		"<Esc>": ActionFilter_clear,
+		"w": ActionQueue_screen,
+
+		"j": ActionSelect_next,
+		"<Down>": ActionSelect_next,
+		"k": ActionSelect_prev,
+		"<Up>": ActionSelect_prev,
+		"gg": ActionSelect_first,
+		"<Home>": ActionSelect_first,
+		"G": ActionSelect_last,
+		"<End>": ActionSelect_last,
+		"<PageDown>": ActionPage_down,
+		"<PageUp>": ActionPage_up,
+		"<C-d>": ActionHalf_page_down,
+		"<C-u>": ActionHalf_page_up,
+	},
+	"follow": {
+		"l": ActionOpen,
+		"<Right>": ActionOpen,
+		"<Enter>": ActionOpen,
+		"a": ActionQueue_add,
+		"c": ActionChat,
+		"s": ActionSearch,
+		"b": ActionCategories,
+	},
+	"channel": {
+		"h": ActionBack,
+		"<Left>": ActionBack,
+		"l": ActionPlay,
+		"<Enter>": ActionPlay,
+		"C": ActionPlay_with_chat,
+		"a": ActionQueue_add,
+		"c": ActionChat,
+		"s": ActionSearch,
+		"<Tab>": ActionTab_next,
+		"]": ActionTab_next,
+		"<S-Tab>": ActionTab_prev,
+		"[": ActionTab_prev,
+	},
+	"queue": {
+		"h": ActionBack,
+		"<Left>": ActionBack,
+		"l": ActionPlay,
+		"<Enter>": ActionPlay,
+		"d": ActionQueue_remove,
+		"J": ActionQueue_move_down,
+		"K": ActionQueue_move_up,
+	},
+	"chat": {
+		"h": ActionBack,
+		"<Left>": ActionBack,
+		"n": ActionSearch_older,
+		"N": ActionSearch_newer,
+		"f": ActionFocus,
+		"<Enter>": ActionFocus,
+		"i": ActionCompose,
+		"u": ActionUser_card,
+		"t": ActionMod_timeout,
+		"b": ActionMod_ban,
+		"U": ActionMod_unban,
+		"x": ActionMod_delete,
+	},
+	"search": {
+		"h": ActionBack,
+		"<Left>": ActionBack,
+		"l": ActionOpen,
+		"<Right>": ActionOpen,
+		"<Enter>": ActionOpen,
+		"s": ActionSearch,
+		"a": ActionFollow_add,
+	},
+	"category": {
+		"h": ActionBack,
+		"<Left>": ActionBack,
+		"l": ActionPlay,
+		"<Enter>": ActionPlay,
+		"o": ActionOpen,
+		"c": ActionChat,
+		"<Tab>": ActionCategory_next,
+		"]": ActionCategory_next,
+		"<S-Tab>": ActionCategory_prev,
+		"[": ActionCategory_prev,
+	},
+}
+
+// The comparable part of a term.Event
+type Key struct {
+	Ty    int8
+	X     rune
+	Ctrl  bool
+	Alt   bool
+	Super bool
+	Shift bool
+}
+
+func Key_of(event term.Event) Key {
+	ret := Key{ Ty: event.Ty, X: event.X, Ctrl: event.Mod_ctrl, Alt: event.Mod_alt, Super: event.Mod_super, Shift: event.Mod_shift }
+	// Shifted characters are already uppercase, e.g. 'G'
+	if event.Ty == term.TyCodepoint && event.X != '\t' {
+		ret.Shift = false
+	}
+	return ret
+}
+
+var KEY_NAMES = map[string]Key{
+	"Esc": { Ty: term.TyEscape },
+	"Enter": { Ty: term.TyCodepoint, X: '\n' },
+	"Tab": { Ty: term.TyCodepoint, X: '\t' },
+	"BS": { Ty: term.TyCodepoint, X: 127 },
+	"Space": { Ty: term.TyCodepoint, X: ' ' },
+	"lt": { Ty: term.TyCodepoint, X: '<' },
+	"Up": { Ty: term.TyArrowUp },
+	"Down": { Ty: term.TyArrowDown },
+	"Left": { Ty: term.TyArrowLeft },
+	"Right": { Ty: term.TyArrowRight },
+	"Home": { Ty: term.TyHome },
+	"End": { Ty: term.TyEnd },
+	"PageUp": { Ty: term.TyPageUp },
+	"PageDown": { Ty: term.TyPageDown },
+	"Insert": { Ty: term.TyInsert },
+	"Del": { Ty: term.TyDelete },
+}
+
+// Vim-like notation, e.g. "gg", "<C-d>", "<A-S-Up>", "<F5>", "<lt>"
+// Modifiers are C (ctrl), A or M (alt), D (super) and S (shift)
+func Parse_keys(s string) ([]Key, error) {
+	ret := []Key{}
+	for len(s) > 0 {
+		if s[0] != '<' || !strings.Contains(s, ">") {
+			r := []rune(s)[0]
+			ret = append(ret, Key{ Ty: term.TyCodepoint, X: r })
+			s = s[len(string(r)):]
+			continue
+		}
+
+		close := strings.Index(s, ">")
+		inner := s[1:close]
+		s = s[close + 1:]
+
+		var key Key
+		parts := strings.Split(inner, "-")
+		name := parts[len(parts) - 1]
+		if name == "" && len(parts) >= 2 {
+			name = "-" // e.g. <C-->
+			parts = parts[:len(parts) - 1]
+		}
+		if x, ok := KEY_NAMES[name]; ok {
+			key = x
+		} else if len(name) >= 2 && name[0] == 'F' {
+			var n int
+			if _, err := fmt.Sscanf(name[1:], "%d", &n); err != nil || n < 1 || n > 35 {
+				return nil, fmt.Errorf("Unknown key %q", "<" + inner + ">")
+			}
+			key = Key{ Ty: term.TyFunction, X: rune(n) }
+		} else if len([]rune(name)) == 1 {
+			key = Key{ Ty: term.TyCodepoint, X: []rune(name)[0] }
+		} else {
+			return nil, fmt.Errorf("Unknown key %q", "<" + inner + ">")
+		}
+
+		for _, mod := range parts[:len(parts) - 1] {
+			switch mod {
+			case "C": key.Ctrl = true
+			case "A", "M": key.Alt = true
+			case "D": key.Super = true
+			case "S": key.Shift = true
+			default: return nil, fmt.Errorf("Unknown modifier %q in %q", mod, "<" + inner + ">")
+			}
+		}
+		// Match what Key_of produces, <S-g> is G
+		if key.Ty == term.TyCodepoint && key.X != '\t' && key.Shift {
+			key.X = []rune(strings.ToUpper(string(key.X)))[0]
+			key.Shift = false
+		}
+		ret = append(ret, key)
+	}
+	return ret, nil
+}
+
+func (self Key) String() string {
+	var name string
+	for k, v := range KEY_NAMES {
+		if v.Ty == self.Ty && v.X == self.X && (name == "" || len(k) < len(name)) {
+			name = k
+		}
+	}
+	if name == "" {
+		switch self.Ty {
+		case term.TyFunction: name = fmt.Sprintf("F%d", self.X)
+		case term.TyCodepoint: name = string(self.X)
+		default: name = "?"
+		}
+	}
+
+	var mods string
+	if self.Ctrl { mods += "C-" }
+	if self.Alt { mods += "A-" }
+	if self.Super { mods += "D-" }
+	if self.Shift { mods += "S-" }
+	if mods == "" && self.Ty == term.TyCodepoint && len(name) == 1 {
+		return name
+	}
+	return "<" + mods + name + ">"
+}
+
+type Binding struct {
+	Keys   []Key
+	Action Action
+}
+
+// Screen name -> bindings, "global" bindings are tried after the screen's
+type Keymap map[string][]Binding
+
+// Starts from DEFAULT_KEYMAP and applies the overrides from the config
+func New_keymap(overrides map[string]map[string]string) (Keymap, error) {
+	ret := Keymap{}
+	add := func(screen string, keys string, action Action) error {
+		if _, ok := DEFAULT_KEYMAP[screen]; !ok {
+			return fmt.Errorf("Unknown screen %q in keys config", screen)
+		}
+		parsed, err := Parse_keys(keys)
+		if err != nil {
+			return err
+		} else if len(parsed) == 0 {
+			return fmt.Errorf("Empty key sequence for %q", action)
+		}
+		ret[screen] = slices.DeleteFunc(ret[screen], func(b Binding) bool {
+			return slices.Equal(b.Keys, parsed)
+		})
+		if action != ActionNone && action != "" {
+			ret[screen] = append(ret[screen], Binding{ parsed, action })
+		}
+		return nil
+	}
+
+	for screen, bindings := range DEFAULT_KEYMAP {
+		for keys, action := range bindings {
+			must_valid_default(add(screen, keys, action))
+		}
+	}
+	for screen, bindings := range overrides {
+		for keys, action := range bindings {
+			if !Is_action(Action(action)) {
+				return nil, fmt.Errorf("Unknown action %q for %q", action, keys)
+			}
+			if err := add(screen, keys, Action(action)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for screen := range ret {
+		slices.SortFunc(ret[screen], compare_bindings)
+	}
+	return ret, nil
+}
+
+func must_valid_default(err error) {
+	if err != nil {
+		panic("DEV: invalid DEFAULT_KEYMAP: " + err.Error())
+	}
+}
+
+// By action, then shortest key first so that hints show e.g. "j" over "<Down>"
+func compare_bindings(a, b Binding) int {
+	if x := strings.Compare(string(a.Action), string(b.Action)); x != 0 {
+		return x
+	}
+	a_str, b_str := format_keys(a.Keys), format_keys(b.Keys)
+	if len(a_str) != len(b_str) {
+		return len(a_str) - len(b_str)
+	}
+	return strings.Compare(a_str, b_str)
+}
+
+func Is_action(action Action) bool {
+	if action == ActionNone {
+		return true
+	}
+	for _, bindings := range DEFAULT_KEYMAP {
+		for _, x := range bindings {
+			if x == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the action for a pending key sequence. is_prefix is true if the
+// sequence could still become a longer binding, in which case we wait for
+// the next key.
+func (self Keymap) Lookup(screen int, pending []Key) (action Action, is_prefix bool) {
+	for _, name := range []string{SCREEN_NAMES[screen], "global"} {
+		for _, b := range self[name] {
+			if len(b.Keys) > len(pending) && slices.Equal(b.Keys[:len(pending)], pending) {
+				is_prefix = true
+			} else if action == "" && slices.Equal(b.Keys, pending) {
+				action = b.Action
+			}
+		}
+		if action != "" && !is_prefix {
+			return action, false
+		}
+	}
+	return action, is_prefix
+}
+
+// The bindings that apply on a screen, screen-specific ones shadowing global
+func (self Keymap) Active(screen int) []Binding {
+	ret := slices.Clone(self[SCREEN_NAMES[screen]])
+	for _, b := range self["global"] {
+		shadowed := slices.ContainsFunc(ret, func(x Binding) bool { return slices.Equal(x.Keys, b.Keys) })
+		if !shadowed {
+			ret = append(ret, b)
+		}
+	}
+	slices.SortFunc(ret, compare_bindings)
+	return ret
+}
+
+// The first key sequence bound to action, e.g. for the footer hints
+func (self Keymap) Hint(screen int, action Action) string {
+	for _, b := range self.Active(screen) {
+		if b.Action == action {
+			return format_keys(b.Keys)
+		}
+	}
+	return ""
+}
+
+func format_keys(keys []Key) string {
+	var ret strings.Builder
+	for _, k := range keys {
+		ret.WriteString(k.String())
+	}
+	return ret.String()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Dispatch
+
+// Feeds a key into the pending sequence. Returns the bound action once the
+// sequence is complete, or "" while waiting for more keys or if it is unbound.
+// Anything that is not a key press, e.g. an unparsed sequence or a late reply
+// from the terminal, is ignored without a message.
+func (self *UIState) resolve_key(event term.Event) Action {
+	if event.Ty < term.TyEscape || event.Ty > term.TyCodepoint {
+		return ""
+	}
+	self.Key_pending = append(self.Key_pending, Key_of(event))
+	action, is_prefix := self.Keymap.Lookup(self.Screen, self.Key_pending)
+	if is_prefix {
+		return ""
+	}
+	if action == "" && len(self.Key_pending) > 1 {
+		// The sequence went nowhere, so try again with just this key
+		self.Key_pending = self.Key_pending[:0]
+		return self.resolve_key(event)
+	}
+	if action == "" {
+		_, _ = self.Message.WriteString(fmt.Sprintf("%s is not bound, press %s for help\n", Key_of(event), self.Keymap.Hint(self.Screen, ActionHelp)))
+	}
+	self.Key_pending = self.Key_pending[:0]
+	return action
+}
+
+// Actions that every screen with a list shares
+// Returns true if we should quit
+func (self *UIState) list_action(action Action, cancel context.CancelFunc, length int, viewport Viewport, selection *uint16) bool {
+	switch action {
+	case "":
+	case ActionQuit:
+		cancel()
+		return true
+	case ActionHelp:
+		self.Show_help = true
+	case ActionFilter:
+		self.Filter.Is_typing = true
+	case ActionFilter_clear:
+		self.Filter.Clear()
+	case ActionQueue_screen:
+		self.Filter.Clear()
+		self.Screen = ScreenQueue
+	default:
+		if !list_navigate(action, length, self.Filter, viewport, selection) {
+			_, _ = self.Message.WriteString(fmt.Sprintf("%q does nothing on this screen\n", action))
+		}
+	}
+	return false
+}
+
+// e.g. " q:quit r:refresh ?:help"
+func (self UIState) render_hints(writer *bufio.Writer, actions ...Action) {
+	for _, action := range actions {
+		if hint := self.Keymap.Hint(self.Screen, action); hint != "" {
+			fmt.Fprintf(writer, " %s:%s", hint, action)
+		}
+	}
+}
+
+// Lists every binding of the current screen over the list area
+func (self UIState) help_render(writer *bufio.Writer) {
+	type Line struct {
+		Action Action
+		Keys   []string
+	}
+	lines := []Line{}
+	for _, b := range self.Keymap.Active(self.Screen) {
+		if len(lines) > 0 && lines[len(lines) - 1].Action == b.Action {
+			lines[len(lines) - 1].Keys = append(lines[len(lines) - 1].Keys, format_keys(b.Keys))
+		} else {
+			lines = append(lines, Line{ b.Action, []string{format_keys(b.Keys)} })
+		}
+	}
+
+	row := LIST_TOP_ROW
+	print_row := func(text string) {
+		fmt.Fprintf(writer, "\x1B[%d;1H\x1B[2K%s", row, text)
+		row += 1
+	}
+	print_row(fmt.Sprintf(" Keys for the %s screen (press any key to close)", SCREEN_NAMES[self.Screen]))
+	print_row("")
+	for _, line := range lines {
+		if row >= self.Height {
+			print_row(" ...")
+			break
+		}
+		print_row(fmt.Sprintf("  %-20s %s", line.Action, strings.Join(line.Keys, ", ")))
+	}
+}
@@ -0,0 +1,124 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yueleshia/streamsurf/src/term"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run Key
+
+func TestParseKeys(t *testing.T) {
+	cases := []struct {
+		input string
+		want  []Key
+	}{
+		{ "j", []Key{{ Ty: term.TyCodepoint, X: 'j' }} },
+		{ "gg", []Key{{ Ty: term.TyCodepoint, X: 'g' }, { Ty: term.TyCodepoint, X: 'g' }} },
+		{ "<C-d>", []Key{{ Ty: term.TyCodepoint, X: 'd', Ctrl: true }} },
+		{ "<S-g>", []Key{{ Ty: term.TyCodepoint, X: 'G' }} },
+		{ "<A-S-Up>", []Key{{ Ty: term.TyArrowUp, Alt: true, Shift: true }} },
+		{ "<D-k>", []Key{{ Ty: term.TyCodepoint, X: 'k', Super: true }} },
+		{ "<F5>", []Key{{ Ty: term.TyFunction, X: 5 }} },
+		{ "<lt>", []Key{{ Ty: term.TyCodepoint, X: '<' }} },
+		{ "<C-->", []Key{{ Ty: term.TyCodepoint, X: '-', Ctrl: true }} },
+		{ "<", []Key{{ Ty: term.TyCodepoint, X: '<' }} },
+		{ "<Esc>", []Key{{ Ty: term.TyEscape }} },
+	}
+	for _, c := range cases {
+		got, err := Parse_keys(c.input)
+		a.AssertEqual(t, nil, err)
+		a.AssertEqual(t, c.want, got)
+	}
+
+	_, err := Parse_keys("<Nope>")
+	a.AssertEqual(t, true, err != nil)
+	_, err = Parse_keys("<X-a>")
+	a.AssertEqual(t, true, err != nil)
+}
+
+func TestKeyString(t *testing.T) {
+	for _, input := range []string{"j", "G", "<C-d>", "<PageDown>", "<A-S-Up>", "<F12>", "<Enter>"} {
+		keys, err := Parse_keys(input)
+		a.AssertEqual(t, nil, err)
+		a.AssertEqual(t, input, format_keys(keys))
+	}
+}
+
+func TestKeymap(t *testing.T) {
+	keymap, err := New_keymap(map[string]map[string]string{
+		"global": { "q": "none", "<C-n>": "select_next" },
+		"channel": { "gh": "back" },
+	})
+	a.AssertEqual(t, nil, err)
+
+	lookup := func(screen int, keys string) (Action, bool) {
+		parsed, err := Parse_keys(keys)
+		a.AssertEqual(t, nil, err)
+		return keymap.Lookup(screen, parsed)
+	}
+	action, is_prefix := lookup(ScreenFollow, "q")
+	a.AssertEqual(t, Action(""), action)
+	a.AssertEqual(t, false, is_prefix)
+
+	action, _ = lookup(ScreenFollow, "<C-n>")
+	a.AssertEqual(t, ActionSelect_next, action)
+
+	// Multi-key sequences wait on their prefix
+	action, is_prefix = lookup(ScreenChannel, "g")
+	a.AssertEqual(t, Action(""), action)
+	a.AssertEqual(t, true, is_prefix)
+	action, _ = lookup(ScreenChannel, "gh")
+	a.AssertEqual(t, ActionBack, action)
+	action, _ = lookup(ScreenChannel, "gg")
+	a.AssertEqual(t, ActionSelect_first, action)
+	action, _ = lookup(ScreenFollow, "gh")
+	a.AssertEqual(t, Action(""), action)
+
+	// Screen bindings shadow global ones
+	action, _ = lookup(ScreenQueue, "<Enter>")
+	a.AssertEqual(t, ActionPlay, action)
+	a.AssertEqual(t, "j", keymap.Hint(ScreenFollow, ActionSelect_next))
+
+	_, err = New_keymap(map[string]map[string]string{ "global": { "x": "explode" } })
+	a.AssertEqual(t, true, err != nil)
+	_, err = New_keymap(map[string]map[string]string{ "nowhere": { "x": "quit" } })
+	a.AssertEqual(t, true, err != nil)
+}
+
+func TestResolveKey(t *testing.T) {
+	ui := UIState{ Screen: ScreenFollow, Keymap: must_keymap(t) }
+	g := term.Event{ Ty: term.TyCodepoint, X: 'g' }
+	j := term.Event{ Ty: term.TyCodepoint, X: 'j' }
+
+	a.AssertEqual(t, Action(""), ui.resolve_key(g))
+	a.AssertEqual(t, ActionSelect_first, ui.resolve_key(g))
+	a.AssertEqual(t, 0, len(ui.Key_pending))
+
+	// A sequence that goes nowhere restarts from the last key
+	a.AssertEqual(t, Action(""), ui.resolve_key(g))
+	a.AssertEqual(t, ActionSelect_next, ui.resolve_key(j))
+
+	// Only key presses are reported as unbound, and the rest leave a pending
+	// sequence alone
+	a.AssertEqual(t, Action(""), ui.resolve_key(term.Event{ Ty: term.TyCodepoint, X: 'Q', Mod_ctrl: true }))
+	a.AssertEqual(t, true, strings.Contains(ui.Message.String(), "is not bound"))
+	ui.Message.Reset()
+	a.AssertEqual(t, Action(""), ui.resolve_key(term.Event{ Ty: term.TyFunction, X: 12 }))
+	a.AssertEqual(t, true, strings.Contains(ui.Message.String(), "is not bound"))
+	ui.Message.Reset()
+	a.AssertEqual(t, Action(""), ui.resolve_key(g))
+	for _, ty := range []int8{term.TyUnknown, term.TyMouse, term.TyKeyboardFlags, term.TyDeviceAttributes, term.TyGraphicsReply} {
+		a.AssertEqual(t, Action(""), ui.resolve_key(term.Event{ Ty: ty, X: 1 }))
+	}
+	a.AssertEqual(t, "", ui.Message.String())
+	a.AssertEqual(t, ActionSelect_first, ui.resolve_key(g))
+}
+
+func must_keymap(t *testing.T) Keymap {
+	keymap, err := New_keymap(nil)
+	a.AssertEqual(t, nil, err)
+	return keymap
+}
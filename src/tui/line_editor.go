@@ -0,0 +1,180 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/yueleshia/streamsurf/src/term"
+)
+
+// A line of text with readline-like editing, history and tab completion
+type LineEditor struct {
+	Is_typing bool
+	Text      []rune
+	Cursor    int // Index into Text
+	History   []string // Oldest first
+
+	history_pos int // len(History) while editing a new line
+	draft       []rune // The new line, while looking through the history
+	completion  *completion
+}
+
+// Tab cycles through the candidates, replacing Text[start:Cursor]
+type completion struct {
+	start      int
+	candidates []string
+	idx        int
+}
+
+const (
+	LineNone = iota
+	LineSubmit
+	LineCancel
+)
+
+// complete returns the candidates for a word being completed (without the @)
+// Returns one of LineNone, LineSubmit and LineCancel
+func (self *LineEditor) Input(event term.Event, complete func(prefix string) []string) int {
+	if !(event.Ty == term.TyCodepoint && event.X == '\t') {
+		self.completion = nil
+	}
+
+	switch event.Ty {
+	case term.TyEscape: return LineCancel
+	case term.TyArrowLeft: self.Cursor = max(self.Cursor - 1, 0)
+	case term.TyArrowRight: self.Cursor = min(self.Cursor + 1, len(self.Text))
+	case term.TyHome: self.Cursor = 0
+	case term.TyEnd: self.Cursor = len(self.Text)
+	case term.TyDelete: self.delete(self.Cursor, self.Cursor + 1)
+	case term.TyArrowUp: self.history_step(-1)
+	case term.TyArrowDown: self.history_step(1)
+	case term.TyCodepoint:
+		if event.Mod_ctrl {
+			switch event.X {
+			case 'a': self.Cursor = 0
+			case 'e': self.Cursor = len(self.Text)
+			case 'b': self.Cursor = max(self.Cursor - 1, 0)
+			case 'f': self.Cursor = min(self.Cursor + 1, len(self.Text))
+			case 'h': self.delete(self.Cursor - 1, self.Cursor)
+			case 'd': self.delete(self.Cursor, self.Cursor + 1)
+			case 'w': self.delete(self.word_start(), self.Cursor)
+			case 'u': self.delete(0, self.Cursor)
+			case 'k': self.delete(self.Cursor, len(self.Text))
+			case 'p': self.history_step(-1)
+			case 'n': self.history_step(1)
+			}
+			return LineNone
+		}
+		switch {
+		case event.X == '\n': return LineSubmit
+		case event.X == '\t': self.complete(complete)
+		case event.X == 127: self.delete(self.Cursor - 1, self.Cursor)
+		case unicode.IsPrint(event.X): self.insert(string(event.X))
+		}
+	}
+	return LineNone
+}
+
+func (self *LineEditor) insert(s string) {
+	self.Text = slices.Insert(self.Text, self.Cursor, []rune(s)...)
+	self.Cursor += len([]rune(s))
+}
+
+func (self *LineEditor) delete(start int, close int) {
+	start, close = max(start, 0), min(close, len(self.Text))
+	if start >= close {
+		return
+	}
+	self.Text = slices.Delete(self.Text, start, close)
+	if self.Cursor >= close {
+		self.Cursor -= close - start
+	} else if self.Cursor > start {
+		self.Cursor = start
+	}
+}
+
+// Start of the word before the cursor, like ctrl-w in a shell
+func (self LineEditor) word_start() int {
+	i := self.Cursor
+	for i > 0 && unicode.IsSpace(self.Text[i - 1]) {
+		i -= 1
+	}
+	for i > 0 && !unicode.IsSpace(self.Text[i - 1]) {
+		i -= 1
+	}
+	return i
+}
+
+// Returns the text and starts a new line, remembering it in the history
+func (self *LineEditor) Submit() string {
+	ret := string(self.Text)
+	if ret != "" && (len(self.History) == 0 || self.History[len(self.History) - 1] != ret) {
+		self.History = append(self.History, ret)
+	}
+	self.Text, self.Cursor = self.Text[:0], 0
+	self.history_pos, self.draft = len(self.History), nil
+	return ret
+}
+
+func (self *LineEditor) history_step(delta int) {
+	pos := self.history_pos + delta
+	if pos < 0 || pos > len(self.History) {
+		return
+	}
+	if self.history_pos == len(self.History) {
+		self.draft = slices.Clone(self.Text)
+	}
+	self.history_pos = pos
+	if pos == len(self.History) {
+		self.Text = self.draft
+	} else {
+		self.Text = []rune(self.History[pos])
+	}
+	self.Cursor = len(self.Text)
+}
+
+// Completes "@prefix" before the cursor, pressing tab again cycles through
+// the other candidates
+func (self *LineEditor) complete(candidates func(prefix string) []string) {
+	if x := self.completion; x != nil {
+		x.idx = (x.idx + 1) % len(x.candidates)
+		self.replace(x.start, "@" + x.candidates[x.idx])
+		return
+	}
+	start := self.word_start()
+	word := string(self.Text[start:self.Cursor])
+	prefix, ok := strings.CutPrefix(word, "@")
+	if !ok || strings.ContainsFunc(word, unicode.IsSpace) {
+		return
+	}
+	if list := candidates(prefix); len(list) > 0 {
+		self.completion = &completion{ start: start, candidates: list }
+		self.replace(start, "@" + list[0])
+	}
+}
+
+func (self *LineEditor) replace(start int, s string) {
+	self.delete(start, self.Cursor)
+	self.insert(s)
+}
+
+// Shows the part of the line around the cursor that fits in width columns,
+// with the cursor in reverse video
+func (self LineEditor) Render(writer *bufio.Writer, prompt string, width int) {
+	width = max(width - len(prompt) - 1, 1)
+	start := max(self.Cursor - width + 1, 0)
+	close := min(start + width, len(self.Text))
+	fmt.Fprint(writer, prompt)
+	fmt.Fprint(writer, string(self.Text[start:self.Cursor]))
+	cursor := " "
+	if self.Cursor < len(self.Text) {
+		cursor = string(self.Text[self.Cursor])
+	}
+	fmt.Fprintf(writer, "\x1B[7m%s\x1B[27m", cursor)
+	if self.Cursor < close {
+		fmt.Fprint(writer, string(self.Text[self.Cursor + 1:close]))
+	}
+}
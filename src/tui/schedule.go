@@ -0,0 +1,103 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// Upcoming streams from the channel schedules, shown under the follow list
+
+type ScheduleReply struct {
+	Channel  string
+	Segments []src.ScheduleSegment
+	Err      error
+}
+
+// At most this many upcoming streams are shown, plus a header row
+const UPCOMING_ROWS = 5
+const UPCOMING_WHEN_WIDTH = 19
+
+func Refresh_schedules(queue chan ScheduleReply, channels ...string) {
+	for _, channel := range channels {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+			defer cancel()
+			segments, err := src.Graph_schedule(ctx, channel)
+			queue <- ScheduleReply{ channel, segments, err }
+		}()
+	}
+}
+
+func (self *UIState) schedule_update(reply ScheduleReply) {
+	if reply.Err != nil {
+		_, _ = self.Message.WriteString(reply.Err.Error() + "\n")
+		return
+	}
+	if self.Schedules == nil {
+		self.Schedules = make(map[string][]src.ScheduleSegment)
+	}
+	self.Schedules[reply.Channel] = reply.Segments
+}
+
+// The next stream of each followed channel, soonest first. Cancelled
+// streams are skipped. Channels without a schedule that are likely live soon
+// are guessed from their past streams.
+func (self UIState) upcoming(now time.Time) []src.ScheduleSegment {
+	var ret []src.ScheduleSegment
+	for _, channel := range self.Channel_list {
+		for _, x := range self.Schedules[channel] {
+			if !x.Is_cancelled && x.Start_time.After(now) {
+				ret = append(ret, x)
+				break
+			}
+		}
+	}
+	ret = append(ret, self.predicted(now, ret)...)
+	slices.SortStableFunc(ret, func(a, b src.ScheduleSegment) int { return a.Start_time.Compare(b.Start_time) })
+	return ret
+}
+
+// Footer rows the upcoming section takes, 0 when nothing is scheduled
+func (self UIState) upcoming_rows(now time.Time) int {
+	count := min(len(self.upcoming(now)), UPCOMING_ROWS)
+	if count == 0 {
+		return 0
+	}
+	return count + 1
+}
+
+// e.g. "Tue 18:00, in 5h", or "due" once it has started
+func format_when(start time.Time, now time.Time) string {
+	until := start.Sub(now)
+	var in string
+	switch {
+	case until <= 0: in = "due"
+	case until >= 24 * time.Hour: in = fmt.Sprintf("in %dd", int(until.Hours()) / 24)
+	case until >= time.Hour: in = fmt.Sprintf("in %dh", int(until.Hours()))
+	default: in = fmt.Sprintf("in %dm", int(until.Minutes()))
+	}
+	return start.Format("Mon 15:04") + ", " + in
+}
+
+func (self UIState) upcoming_render(writer *bufio.Writer, now time.Time) {
+	segments := self.upcoming(now)
+	if len(segments) == 0 {
+		return
+	}
+	fmt.Fprintf(writer, "Upcoming\r\n")
+
+	gap := " | "
+	sizes := Column_sizes(self.Width, gap)
+	// Channel, title, category, when
+	sizes = []int{sizes[0], sizes[1] + sizes[3] + sizes[4] + sizes[5] + 2 * len(gap) - UPCOMING_WHEN_WIDTH, sizes[2], UPCOMING_WHEN_WIDTH}
+	for _, x := range segments[:min(len(segments), UPCOMING_ROWS)] {
+		_ = print_line(writer, gap, sizes, []string{x.Channel, x.Title, x.Category, format_when(x.Start_time.Local(), now)}, nil)
+		fmt.Fprint(writer, "\r")
+	}
+}
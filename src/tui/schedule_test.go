@@ -0,0 +1,39 @@
+package tui
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run 'Upcoming|FormatWhen'
+
+func TestUpcoming(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	ui := &UIState{ Channel_list: []string{"a", "b", "c"} }
+	segment := func(channel string, hours int, is_cancelled bool) src.ScheduleSegment {
+		return src.ScheduleSegment{ Id: fmt.Sprintf("%s%d", channel, hours), Channel: channel, Start_time: now.Add(time.Duration(hours) * time.Hour), Is_cancelled: is_cancelled }
+	}
+	a.AssertEqual(t, 0, ui.upcoming_rows(now))
+
+	ui.schedule_update(ScheduleReply{ Channel: "a", Segments: []src.ScheduleSegment{segment("a", 30, false), segment("a", 50, false)} })
+	// Cancelled and already started streams are skipped
+	ui.schedule_update(ScheduleReply{ Channel: "b", Segments: []src.ScheduleSegment{segment("b", -1, false), segment("b", 2, true), segment("b", 5, false)} })
+	// Not followed
+	ui.schedule_update(ScheduleReply{ Channel: "z", Segments: []src.ScheduleSegment{segment("z", 1, false)} })
+	ui.schedule_update(ScheduleReply{ Channel: "c", Err: fmt.Errorf("Schedule of c failed") })
+
+	a.AssertEqual(t, []src.ScheduleSegment{segment("b", 5, false), segment("a", 30, false)}, ui.upcoming(now))
+	a.AssertEqual(t, 3, ui.upcoming_rows(now))
+	a.AssertEqual(t, "Schedule of c failed\n", ui.Message.String())
+}
+
+func TestFormatWhen(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	a.AssertEqual(t, "Mon 12:40, in 40m", format_when(now.Add(40 * time.Minute), now))
+	a.AssertEqual(t, "Mon 18:00, in 6h", format_when(now.Add(6 * time.Hour), now))
+	a.AssertEqual(t, "Thu 18:00, in 3d", format_when(now.Add(78 * time.Hour), now))
+}
@@ -0,0 +1,247 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/term"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+// Search screen
+
+const (
+	SearchRowLive int = iota
+	SearchRowChannel
+	SearchRowCategory
+)
+
+// Live streams, then channels, then categories, Idx is into the list of Kind
+type SearchRow struct {
+	Kind int
+	Idx  int
+}
+
+type SearchReply struct {
+	Query   string
+	Results src.SearchResults
+	Err     error
+}
+
+const SEARCH_FOOTER_ROWS = 1 + MESSAGE_ROWS
+const SEARCH_KIND_WIDTH = 8
+
+func (self *UIState) search_open() {
+	self.Filter.Clear()
+	if self.Screen != ScreenSearch {
+		self.Search_return = self.Screen
+	}
+	self.Screen = ScreenSearch
+	self.Search_query.Is_typing = true
+	self.Search_query.Cursor = len(self.Search_query.Text)
+}
+
+func (self *UIState) search_run() {
+	query := strings.TrimSpace(string(self.Search_query.Text))
+	if query == "" {
+		return
+	}
+	self.Search_pending = query
+	_, _ = self.Message.WriteString(fmt.Sprintf("Searching for %q\n", query))
+	queue := self.Search_queue
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+		defer cancel()
+		results, err := src.Search(ctx, query)
+		queue <- SearchReply{ query, results, err }
+	}()
+}
+
+// Replies to anything but the latest query are dropped
+func (self *UIState) search_update(reply SearchReply) {
+	if reply.Query != self.Search_pending {
+		return
+	}
+	self.Search_pending = ""
+	if reply.Err != nil {
+		_, _ = self.Message.WriteString(reply.Err.Error() + "\n")
+		return
+	}
+	results := reply.Results
+	self.Search_results = results
+	self.Search_rows = self.Search_rows[:0]
+	for i := range results.Live {
+		self.Search_rows = append(self.Search_rows, SearchRow{ SearchRowLive, i })
+	}
+	for i := range results.Channels {
+		self.Search_rows = append(self.Search_rows, SearchRow{ SearchRowChannel, i })
+	}
+	for i := range results.Categories {
+		self.Search_rows = append(self.Search_rows, SearchRow{ SearchRowCategory, i })
+	}
+	self.Search_selection = 0
+	self.Search_viewport.Offset = 0
+	_, _ = self.Message.WriteString(fmt.Sprintf("Found %d live, %d channels and %d categories for %q\n", len(results.Live), len(results.Channels), len(results.Categories), reply.Query))
+}
+
+// The login of the selected row, empty for categories
+func (self UIState) search_channel() string {
+	if int(self.Search_selection) >= len(self.Search_rows) {
+		return ""
+	}
+	row := self.Search_rows[self.Search_selection]
+	switch row.Kind {
+	case SearchRowLive: return self.Search_results.Live[row.Idx].Channel
+	case SearchRowChannel: return self.Search_results.Channels[row.Idx].Login
+	}
+	return ""
+}
+
+func (self *UIState) search_select() {
+	if int(self.Search_selection) >= len(self.Search_rows) {
+		return
+	}
+	row := self.Search_rows[self.Search_selection]
+	if row.Kind == SearchRowCategory {
+		self.category_open(self.Search_results.Categories[row.Idx].Name)
+		return
+	}
+	channel := self.search_channel()
+	if row.Kind == SearchRowLive {
+		self.Channel_live = self.Search_results.Live[row.Idx]
+	}
+	self.Channel_return = ScreenSearch
+	self.Channel_selection = 0
+	self.Channel_viewport.Offset = 0
+	Refresh_channels(self.Refresh_queue, channel)
+	self.channel_swap(channel)
+}
+
+// Adds a channel to the follow list, and saves the list so that it is there
+// on the next start too
+func (self *UIState) follow_add(channel string) error {
+	if slices.ContainsFunc(self.Channel_list, func(x string) bool { return strings.EqualFold(x, channel) }) {
+		return fmt.Errorf("Already following %s", channel)
+	}
+	if err := src.Check_channel_limit(len(self.Channel_list) + 1); err != nil {
+		return err
+	}
+	list := append(slices.Clone(self.Channel_list), channel)
+	if self.Channel_list_path != "" {
+		if err := src.Write_channel_list(self.Channel_list_path, list); err != nil {
+			return err
+		}
+	}
+	self.Channel_list = list
+
+	blank := src.Video{ Channel: channel }
+	self.Follow_videos = append(self.Follow_videos, blank)
+	// @VOLATILE: follow_swap needs a key per Follow_videos entry
+	self.Follow_latest[channel] = FollowPair{blank, blank}
+	Refresh_channels(self.Refresh_queue, channel)
+	Refresh_schedules(self.Schedule_queue, channel)
+	return nil
+}
+
+func (self *UIState) search_input(event term.Event, cancel context.CancelFunc) bool {
+	self.Message.Reset()
+	if self.Search_query.Is_typing && event.Ty != term.TyMouse {
+		switch self.Search_query.Input(event, func(string) []string { return nil }) {
+		case LineSubmit:
+			self.Search_query.Is_typing = false
+			self.search_run()
+		case LineCancel:
+			self.Search_query.Is_typing = false
+			if len(self.Search_rows) == 0 {
+				self.Screen = self.Search_return
+			}
+		}
+		return false
+	}
+
+	length := len(self.Search_rows)
+	if event.Ty == term.TyMouse {
+		if list_scroll(event, length, Filter{}, &self.Search_selection) {
+		} else if self.list_click(event, length, Filter{}, self.Search_viewport, &self.Search_selection) {
+			self.search_select()
+		}
+		return false
+	}
+
+	action := self.resolve_key(event)
+	switch action {
+	case ActionBack:
+		self.Screen = self.Search_return
+	case ActionOpen:
+		self.search_select()
+	case ActionSearch, ActionFilter:
+		self.search_open()
+	case ActionRefresh:
+		self.search_run()
+	case ActionFollow_add:
+		if channel := self.search_channel(); channel == "" {
+			_, _ = self.Message.WriteString("Only channels can be followed\n")
+		} else if err := self.follow_add(channel); err != nil {
+			_, _ = self.Message.WriteString(err.Error() + "\n")
+		} else {
+			_, _ = self.Message.WriteString(fmt.Sprintf("Followed %s\n", channel))
+		}
+	default:
+		return self.list_action(action, cancel, length, self.Search_viewport, &self.Search_selection)
+	}
+	return false
+}
+
+// e.g. 1234 -> 1.2k
+func format_count(n int) string {
+	switch {
+	case n >= 1_000_000: return fmt.Sprintf("%.1fM", float64(n) / 1_000_000)
+	case n >= 1_000: return fmt.Sprintf("%.1fk", float64(n) / 1_000)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+func (self UIState) search_render(writer *bufio.Writer) {
+	if self.Search_query.Is_typing {
+		self.Search_query.Render(writer, "Search: ", self.Width)
+	} else {
+		fmt.Fprintf(writer, "Search: %s %s", string(self.Search_query.Text), self.Search_viewport.Indicator(len(self.Search_rows)))
+	}
+
+	gap := " | "
+	width := self.Width - SEARCH_KIND_WIDTH - len(gap)
+	// Channel, description, followers and viewers if live
+	sizes := Column_sizes(width, gap)
+	sizes = []int{sizes[0], max(width - 1 - sizes[0] - 14 - 6 - 3 * len(gap), 10), 14, 6}
+	rows := visible_rows(len(self.Search_rows), Filter{})
+	render_list(writer, LIST_TOP_ROW, self.Search_selection, rows, self.Search_viewport, func(idx int) {
+		row := self.Search_rows[idx]
+		switch row.Kind {
+		case SearchRowLive:
+			fmt.Fprintf(writer, "%-*s%s", SEARCH_KIND_WIDTH, "live", gap)
+			print_formatted_line_marked(writer, gap, self.Search_results.Live[row.Idx], [2][]int{}, width)
+		case SearchRowChannel:
+			x := self.Search_results.Channels[row.Idx]
+			var live string
+			if x.Is_live {
+				live = "○ " + format_count(x.Viewers)
+			}
+			fmt.Fprintf(writer, "%-*s%s", SEARCH_KIND_WIDTH, "channel", gap)
+			_ = print_line(writer, gap, sizes, []string{x.Login, strings.Join(strings.Fields(x.Description), " "), format_count(x.Followers) + " followers", live}, nil)
+		case SearchRowCategory:
+			x := self.Search_results.Categories[row.Idx]
+			fmt.Fprintf(writer, "%-*s%s", SEARCH_KIND_WIDTH, "category", gap)
+			_ = print_line(writer, gap, []int{sizes[0] + len(gap) + sizes[1], sizes[2], sizes[3]}, []string{x.Display_name, format_count(x.Viewers) + " viewers", ""}, nil)
+		}
+	})
+
+	render_footer_start(writer, self.Height, SEARCH_FOOTER_ROWS)
+	self.render_hints(writer, ActionQuit, ActionBack, ActionOpen, ActionSearch, ActionFollow_add, ActionHelp)
+	fmt.Fprintf(writer, "\r\n")
+	render_message(writer, self.Message.String())
+}
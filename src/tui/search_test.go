@@ -0,0 +1,76 @@
+package tui
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/term"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run Search
+
+func search_state(t *testing.T) *UIState {
+	ui := &UIState{}
+	ui.Load_config("tsoding\nforsen")
+	ui.Keymap = src.Must(New_keymap(nil))
+	ui.Channel_list_path = filepath.Join(t.TempDir(), "channel_list.txt")
+	ui.Screen = ScreenFollow
+	ui.search_open()
+	for _, r := range "coding" {
+		ui.search_input(term.Event{ Ty: term.TyCodepoint, X: r }, nil)
+	}
+	ui.search_input(term.Event{ Ty: term.TyCodepoint, X: '\n' }, nil)
+	return ui
+}
+
+func TestSearchScreen(t *testing.T) {
+	ui := search_state(t)
+	a.AssertEqual(t, "coding", ui.Search_pending)
+	a.AssertEqual(t, false, ui.Search_query.Is_typing)
+
+	// Stale replies are dropped
+	ui.search_update(SearchReply{ Query: "cod", Err: fmt.Errorf("stale") })
+	a.AssertEqual(t, 0, len(ui.Search_rows))
+	ui.search_update(SearchReply{ Query: "coding", Results: src.SearchResults{
+		Live: []src.Video{{ Channel: "bigcoder", Is_live: true }},
+		Channels: []src.SearchChannel{{ Login: "tsoding" }, { Login: "codingtrain" }},
+		Categories: []src.SearchCategory{{ Name: "Software and Game Development" }},
+	}})
+	a.AssertEqual(t, []SearchRow{{ SearchRowLive, 0 }, { SearchRowChannel, 0 }, { SearchRowChannel, 1 }, { SearchRowCategory, 0 }}, ui.Search_rows)
+
+	// Following
+	ui.Search_selection = 1
+	a.AssertEqual(t, "Already following tsoding", ui.follow_add(ui.search_channel()).Error())
+	ui.Search_selection = 3
+	a.AssertEqual(t, "", ui.search_channel())
+	ui.Search_selection = 2
+	ui.search_input(term.Event{ Ty: term.TyCodepoint, X: 'a' }, nil)
+	a.AssertEqual(t, "Followed codingtrain\n", ui.Message.String())
+	a.AssertEqual(t, []string{"tsoding", "forsen", "codingtrain"}, ui.Channel_list)
+	a.AssertEqual(t, 3, len(ui.Follow_videos))
+	saved, ok, err := src.Read_channel_list(ui.Channel_list_path)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, true, ok)
+	a.AssertEqual(t, ui.Channel_list, saved)
+
+	// A live stream we do not follow still shows on its channel screen, and
+	// going back returns to the search
+	ui.Search_selection = 0
+	ui.search_input(term.Event{ Ty: term.TyCodepoint, X: '\n' }, nil)
+	a.AssertEqual(t, ScreenChannel, ui.Screen)
+	a.AssertEqual(t, "bigcoder", ui.Channel)
+	a.AssertEqual(t, []src.Video{{ Channel: "bigcoder", Is_live: true }}, ui.Channel_videos.As_slice())
+	ui.channel_input(term.Event{ Ty: term.TyCodepoint, X: 'h' }, nil)
+	a.AssertEqual(t, ScreenSearch, ui.Screen)
+	ui.search_input(term.Event{ Ty: term.TyCodepoint, X: 'h' }, nil)
+	a.AssertEqual(t, ScreenFollow, ui.Screen)
+}
+
+func TestFormatCount(t *testing.T) {
+	a.AssertEqual(t, "999", format_count(999))
+	a.AssertEqual(t, "1.2k", format_count(1234))
+	a.AssertEqual(t, "3.5M", format_count(3_456_789))
+}
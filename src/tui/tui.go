@@ -10,10 +10,16 @@ import (
 
 	"io"
 	"os/exec"
+	"os/signal"
+	"time"
 
+	"github.com/rivo/uniseg"
 	xterm "golang.org/x/term"
 
 	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/chat"
+	"github.com/yueleshia/streamsurf/src/emotes"
+	"github.com/yueleshia/streamsurf/src/graphics"
 	"github.com/yueleshia/streamsurf/src/term"
 )
 
@@ -88,9 +94,21 @@ func (self *UIState) Interactive() {
 		err := xterm.Restore(stdin_fd, old_state)
 		_ = err
 	}()
-	_ = src.Must(writer.WriteString(term.Enter_alt_buffer + "\x1B[1;1H" + term.Hide_cursor))
+	// Without auto wrap, long lines are clipped instead of pushing the footer down
+	_ = src.Must(writer.WriteString(term.Enter_alt_buffer + "\x1B[1;1H" + term.Hide_cursor + term.Reset_auto_wrap + term.Enable_mouse_tracking))
+	self.Emotes = emotes.New_registry()
+	defer self.chat_close()
+	// The replies arrive as events, see the TyKeyboardFlags case
+	self.Images = graphics.New_store()
+	self.Images.Set_cell_size(term.Sys_cell_size(stdin_fd))
+	_ = src.Must(writer.WriteString(term.Query_kitty_keyboard + graphics.Query_kitty_graphics + term.Query_device_attributes))
 	defer func() {
-		_ = src.Must(writer.WriteString(term.Leave_alt_buffer +  term.Show_cursor))
+		if self.Kitty_keyboard {
+			_ = src.Must(writer.WriteString(term.Disable_kitty_keyboard))
+			self.Kitty_keyboard = false
+		}
+		src.Must1(self.Images.Close(writer))
+		_ = src.Must(writer.WriteString(term.Disable_mouse_tracking + term.Enable_auto_wrap + term.Leave_alt_buffer +  term.Show_cursor))
 		src.Must1(writer.Flush())
 	}()
 
@@ -102,38 +120,28 @@ func (self *UIState) Interactive() {
 	////////////////////////////////////////////////////////////////////////////
 	// Setup inital screen
 
+	self.layout()
 	render(writer, *self)
 	src.Must1(writer.Flush())
 
+	resize_queue := make(chan os.Signal, 1)
+	term.Sys_notify_resize(resize_queue)
+	defer signal.Stop(resize_queue)
+
 	refresh_queue := make(chan bool, 100)
 	self.Refresh_queue = make(chan src.VideoPacket, 100)
 	Refresh_channels(self.Refresh_queue, self.Channel_list...)
+	Refresh_schedules(self.Schedule_queue, self.Channel_list...)
 
 	// Setup input loop
 	// We do not want tob lock the main loop, so that we can have async updates
 	// But we also cannot set the stdin to be non-blocking so we do not busy loop
 	input_queue_size := 32
 	input_queue := make(chan term.Event, input_queue_size)
-	go func () {
-		var buffer [32]byte
-		for {
-			var parser term.InputParser
-			if n, err := term.Sys_read(stdin_fd, buffer[:]); err != nil || n < 1 {
-				continue
-			} else {
-				parser = buffer[:n]
-			}
-			for {
-				if evt := parser.Next(); evt == nil {
-					break
-				} else if evt == nil {
-					break
-				} else {
-					input_queue <- *evt
-				}
-			}
-		}
-	}()
+	if self.Keymap == nil {
+		self.Keymap = src.Must(New_keymap(nil))
+	}
+	go term.Read_events(stdin_fd, input_queue)
 
 	////////////////////////////////////////////////////////////////////////////
 
@@ -142,6 +150,19 @@ func (self *UIState) Interactive() {
 		case <-ctx.Done(): break main_loop
 		case <-refresh_queue:
 
+		case <-resize_queue:
+			if w, h, err := xterm.GetSize(stdin_fd); err == nil {
+				self.Width = w
+				self.Height = h
+			}
+			self.Images.Set_cell_size(term.Sys_cell_size(stdin_fd))
+
+		case loaded := <-self.Images.Queue:
+			if loaded.Err != nil {
+				src.L_DEBUG.Printf("Could not load %s: %s", loaded.Url, loaded.Err)
+			}
+			self.Images.Add(loaded)
+
 		case message := <-self.Log_queue:
 			fmt.Println("hello")
 			_, _ = self.Message.Write(message)
@@ -152,6 +173,9 @@ func (self *UIState) Interactive() {
 				_ = self.Message.WriteByte('\n')
 			} else {
 				self.Add_and_update_follow(packet)
+				if len(packet.Vids) > 0 {
+					self.activity_update(packet.Vids[0].Channel)
+				}
 				if self.Message.String() != "Refreshed\n" {
 					_, _  = self.Message.WriteString("Refreshed\n")
 				}
@@ -161,14 +185,77 @@ func (self *UIState) Interactive() {
 			switch (self.Screen) {
 			case ScreenFollow: self.follow_swap()
 			case ScreenChannel: self.channel_swap(self.Channel)
+			case ScreenQueue, ScreenChat, ScreenSearch, ScreenCategory:
 			default: panic("DEV: Unsupport screen")
 			}
 
+		case err := <-self.Player_exit:
+			self.queue_advance(err)
+
+		case msg := <-self.Chat_queue:
+			self.chat_push(msg)
+
+		case err := <-self.Chat_error:
+			_, _ = self.Message.WriteString(err.Error())
+			_ = self.Message.WriteByte('\n')
+
+		case reply := <-self.Search_queue:
+			self.search_update(reply)
+
+		case reply := <-self.Category_queue:
+			self.category_update(reply)
+
+		case reply := <-self.Schedule_queue:
+			self.schedule_update(reply)
+
+		case reply := <-self.History_queue:
+			self.history_update(reply)
+
+		case err := <-self.Emotes_loaded:
+			// Chat is still readable without them
+			if err != nil {
+				src.L_DEBUG.Printf("%s", err)
+			}
+
 		case event := <- input_queue:
+			switch event.Ty {
+			case term.TyKeyboardFlags:
+				// Only kitty-protocol terminals reply to the query
+				if !self.Kitty_keyboard {
+					_ = src.Must(writer.WriteString(term.Enable_kitty_keyboard))
+					src.Must1(writer.Flush())
+					self.Kitty_keyboard = true
+				}
+				continue
+			case term.TyGraphicsReply:
+				if event.X == 1 {
+					self.Images.Protocol = graphics.ProtocolKitty
+				}
+				continue
+			case term.TyDeviceAttributes:
+				// Always replied to after the kitty queries, so if we have not
+				// enabled the keyboard protocol by now, we stay on legacy
+				// parsing. Prefer kitty graphics over sixel.
+				if event.X == 1 && self.Images.Protocol == graphics.ProtocolNone {
+					self.Images.Protocol = graphics.ProtocolSixel
+				}
+				continue
+			}
+
+			// Any key closes the help, rather than doing what it is bound to
+			if self.Show_help && event.Ty != term.TyMouse {
+				self.Show_help = false
+				break
+			}
+
 			is_break := false
 			switch (self.Screen) {
 			case ScreenFollow: is_break = self.follow_input(event, cancel)
 			case ScreenChannel: is_break = self.channel_input(event, cancel)
+			case ScreenQueue: is_break = self.queue_input(event, cancel)
+			case ScreenChat: is_break = self.chat_input(event, cancel)
+			case ScreenSearch: is_break = self.search_input(event, cancel)
+			case ScreenCategory: is_break = self.category_input(event, cancel)
 			default: panic("DEV: Unsupport screen")
 			}
 
@@ -177,36 +264,104 @@ func (self *UIState) Interactive() {
 			}
 		}
 
+		self.layout()
 		render(writer, *self)
 	}
 }
 
 func render(writer *bufio.Writer, ui UIState) {
 	fmt.Fprint(writer, term.Clear + "\x1B[1;1H")
+	if ui.Images != nil {
+		src.Must1(ui.Images.Clear(writer))
+	}
 	switch ui.Screen {
 	case ScreenFollow: ui.follow_render(writer)
 	case ScreenChannel: ui.channel_render(writer)
+	case ScreenQueue: ui.queue_render(writer)
+	case ScreenChat: ui.chat_render(writer)
+	case ScreenSearch: ui.search_render(writer)
+	case ScreenCategory: ui.category_render(writer)
 	default: panic("DEV: Unsupport screen")
 	}
+	if ui.Show_help {
+		ui.help_render(writer)
+	}
 	src.Must1(writer.Flush())
 }
 
-func render_video_list(writer *bufio.Writer, selection uint16, videos []src.Video) {
-	for i := uint16(0); int(i) < len(videos); i += 1 {
-		fmt.Fprintf(writer, "\x1B[%d;1H", i + 2)
-		if i == selection {
-			fmt.Fprintf(writer, "\x1B[0;%s%s;%s%sm", term.Part_foreground, term.Part_white, term.Part_background, term.Part_black)
-		}
-		Print_formatted_line(writer, " | ", videos[i])
-		if i == selection {
-			fmt.Fprintf(writer, term.Reset_attributes)
+// Number of rows below the list for each screen
+const (
+	FOLLOW_FOOTER_ROWS = 3 + MESSAGE_ROWS
+	CHANNEL_FOOTER_ROWS = 7 + MESSAGE_ROWS
+	QUEUE_FOOTER_ROWS = 1 + MESSAGE_ROWS
+
+	// Which row of the channel footer lists the chapters
+	CHANNEL_CHAPTER_ROW = 6
+	CHAPTER_PREFIX = "Chapters: "
+	CHAPTER_GAP = " | "
+)
+
+// Sizes the viewport of the current screen to the terminal and scrolls it
+// so that the selection stays visible
+func (self *UIState) layout() {
+	list_rows := func(footer int) int {
+		return max(self.Height - 1 - footer, 0) // 1 for the header
+	}
+
+	switch self.Screen {
+	case ScreenFollow:
+		rows := visible_rows(len(self.Follow_videos), self.Filter)
+		self.Follow_viewport.Rows = list_rows(FOLLOW_FOOTER_ROWS + self.upcoming_rows(time.Now()))
+		self.Follow_viewport.Scroll_to(max(slices.Index(rows, int(self.Follow_selection)), 0), len(rows))
+	case ScreenChannel:
+		rows := visible_rows(len(self.Channel_videos.As_slice()), self.Filter)
+		self.Channel_viewport.Rows = list_rows(CHANNEL_FOOTER_ROWS)
+		self.Channel_viewport.Scroll_to(max(slices.Index(rows, int(self.Channel_selection)), 0), len(rows))
+		if vid, ok := self.channel_selected(); ok && self.has_detail_pane() {
+			if len(vid.Thumbnail_URL) > 0 {
+				self.Images.Request(vid.Thumbnail_URL[0])
+			}
+			self.Images.Request(vid.Avatar_URL)
 		}
+	case ScreenQueue:
+		self.Queue_viewport.Rows = list_rows(QUEUE_FOOTER_ROWS)
+		self.Queue_viewport.Scroll_to(int(self.Queue_selection), len(self.Queue.Items))
+	case ScreenChat:
+		self.chat_layout()
+	case ScreenSearch:
+		self.Search_viewport.Rows = list_rows(SEARCH_FOOTER_ROWS)
+		self.Search_viewport.Scroll_to(int(self.Search_selection), len(self.Search_rows))
+	case ScreenCategory:
+		rows := visible_rows(len(self.Category_videos), self.Filter)
+		self.Category_viewport.Rows = list_rows(CATEGORY_FOOTER_ROWS)
+		self.Category_viewport.Scroll_to(max(slices.Index(rows, int(self.Category_selection)), 0), len(rows))
+	default: panic("DEV: Unsupport screen")
 	}
 }
 
+func render_video_list(writer *bufio.Writer, width int, selection uint16, videos []src.Video, filter Filter, viewport Viewport) {
+	rows := visible_rows(len(videos), filter)
+	render_list(writer, LIST_TOP_ROW, selection, rows, viewport, func(idx int) {
+		print_formatted_line_marked(writer, " | ", videos[idx], filter.Matches[idx], width)
+	})
+}
+
+// Moves the cursor to the first row of the footer
+func render_footer_start(writer *bufio.Writer, height int, footer_rows int) {
+	fmt.Fprintf(writer, "\x1B[%d;1H", max(height - footer_rows + 1, 1))
+}
+
+// Only the last MESSAGE_ROWS lines are shown so that the footer stays pinned
 func render_message(writer *bufio.Writer, message string) {
-	for part := range strings.SplitSeq(message, "\n") {
-		fmt.Fprintf(writer, "%s\r\n", part)
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	if len(lines) > MESSAGE_ROWS {
+		lines = lines[len(lines) - MESSAGE_ROWS:]
+	}
+	for i, part := range lines {
+		if i != 0 {
+			fmt.Fprint(writer, "\r\n")
+		}
+		fmt.Fprint(writer, part)
 	}
 }
 
@@ -227,187 +382,561 @@ func (self *UIState) follow_swap() {
 		idx += 1
 	}
 	slices.SortFunc(self.Follow_videos, src.Sort_videos_by_latest)
+	self.Filter.Update(self.Follow_videos)
+	self.Follow_selection = uint16(self.Filter.Settle(len(self.Follow_videos), int(self.Follow_selection)))
+}
+
+func (self *UIState) follow_open() {
+	if self.Filter.Includes(int(self.Follow_selection)) {
+		vid := self.Follow_videos[self.Follow_selection]
+		self.Filter.Clear()
+		self.Channel_selection = 0
+		self.Channel_viewport.Offset = 0
+		self.Channel_return = ScreenFollow
+		self.channel_swap(vid.Channel)
+	}
 }
 
 func (self *UIState) follow_input(event term.Event, cancel context.CancelFunc) bool {
 	self.Message.Reset()
-	switch event.Ty {
-	case term.TyCodepoint:
-		switch event.X {
-		case 'c':
-			if event.Mod_ctrl {
-				cancel()
-				return true
-			}
-		case 'q':
-			cancel()
-			return true
-
-		case 'r':
-			Refresh_channels(self.Refresh_queue, self.Channel_list...)
-			
-		case 'j':
-			if int(self.Follow_selection) + 1 < len(self.Follow_videos) {
-				self.Follow_selection += 1
-			}
-		case 'k':
-			if int(self.Follow_selection) > 0 {
-				self.Follow_selection -= 1
-			}
-		case 'l':
-			vid := self.Follow_videos[self.Follow_selection]
-			self.channel_swap(vid.Channel)
+	if self.filter_input(event, self.Follow_videos, &self.Follow_selection) {
+		return false
+	}
+	if event.Ty == term.TyMouse {
+		if list_scroll(event, len(self.Follow_videos), self.Filter, &self.Follow_selection) {
+		} else if self.list_click(event, len(self.Follow_videos), self.Filter, self.Follow_viewport, &self.Follow_selection) {
+			self.follow_open()
+		}
+		return false
+	}
 
-		default:
-			self.Message.WriteString(fmt.Sprintf("%d %+v\n", event.Ty, event))
+	action := self.resolve_key(event)
+	switch action {
+	case ActionRefresh:
+		Refresh_channels(self.Refresh_queue, self.Channel_list...)
+		Refresh_schedules(self.Schedule_queue, self.Channel_list...)
+	case ActionOpen:
+		self.follow_open()
+	case ActionSearch:
+		self.search_open()
+	case ActionCategories:
+		self.category_step(0)
+	case ActionChat:
+		if self.Filter.Includes(int(self.Follow_selection)) {
+			self.chat_open(self.Follow_videos[self.Follow_selection])
+		}
+	case ActionQueue_add:
+		if self.Filter.Includes(int(self.Follow_selection)) {
+			self.queue_add(self.Follow_videos[self.Follow_selection], "")
 		}
 	default:
-		self.Message.WriteString(fmt.Sprintf("%d %+v\n", event.Ty, event))
+		return self.list_action(action, cancel, len(self.Follow_videos), self.Follow_viewport, &self.Follow_selection)
 	}
 	return false
 }
 
 func (self UIState) follow_render(writer *bufio.Writer) {
-	height_left := self.Height
-	fmt.Fprint(writer, "Follow\n")
-	height_left -= 1
-
-	to_render := self.Follow_videos
-	if len(to_render) < height_left - 2 {
-		to_render = to_render[:len(to_render)]
-	}
-	render_video_list(writer, self.Follow_selection, to_render)
-
-	fmt.Fprintf(writer, "\r\n (q)uit (r)efresh (hjkl) navigate")
-	fmt.Fprintf(writer, "\r\n")
+	rows := visible_rows(len(self.Follow_videos), self.Filter)
+	fmt.Fprintf(writer, "Follow %s", self.Follow_viewport.Indicator(len(rows)))
+	render_video_list(writer, self.Width, self.Follow_selection, self.Follow_videos, self.Filter, self.Follow_viewport)
+
+	now := time.Now()
+	render_footer_start(writer, self.Height, FOLLOW_FOOTER_ROWS + self.upcoming_rows(now))
+	self.upcoming_render(writer, now)
+	self.filter_render(writer)
+	fmt.Fprint(writer, "\r\n")
+	self.render_hints(writer, ActionQuit, ActionRefresh, ActionOpen, ActionFilter, ActionChat, ActionQueue_add, ActionQueue_screen, ActionSearch, ActionCategories, ActionHelp)
 	fmt.Fprintf(writer, "\r\nui_selection: %d\r\n", self.Follow_selection)
-	fmt.Fprintf(writer, "\r\n")
 	render_message(writer, self.Message.String())
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 // Channel screen
 
+type ChannelTab struct {
+	Name string
+	Broadcast_type string // Empty for every type
+}
+
+// The live stream is listed under "All" and "Past broadcasts", as that is
+// what it becomes
+var CHANNEL_TABS = []ChannelTab{
+	{"All", ""},
+	{"Past broadcasts", src.BroadcastArchive},
+	{"Highlights", src.BroadcastHighlight},
+	{"Uploads", src.BroadcastUpload},
+	{"Past premieres", src.BroadcastPremiere},
+}
+
 func (self *UIState) channel_swap(channel string) {
 	self.Screen = ScreenChannel
 	self.Channel = channel
 	self.Channel_command = self.Channel_command[:0]
 
+	broadcast_type := CHANNEL_TABS[self.Channel_tab].Broadcast_type
 	self.Channel_videos.Clear()
-	if pair, ok := self.Follow_latest[channel]; ok && pair.Live.Duration > 0 {
-		self.Channel_videos.Push(pair.Live)
+	if broadcast_type != "" && broadcast_type != src.BroadcastArchive {
+	} else if pair, ok := self.Follow_latest[channel]; ok {
+		if pair.Live.Duration > 0 {
+			self.Channel_videos.Push(pair.Live)
+		}
+	} else if self.Channel_live.Channel == channel && self.Channel_live.Is_live {
+		self.Channel_videos.Push(self.Channel_live)
 	}
 	for _, vid := range self.Cache.As_slice() {
-		if vid.Channel == self.Channel {
+		if vid.Channel == self.Channel && (broadcast_type == "" || vid.Broadcast_type == broadcast_type) {
 			self.Channel_videos.Push(vid)
 		}
 	}
 	slices.SortFunc(self.Channel_videos.As_slice(), src.Sort_videos_by_latest)
+	self.Filter.Update(self.Channel_videos.As_slice())
+	self.Channel_selection = uint16(self.Filter.Settle(len(self.Channel_videos.As_slice()), int(self.Channel_selection)))
+	self.channel_fetch_tab()
+	self.history_fetch(channel)
+}
+
+// The follow refresh asks for every type at once, so a channel with many
+// past broadcasts would never show its older highlights and uploads. Each
+// tab fetches its own type the first time it is shown for a channel.
+func (self *UIState) channel_fetch_tab() {
+	tab := CHANNEL_TABS[self.Channel_tab]
+	key := self.Channel + " " + tab.Broadcast_type
+	if tab.Broadcast_type == "" || self.Channel_fetched[key] {
+		return
+	}
+	if self.Channel_fetched == nil {
+		self.Channel_fetched = make(map[string]bool)
+	}
+	self.Channel_fetched[key] = true
+	_, _ = self.Message.WriteString(fmt.Sprintf("Loading %s of %s\n", strings.ToLower(tab.Name), self.Channel))
+	Refresh_channel_type(self.Refresh_queue, self.Channel, tab.Broadcast_type)
+}
+
+func (self *UIState) channel_tab_step(delta int) {
+	count := len(CHANNEL_TABS)
+	self.Channel_tab = ((self.Channel_tab + delta) % count + count) % count
+	self.Channel_selection = 0
+	self.Channel_viewport.Offset = 0
+	self.channel_swap(self.Channel)
+}
+
+// Thumbnail and avatar to the right of the list, only if the terminal can
+// draw images and there is room
+const (
+	DETAIL_PANE_COLUMNS = 34
+	THUMBNAIL_COLUMNS = 32
+	THUMBNAIL_ROWS = 9
+	AVATAR_COLUMNS = 6
+	AVATAR_ROWS = 3
+)
+
+func (self UIState) has_detail_pane() bool {
+	rows := self.Height - 1 - CHANNEL_FOOTER_ROWS
+	return self.Images != nil && self.Images.Protocol != graphics.ProtocolNone &&
+		self.Width >= 100 && rows >= THUMBNAIL_ROWS + 1 + AVATAR_ROWS
+}
+
+func (self UIState) detail_pane_render(writer *bufio.Writer, vid src.Video) {
+	column := self.Width - DETAIL_PANE_COLUMNS + 2
+	if len(vid.Thumbnail_URL) > 0 {
+		fmt.Fprintf(writer, "\x1B[%d;%dH", LIST_TOP_ROW, column)
+		src.Must1(self.Images.Draw(writer, vid.Thumbnail_URL[0], THUMBNAIL_COLUMNS, THUMBNAIL_ROWS))
+	}
+	avatar_row := LIST_TOP_ROW + THUMBNAIL_ROWS + 1
+	fmt.Fprintf(writer, "\x1B[%d;%dH", avatar_row, column)
+	src.Must1(self.Images.Draw(writer, vid.Avatar_URL, AVATAR_COLUMNS, AVATAR_ROWS))
+	name := vid.Display_name
+	if name == "" {
+		name = vid.Channel
+	}
+	fmt.Fprintf(writer, "\x1B[%d;%dH%s", avatar_row + 1, column + AVATAR_COLUMNS + 1, name)
+
+	// As much as fits above the footer
+	row := avatar_row + AVATAR_ROWS + 1
+	bottom := self.Height - CHANNEL_FOOTER_ROWS
+	for _, line := range detail_lines(vid) {
+		if row > bottom {
+			break
+		}
+		fmt.Fprintf(writer, "\x1B[%d;%dH", row, column)
+		_ = print_line(writer, "", []int{DETAIL_PANE_COLUMNS - 2}, []string{line}, nil)
+		row += 1
+	}
+}
+
+var BROADCAST_NAMES = map[string]string{
+	src.BroadcastArchive: "Past broadcast",
+	src.BroadcastHighlight: "Highlight",
+	src.BroadcastUpload: "Upload",
+	src.BroadcastPremiere: "Past premiere",
+}
+
+// The details that do not fit in the list columns
+func detail_lines(vid src.Video) []string {
+	var ret []string
+	if vid.Game != "" {
+		ret = append(ret, vid.Game)
+	}
+	if vid.Is_live {
+		ret = append(ret, fmt.Sprintf("Live, %s viewers", format_count(vid.Viewers)))
+	} else if name, ok := BROADCAST_NAMES[vid.Broadcast_type]; ok {
+		ret = append(ret, name)
+	}
+	if vid.Language != "" {
+		ret = append(ret, "Language: " + vid.Language)
+	}
+	if len(vid.Tags) > 0 {
+		ret = append(ret, "Tags: " + strings.Join(vid.Tags, ", "))
+	}
+	if len(vid.Muted_segments) > 0 {
+		var total time.Duration
+		for _, x := range vid.Muted_segments {
+			total += x.Duration
+		}
+		ret = append(ret, fmt.Sprintf("Muted: %d parts, %s", len(vid.Muted_segments), total))
+		for _, x := range vid.Muted_segments {
+			ret = append(ret, fmt.Sprintf("  %s-%s", src.Format_clock(x.Offset), src.Format_clock(x.Offset + x.Duration)))
+		}
+	}
+	return ret
+}
+
+// Clearing the ring buffer leaves the old videos in place, so an empty tab
+// still has something at the selection
+func (self UIState) channel_selected() (src.Video, bool) {
+	if int(self.Channel_selection) >= len(self.Channel_videos.As_slice()) {
+		return src.Video{}, false
+	}
+	return self.Channel_videos.Buffer[self.Channel_selection], true
+}
+
+func (self *UIState) channel_play(offset string) {
+	vid, ok := self.channel_selected()
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if vid.Is_live || len(offset) == 0 {
+		_, _ = self.Message.WriteString(fmt.Sprintf("Playing %s\n", vid.Url))
+		go streamlink(ctx, self.Log_queue, src.Streamlink_args(vid, "")...)
+	} else {
+		_, _ = self.Message.WriteString(fmt.Sprintf("Playing %s at %s\n", vid.Url, offset))
+		go streamlink(ctx, self.Log_queue, src.Streamlink_args(vid, offset)...)
+	}
+	// @TODO: Track if video is currently playing, and close it if we reopen. Maybe this is undesired behaviour?
+	_ = cancel
+}
+
+// Renders the VOD's chat to subtitles and plays it in mpv. Fetching every
+// comment can take a while for long VODs, so it happens in the background.
+func (self *UIState) channel_play_with_chat(offset string) {
+	vid, ok := self.channel_selected()
+	if !ok {
+		return
+	}
+	if vid.Is_live {
+		_, _ = self.Message.WriteString("Chat subtitles are only available for VODs\n")
+		return
+	}
+	video_id, err := chat.Video_id(vid.Url)
+	if err != nil {
+		_, _ = self.Message.WriteString(err.Error() + "\n")
+		return
+	}
+
+	_, _ = self.Message.WriteString(fmt.Sprintf("Fetching chat of %s\n", vid.Url))
+	options, log := self.Chat_subtitles, self.Log_queue
+	go func() {
+		path, err := chat.Generate_subtitles(context.Background(), video_id, options, nil)
+		if err != nil {
+			log <- []byte(fmt.Sprintf("Could not fetch chat of %s: %s\n", vid.Url, err))
+			return
+		}
+		log <- []byte(fmt.Sprintf("Playing %s with chat\n", vid.Url))
+		_ = streamlink(context.Background(), log, src.Streamlink_args_with_subtitles(vid, offset, path)...)
+	}()
+}
+
+// The chapter under the (1-based) column on the chapters row, -1 if none
+func chapter_hit(chapters []src.Chapter, column int) int {
+	x := 1 + uniseg.StringWidth(CHAPTER_PREFIX)
+	for i, chapter := range chapters {
+		width := uniseg.StringWidth(chapter.Name)
+		if x <= column && column < x + width {
+			return i
+		}
+		x += width + uniseg.StringWidth(CHAPTER_GAP)
+	}
+	return -1
 }
 
 func (self *UIState) channel_input(event term.Event, cancel context.CancelFunc) bool {
 	self.Message.Reset()
-	switch event.Ty {
-	case term.TyCodepoint:
-		switch event.X {
-		case 'c':
-			if event.Mod_ctrl {
-				cancel()
-				return true
-			}
-		case 'q':
-			cancel()
-			return true
-
-		case 'r':
-			Refresh_channels(self.Refresh_queue, self.Channel)
-			
-		case 'h':
-			for i, vid := range self.Follow_videos {
-				if vid.Channel == self.Channel {
-					self.Follow_selection = uint16(i)
-					break
-				}
-			}
-			self.Screen = ScreenFollow
-
-		case 'j':
-			if int(self.Channel_selection) + 1 < len(self.Channel_videos.Buffer) {
-				self.Channel_command = self.Channel_command[:0] // Clear time selection
-				self.Channel_selection += 1
-			}
-		case 'k':
-			if self.Channel_selection > 0 {
-				self.Channel_command = self.Channel_command[:0] // Clear time selection
-				self.Channel_selection -= 1
-			}
-		case 'l':
-			if len(self.Channel_videos.Buffer) > 0 {
-				ctx, cancel := context.WithCancel(context.Background())
-				vid := self.Channel_videos.Buffer[self.Channel_selection]
-
-				if vid.Is_live || len(self.Channel_command) == 0 {
-					_, _ = self.Message.WriteString(fmt.Sprintf("Playing %s\n", vid.Url))
-					go streamlink(ctx, self.Log_queue, vid.Url)
-				} else {
-					_, _ = self.Message.WriteString(fmt.Sprintf("Playing %s at %s\n", vid.Url, self.Channel_command))
-					go streamlink(ctx, self.Log_queue, vid.Url, "--hls-start-offset", string(self.Channel_command))
-				}
-				// @TODO: Track if video is currently playing, and close it if we reopen. Maybe this is undesired behaviour?
-				_ = cancel
+	if self.filter_input(event, self.Channel_videos.As_slice(), &self.Channel_selection) {
+		self.Channel_command = self.Channel_command[:0]
+		return false
+	}
+	if event.Ty == term.TyMouse {
+		if event.Button == term.MouseBtn1 && int(event.Y) == self.Height - CHANNEL_FOOTER_ROWS + CHANNEL_CHAPTER_ROW {
+			vid, _ := self.channel_selected()
+			if i := chapter_hit(vid.Chapters, int(event.X)); i >= 0 && !vid.Is_live {
+				self.channel_play(src.Format_clock(vid.Chapters[i].Position))
 			}
-		case '0','1','2','3','4','5','6','7','8','9', ':':
-			vid := self.Channel_videos.Buffer[self.Channel_selection]
+			return false
+		}
+		prev := self.Channel_selection
+		if list_scroll(event, len(self.Channel_videos.As_slice()), self.Filter, &self.Channel_selection) {
+		} else if self.list_click(event, len(self.Channel_videos.As_slice()), self.Filter, self.Channel_viewport, &self.Channel_selection) {
+			self.channel_play(string(self.Channel_command))
+		}
+		if prev != self.Channel_selection {
+			self.Channel_command = self.Channel_command[:0] // Clear time selection
+		}
+		return false
+	}
 
-			if !vid.Is_live {
-				self.Channel_command = append(self.Channel_command, byte(event.X))
-			}
+	// Typing the start offset is not rebindable
+	if event.Ty == term.TyCodepoint && !event.Mod_ctrl && !event.Mod_alt && len(self.Key_pending) == 0 {
+		length := len(self.Channel_command)
+		vid, ok := self.channel_selected()
+		if ('0' <= event.X && event.X <= '9' || event.X == ':') && ok && !vid.Is_live {
+			self.Channel_command = append(self.Channel_command, byte(event.X))
+			return false
+		} else if event.X == 127 && length > 0 {
+			self.Channel_command = self.Channel_command[:length - 1]
+			return false
+		}
+	}
 
-		case 127:
-			length := len(self.Channel_command)
-			if length > 0 {
-				self.Channel_command = self.Channel_command[:length - 1]
+	prev := self.Channel_selection
+	action := self.resolve_key(event)
+	switch action {
+	case ActionRefresh:
+		Refresh_channel_type(self.Refresh_queue, self.Channel, CHANNEL_TABS[self.Channel_tab].Broadcast_type)
+	case ActionTab_next:
+		self.channel_tab_step(1)
+	case ActionTab_prev:
+		self.channel_tab_step(-1)
+	case ActionBack:
+		for i, vid := range self.Follow_videos {
+			if vid.Channel == self.Channel {
+				self.Follow_selection = uint16(i)
+				break
 			}
-
-		default:
+		}
+		self.Filter.Clear()
+		self.Screen = self.Channel_return
+	case ActionPlay:
+		self.channel_play(string(self.Channel_command))
+	case ActionPlay_with_chat:
+		self.channel_play_with_chat(string(self.Channel_command))
+	case ActionSearch:
+		self.search_open()
+	case ActionChat:
+		if vid, ok := self.channel_selected(); ok {
+			self.chat_open(vid)
+		}
+	case ActionQueue_add:
+		if vid, ok := self.channel_selected(); ok {
+			self.queue_add(vid, string(self.Channel_command))
+			self.Channel_command = self.Channel_command[:0]
 		}
 	default:
-		self.Message.WriteString(fmt.Sprintf("%d %+v\n", event.Ty, event))
-		self.Message.WriteString(self.Channel_videos.Buffer[self.Channel_selection].Url)
-		_ = self.Message.WriteByte('\n')
+		is_quit := self.list_action(action, cancel, len(self.Channel_videos.As_slice()), self.Channel_viewport, &self.Channel_selection)
+		if prev != self.Channel_selection {
+			self.Channel_command = self.Channel_command[:0] // Clear time selection
+		}
+		return is_quit
 	}
 	return false
 }
 
 func (self UIState) channel_render(writer *bufio.Writer) {
-	height_left := self.Height
-	fmt.Fprintf(writer, "Channel %s\n", self.Channel)
-	height_left -= 1
-
-	to_render := self.Channel_videos.As_slice()
-	if len(to_render) < height_left - 2 {
-		to_render = to_render[:len(to_render)]
+	videos := self.Channel_videos.As_slice()
+	rows := visible_rows(len(videos), self.Filter)
+	fmt.Fprintf(writer, "Channel %s", self.Channel)
+	for i, tab := range CHANNEL_TABS {
+		if i == self.Channel_tab {
+			fmt.Fprintf(writer, " %s%s%s", term.Start_highlight, tab.Name, term.End_highlight)
+		} else {
+			fmt.Fprintf(writer, " %s", tab.Name)
+		}
+	}
+	fmt.Fprintf(writer, " %s", self.Channel_viewport.Indicator(len(rows)))
+	// An empty tab gets an empty pane and footer
+	vid, ok := self.channel_selected()
+	if self.has_detail_pane() {
+		render_video_list(writer, self.Width - DETAIL_PANE_COLUMNS, self.Channel_selection, videos, self.Filter, self.Channel_viewport)
+		if ok {
+			self.detail_pane_render(writer, vid)
+		}
+	} else {
+		render_video_list(writer, self.Width, self.Channel_selection, videos, self.Filter, self.Channel_viewport)
 	}
-	render_video_list(writer, self.Channel_selection, to_render)
 
+	render_footer_start(writer, self.Height, CHANNEL_FOOTER_ROWS)
 	// Display play time
-	vid := self.Channel_videos.Buffer[self.Channel_selection]
 	// On live videos hide this selection, because you will be on live
 	if !vid.Is_live && len(self.Channel_command) > 0 {
-		fmt.Fprintf(writer, "\r\n Length (hh:mm:ss): %s\r\n", string(self.Channel_command))
+		fmt.Fprintf(writer, " Length (hh:mm:ss): %s", string(self.Channel_command))
 	}
+	fmt.Fprint(writer, "\r\n")
 
-	fmt.Fprintf(writer, "\r\n (q)uit (r)efresh (hjkl) navigate")
-	fmt.Fprintf(writer, "\r\n")
+	self.filter_render(writer)
+	fmt.Fprint(writer, "\r\n")
+	self.render_hints(writer, ActionQuit, ActionRefresh, ActionBack, ActionPlay, ActionFilter, ActionChat, ActionQueue_add, ActionTab_next, ActionQueue_screen, ActionHelp)
 	fmt.Fprintf(writer, "\r\n%s", vid.Url)
 	fmt.Fprintf(writer, "\r\n%s", vid.Title)
-	fmt.Fprintf(writer, "\r\nChapters: ")
+	fmt.Fprintf(writer, "\r\n%s", CHAPTER_PREFIX)
+	terms := strings.Fields(string(self.Filter.Query))
 	for i, chapter := range vid.Chapters {
 		if i != 0 {
-			fmt.Fprintf(writer, "%s", " | ")
+			fmt.Fprintf(writer, "%s", CHAPTER_GAP)
+		}
+		var marks []int
+		for _, t := range terms {
+			if pos, ok := Fuzzy_match(t, chapter.Name); ok {
+				marks = append(marks, pos...)
+			}
 		}
-		fmt.Fprintf(writer, "%s", chapter.Name)
+		_ = print_marked(writer, chapter.Name, marks)
+	}
+	fmt.Fprintf(writer, "\r\n%s\r\n", self.activity_hint(self.Channel, time.Now()))
+	render_message(writer, self.Message.String())
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Queue screen
+
+func (self *UIState) queue_add(vid src.Video, offset string) {
+	if vid.Url == "" {
+		return
+	}
+	if self.Queue.Add(vid, offset) {
+		_, _ = self.Message.WriteString(fmt.Sprintf("Queued %s\n", vid.Url))
+	} else {
+		_, _ = self.Message.WriteString(fmt.Sprintf("Updated offset of %s\n", vid.Url))
+	}
+	if err := self.Queue.Save(); err != nil {
+		_, _ = self.Message.WriteString(err.Error())
+		_ = self.Message.WriteByte('\n')
+	}
+}
+
+func (self *UIState) queue_play(idx int) {
+	if idx < 0 || idx >= len(self.Queue.Items) {
+		self.Queue_playing = ""
+		return
+	}
+	item := self.Queue.Items[idx]
+	self.Queue_playing = item.Video.Url
+	_, _ = self.Message.WriteString(fmt.Sprintf("Playing %s %s\n", item.Video.Url, item.Offset))
+
+	exit := self.Player_exit
+	log := self.Log_queue
+	go func() {
+		exit <- streamlink(context.Background(), log, src.Streamlink_args(item.Video, item.Offset)...)
+	}()
+}
+
+// A video that played through is removed from the queue and the next one
+// starts. If the player errored (or was killed), we stop so that we do not
+// skip through the queue.
+func (self *UIState) queue_advance(err error) {
+	playing := self.Queue_playing
+	self.Queue_playing = ""
+	if err != nil {
+		_, _ = self.Message.WriteString(fmt.Sprintf("Stopped queue: %s\n", err))
+		return
+	}
+
+	idx := slices.IndexFunc(self.Queue.Items, func(item src.QueueItem) bool {
+		return item.Video.Url == playing
+	})
+	if idx < 0 {
+		return
 	}
+	self.Queue.Remove(idx)
+	if err := self.Queue.Save(); err != nil {
+		_, _ = self.Message.WriteString(err.Error())
+		_ = self.Message.WriteByte('\n')
+	}
+	if int(self.Queue_selection) >= len(self.Queue.Items) && self.Queue_selection > 0 {
+		self.Queue_selection -= 1
+	}
+	self.queue_play(idx)
+}
+
+func (self *UIState) queue_input(event term.Event, cancel context.CancelFunc) bool {
+	self.Message.Reset()
+	length := len(self.Queue.Items)
+	if event.Ty == term.TyMouse {
+		if list_scroll(event, length, Filter{}, &self.Queue_selection) {
+		} else if self.list_click(event, length, Filter{}, self.Queue_viewport, &self.Queue_selection) && self.Queue_playing == "" {
+			self.queue_play(int(self.Queue_selection))
+		}
+		return false
+	}
+
+	action := self.resolve_key(event)
+	switch action {
+	case ActionBack:
+		self.Screen = ScreenFollow
+	case ActionQueue_move_down, ActionQueue_move_up:
+		delta := 1
+		if action == ActionQueue_move_up {
+			delta = -1
+		}
+		self.Queue_selection = uint16(self.Queue.Move(int(self.Queue_selection), delta))
+		if err := self.Queue.Save(); err != nil {
+			_, _ = self.Message.WriteString(err.Error())
+		}
+	case ActionQueue_remove:
+		if length > 0 {
+			self.Queue.Remove(int(self.Queue_selection))
+			if int(self.Queue_selection) + 1 >= length && self.Queue_selection > 0 {
+				self.Queue_selection -= 1
+			}
+			if err := self.Queue.Save(); err != nil {
+				_, _ = self.Message.WriteString(err.Error())
+			}
+		}
+	case ActionPlay:
+		if self.Queue_playing != "" {
+			_, _ = self.Message.WriteString("Already playing, close the player first\n")
+		} else {
+			self.queue_play(int(self.Queue_selection))
+		}
+	case ActionFilter:
+		// Nothing to filter
+	default:
+		return self.list_action(action, cancel, length, self.Queue_viewport, &self.Queue_selection)
+	}
+	return false
+}
+
+func (self UIState) queue_render(writer *bufio.Writer) {
+	fmt.Fprintf(writer, "Watch later %s", self.Queue_viewport.Indicator(len(self.Queue.Items)))
+
+	rows := visible_rows(len(self.Queue.Items), Filter{})
+	render_list(writer, LIST_TOP_ROW, self.Queue_selection, rows, self.Queue_viewport, func(idx int) {
+		item := self.Queue.Items[idx]
+		marker := " "
+		if item.Video.Url == self.Queue_playing {
+			marker = ">"
+		}
+		offset := item.Offset
+		if offset == "" {
+			offset = "-"
+		}
+		prefix := fmt.Sprintf("%s %-8s | ", marker, offset)
+		fmt.Fprint(writer, prefix)
+		print_formatted_line_marked(writer, " | ", item.Video, [2][]int{}, self.Width - len(prefix))
+	})
+
+	render_footer_start(writer, self.Height, QUEUE_FOOTER_ROWS)
+	self.render_hints(writer, ActionQuit, ActionBack, ActionQueue_move_down, ActionQueue_move_up, ActionQueue_remove, ActionPlay, ActionHelp)
 	fmt.Fprintf(writer, "\r\n")
 	render_message(writer, self.Message.String())
 }
@@ -0,0 +1,148 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src/term"
+)
+
+// The number of lines of the message area pinned to the bottom of the screen
+const MESSAGE_ROWS = 3
+
+// Lists always start below the header
+const LIST_TOP_ROW = 2
+
+const DOUBLE_CLICK_INTERVAL = 400 * time.Millisecond
+const SCROLL_WHEEL_ROWS = 3
+
+// Scroll state of a list that may be longer than the terminal
+// Offset and Rows are counted in visible (i.e. filtered) rows
+type Viewport struct {
+	Offset int
+	Rows   int
+}
+
+// Scrolls the least amount so that pos is on screen, like vim
+func (self *Viewport) Scroll_to(pos int, count int) {
+	if self.Rows <= 0 {
+		self.Offset = 0
+		return
+	}
+	if pos < self.Offset {
+		self.Offset = pos
+	} else if pos >= self.Offset + self.Rows {
+		self.Offset = pos - self.Rows + 1
+	}
+	// Do not leave empty rows at the bottom if we can fill them
+	if self.Offset + self.Rows > count {
+		self.Offset = count - self.Rows
+	}
+	if self.Offset < 0 {
+		self.Offset = 0
+	}
+}
+
+// e.g. "[21-40/57]"
+func (self Viewport) Indicator(count int) string {
+	if count == 0 {
+		return "[0/0]"
+	}
+	close := min(self.Offset + self.Rows, count)
+	return fmt.Sprintf("[%d-%d/%d]", self.Offset + 1, close, count)
+}
+
+// Positions of the rows that survive the filter, the viewport indexes into this
+func visible_rows(length int, filter Filter) []int {
+	ret := make([]int, 0, length)
+	for i := 0; i < length; i += 1 {
+		if filter.Includes(i) {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// Shared list movement, returns false if the action is not a movement
+func list_navigate(action Action, length int, filter Filter, viewport Viewport, selection *uint16) bool {
+	page := max(viewport.Rows, 1)
+	delta := 0
+	switch action {
+	case ActionSelect_next: delta = 1
+	case ActionSelect_prev: delta = -1
+	case ActionSelect_first: delta = -length
+	case ActionSelect_last: delta = length
+	case ActionPage_down: delta = page
+	case ActionPage_up: delta = -page
+	case ActionHalf_page_down: delta = max(page / 2, 1)
+	case ActionHalf_page_up: delta = -max(page / 2, 1)
+	default: return false
+	}
+	*selection = uint16(filter.Step(length, int(*selection), delta))
+	return true
+}
+
+// Moves the selection with the scroll wheel
+func list_scroll(event term.Event, length int, filter Filter, selection *uint16) bool {
+	delta := 0
+	switch event.Button {
+	case term.MouseScrollDown: delta = SCROLL_WHEEL_ROWS
+	case term.MouseScrollUp: delta = -SCROLL_WHEEL_ROWS
+	default: return false
+	}
+	*selection = uint16(filter.Step(length, int(*selection), delta))
+	return true
+}
+
+// The list index under a mouse event, -1 if it is not on a row
+func list_hit(event term.Event, rows []int, viewport Viewport) int {
+	row := int(event.Y) - LIST_TOP_ROW
+	if row < 0 || row >= viewport.Rows || viewport.Offset + row >= len(rows) {
+		return -1
+	}
+	return rows[viewport.Offset + row]
+}
+
+// Selects the row under a left click
+// Returns true if this click completes a double click on the same row
+func (self *UIState) list_click(event term.Event, length int, filter Filter, viewport Viewport, selection *uint16) bool {
+	if event.Ty != term.TyMouse || event.Button != term.MouseBtn1 {
+		return false
+	}
+	idx := list_hit(event, visible_rows(length, filter), viewport)
+	if idx < 0 {
+		return false
+	}
+	*selection = uint16(idx)
+
+	now := time.Now()
+	is_double := idx == self.Last_click_idx && now.Sub(self.Last_click) < DOUBLE_CLICK_INTERVAL
+	if is_double {
+		// A third click starts a new double click
+		self.Last_click = time.Time{}
+	} else {
+		self.Last_click = now
+	}
+	self.Last_click_idx = idx
+	return is_double
+}
+
+// Draws the rows of the list that fall in the viewport starting at top_row
+// Returns the number of rows drawn
+func render_list(writer *bufio.Writer, top_row int, selection uint16, rows []int, viewport Viewport, draw_row func(idx int)) int {
+	drawn := 0
+	for i := viewport.Offset; i < len(rows) && drawn < viewport.Rows; i += 1 {
+		idx := rows[i]
+		fmt.Fprintf(writer, "\x1B[%d;1H", top_row + drawn)
+		if idx == int(selection) {
+			fmt.Fprintf(writer, "\x1B[0;%s%s;%s%sm", term.Part_foreground, term.Part_white, term.Part_background, term.Part_black)
+		}
+		draw_row(idx)
+		if idx == int(selection) {
+			fmt.Fprint(writer, term.Reset_attributes)
+		}
+		drawn += 1
+	}
+	return drawn
+}
@@ -0,0 +1,240 @@
+package tui
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yueleshia/streamsurf/src"
+	"github.com/yueleshia/streamsurf/src/term"
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -run 'Scroll|Indicator|Navigate|Click|Chapter'
+
+func TestScrollTo(t *testing.T) {
+	cases := []struct {
+		name  string
+		from  Viewport
+		pos   int
+		count int
+		want  int // Offset afterwards
+	}{
+		{ "visible", Viewport{ 0, 5 }, 3, 20, 0 },
+		{ "below", Viewport{ 0, 5 }, 7, 20, 3 },
+		{ "far below", Viewport{ 2, 5 }, 19, 20, 15 },
+		{ "above", Viewport{ 10, 5 }, 4, 20, 4 },
+		{ "last row", Viewport{ 0, 5 }, 4, 20, 0 },
+		{ "first row", Viewport{ 6, 5 }, 6, 20, 6 },
+		{ "shorter than the viewport", Viewport{ 3, 5 }, 2, 3, 0 },
+		{ "list shrank", Viewport{ 15, 5 }, 16, 18, 13 },
+		{ "empty list", Viewport{ 4, 5 }, 0, 0, 0 },
+		{ "no rows", Viewport{ 4, 0 }, 10, 20, 0 },
+	}
+	for _, c := range cases {
+		viewport := c.from
+		viewport.Scroll_to(c.pos, c.count)
+		a.AssertEqual(t, c.want, viewport.Offset)
+	}
+}
+
+func TestIndicator(t *testing.T) {
+	a.AssertEqual(t, "[0/0]", Viewport{ 0, 5 }.Indicator(0))
+	a.AssertEqual(t, "[1-3/3]", Viewport{ 0, 5 }.Indicator(3))
+	a.AssertEqual(t, "[1-5/57]", Viewport{ 0, 5 }.Indicator(57))
+	a.AssertEqual(t, "[21-40/57]", Viewport{ 20, 20 }.Indicator(57))
+	a.AssertEqual(t, "[41-57/57]", Viewport{ 40, 20 }.Indicator(57))
+}
+
+func TestNavigate(t *testing.T) {
+	// Rows 1, 3, 4, 6, 7, 9 of 10 match
+	filtered := Filter{ Query: []rune("x"), Matches: map[int][2][]int{ 1: {}, 3: {}, 4: {}, 6: {}, 7: {}, 9: {} } }
+	cases := []struct {
+		name     string
+		action   Action
+		filter   Filter
+		rows     int
+		from     uint16
+		want     uint16
+	}{
+		{ "next", ActionSelect_next, Filter{}, 4, 2, 3 },
+		{ "next at the end", ActionSelect_next, Filter{}, 4, 9, 9 },
+		{ "prev at the start", ActionSelect_prev, Filter{}, 4, 0, 0 },
+		{ "page down", ActionPage_down, Filter{}, 4, 1, 5 },
+		{ "page down clamps", ActionPage_down, Filter{}, 4, 8, 9 },
+		{ "page up clamps", ActionPage_up, Filter{}, 4, 2, 0 },
+		{ "half page down", ActionHalf_page_down, Filter{}, 4, 1, 3 },
+		{ "half page of one row", ActionHalf_page_down, Filter{}, 1, 1, 2 },
+		{ "last", ActionSelect_last, Filter{}, 4, 1, 9 },
+
+		{ "filtered next", ActionSelect_next, filtered, 4, 4, 6 },
+		{ "filtered prev", ActionSelect_prev, filtered, 4, 3, 1 },
+		{ "filtered page down", ActionPage_down, filtered, 3, 1, 6 },
+		{ "filtered page down clamps", ActionPage_down, filtered, 4, 6, 9 },
+		{ "filtered page up", ActionPage_up, filtered, 3, 9, 4 },
+		{ "filtered half page down", ActionHalf_page_down, filtered, 4, 3, 6 },
+		{ "filtered half page up", ActionHalf_page_up, filtered, 4, 7, 4 },
+		{ "filtered first", ActionSelect_first, filtered, 4, 9, 1 },
+		{ "filtered last", ActionSelect_last, filtered, 4, 1, 9 },
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			selection := c.from
+			a.AssertEqual(t, true, list_navigate(c.action, 10, c.filter, Viewport{ 0, c.rows }, &selection))
+			a.AssertEqual(t, c.want, selection)
+		})
+	}
+
+	var selection uint16 = 2
+	a.AssertEqual(t, false, list_navigate(ActionQuit, 10, Filter{}, Viewport{ 0, 4 }, &selection))
+	a.AssertEqual(t, uint16(2), selection)
+}
+
+type click struct {
+	row   int           // 0 is the first row of the list
+	after time.Duration // Since the previous click
+	sel   uint16        // Selection after the click
+	is_double bool
+}
+
+func left_click(row int) term.Event {
+	return term.Event{ Ty: term.TyMouse, Button: term.MouseBtn1, X: 5, Y: uint32(LIST_TOP_ROW + row) }
+}
+
+func TestListClick(t *testing.T) {
+	filtered := Filter{ Query: []rune("x"), Matches: map[int][2][]int{ 1: {}, 3: {}, 4: {} } }
+	cases := []struct {
+		name     string
+		length   int
+		filter   Filter
+		viewport Viewport
+		clicks   []click
+	}{
+		{ "row", 5, Filter{}, Viewport{ 0, 5 }, []click{{ 2, time.Second, 2, false }} },
+		{ "header", 5, Filter{}, Viewport{ 0, 5 }, []click{{ -1, time.Second, 0, false }} },
+		{ "below the list", 3, Filter{}, Viewport{ 0, 5 }, []click{{ 4, time.Second, 0, false }} },
+		{ "below the viewport", 10, Filter{}, Viewport{ 0, 5 }, []click{{ 5, time.Second, 0, false }} },
+		{ "scrolled", 10, Filter{}, Viewport{ 3, 5 }, []click{{ 0, time.Second, 3, false }} },
+		{ "filtered", 5, filtered, Viewport{ 0, 5 }, []click{{ 1, time.Second, 3, false }, { 3, time.Second, 3, false }} },
+		{ "filtered and scrolled", 5, filtered, Viewport{ 1, 2 }, []click{{ 1, time.Second, 4, false }} },
+
+		{ "double", 5, Filter{}, Viewport{ 0, 5 }, []click{{ 2, time.Second, 2, false }, { 2, 100 * time.Millisecond, 2, true }} },
+		{ "too slow", 5, Filter{}, Viewport{ 0, 5 }, []click{{ 2, time.Second, 2, false }, { 2, DOUBLE_CLICK_INTERVAL, 2, false }} },
+		{ "other row", 5, Filter{}, Viewport{ 0, 5 }, []click{{ 2, time.Second, 2, false }, { 3, 100 * time.Millisecond, 3, false }} },
+		{ "triple", 5, Filter{}, Viewport{ 0, 5 }, []click{
+			{ 2, time.Second, 2, false },
+			{ 2, 100 * time.Millisecond, 2, true },
+			{ 2, 100 * time.Millisecond, 2, false },
+			{ 2, 100 * time.Millisecond, 2, true },
+		} },
+		{ "filtered double", 5, filtered, Viewport{ 0, 5 }, []click{{ 2, time.Second, 4, false }, { 2, 100 * time.Millisecond, 4, true }} },
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ui := &UIState{}
+			var selection uint16
+			for _, x := range c.clicks {
+				// Pretend the time has passed by moving the last click back
+				if !ui.Last_click.IsZero() {
+					ui.Last_click = ui.Last_click.Add(-x.after)
+				}
+				a.AssertEqual(t, x.is_double, ui.list_click(left_click(x.row), c.length, c.filter, c.viewport, &selection))
+				a.AssertEqual(t, x.sel, selection)
+			}
+		})
+	}
+
+	// Only left clicks select
+	ui := &UIState{}
+	var selection uint16
+	right := left_click(2)
+	right.Button = term.MouseBtn3
+	a.AssertEqual(t, false, ui.list_click(right, 5, Filter{}, Viewport{ 0, 5 }, &selection))
+	a.AssertEqual(t, uint16(0), selection)
+}
+
+func TestFollowDoubleClick(t *testing.T) {
+	ui := &UIState{}
+	ui.Load_config("tsoding\nj_blow")
+	ui.Width, ui.Height = 80, 30
+	ui.Channel_fetched = map[string]bool{ "tsoding history": true, "j_blow history": true }
+	ui.follow_swap()
+	ui.layout()
+
+	ui.follow_input(left_click(1), nil)
+	a.AssertEqual(t, ScreenFollow, ui.Screen)
+	a.AssertEqual(t, uint16(1), ui.Follow_selection)
+	ui.follow_input(left_click(1), nil)
+	a.AssertEqual(t, ScreenChannel, ui.Screen)
+	a.AssertEqual(t, ui.Follow_videos[1].Channel, ui.Channel)
+}
+
+func TestChapterHit(t *testing.T) {
+	chapters := []src.Chapter{{ Name: "Just Chatting" }, { Name: "Factorio" }, { Name: "日本" }}
+	start := 1 + len(CHAPTER_PREFIX)
+	cases := []struct {
+		name   string
+		column int
+		want   int
+	}{
+		{ "prefix", 1, -1 },
+		{ "first", start, 0 },
+		{ "end of first", start + len("Just Chatting") - 1, 0 },
+		{ "gap", start + len("Just Chatting"), -1 },
+		{ "second", start + len("Just Chatting" + CHAPTER_GAP), 1 },
+		{ "wide", start + len("Just Chatting" + CHAPTER_GAP + "Factorio" + CHAPTER_GAP) + 3, 2 },
+		{ "past the end", start + len("Just Chatting" + CHAPTER_GAP + "Factorio" + CHAPTER_GAP) + 4, -1 },
+	}
+	for _, c := range cases {
+		a.AssertEqual(t, c.want, chapter_hit(chapters, c.column))
+	}
+}
+
+// Plays out the cursor movements of a render, returns the screen rows
+func screen_rows(output string) map[int]string {
+	ret := make(map[int]string)
+	row := 1
+	position := regexp.MustCompile("^\x1B\\[(\\d+);(\\d+)H")
+	style := regexp.MustCompile("^\x1B\\[[0-9;]*[A-Za-z]")
+	for len(output) > 0 {
+		if m := position.FindStringSubmatch(output); m != nil {
+			row = src.Must(strconv.Atoi(m[1]))
+			output = output[len(m[0]):]
+		} else if m := style.FindString(output); m != "" {
+			output = output[len(m):]
+		} else if strings.HasPrefix(output, "\r\n") {
+			row += 1
+			output = output[2:]
+		} else {
+			ret[row] += output[:1]
+			output = output[1:]
+		}
+	}
+	return ret
+}
+
+// The click handler has to look for the chapters on the row they are drawn on
+func TestChapterRow(t *testing.T) {
+	ui := &UIState{}
+	ui.Load_config("tsoding")
+	ui.Width, ui.Height = 80, 30
+	ui.Channel_fetched = map[string]bool{ "tsoding history": true }
+	ui.Cache.Push(src.Video{ Channel: "tsoding", Url: "https://www.twitch.tv/videos/1", Start_time: time.Unix(100, 0),
+		Chapters: []src.Chapter{{ Name: "Just Chatting" }, { Name: "Factorio", Position: 10 * time.Minute }} })
+	ui.channel_swap("tsoding")
+	_, _ = ui.Message.WriteString("one\ntwo\nthree\n")
+	ui.layout()
+
+	var output strings.Builder
+	writer := bufio.NewWriter(&output)
+	ui.channel_render(writer)
+	src.Must1(writer.Flush())
+
+	row := ui.Height - CHANNEL_FOOTER_ROWS + CHANNEL_CHAPTER_ROW
+	line := screen_rows(output.String())[row]
+	a.AssertEqual(t, "Chapters: Just Chatting | Factorio", line)
+	a.AssertEqual(t, 1, chapter_hit(ui.Channel_videos.Buffer[ui.Channel_selection].Chapters, strings.Index(line, "Factorio") + 1))
+}
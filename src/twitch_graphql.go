@@ -19,6 +19,8 @@ import (
 var VODS_GRAPHQL_QUERY = strings.ReplaceAll(`query videos($channelOwnerLogin: String!, $limit: Int, $cursor: Cursor, $broadcastType: BroadcastType, $videoSort: VideoSort, $options: VideoConnectionOptionsInput) {
     user(login: $channelOwnerLogin) {
         id
+        displayName
+        profileImageURL(width: 50)
 
         videos(first: $limit, after: $cursor, type: $broadcastType, sort: $videoSort, options: $options) {
             edges {
@@ -30,6 +32,19 @@ var VODS_GRAPHQL_QUERY = strings.ReplaceAll(`query videos($channelOwnerLogin: St
                     previewThumbnailURL(width: 320, height: 180)
                     publishedAt
                     lengthSeconds
+                    broadcastType
+                    language
+                    contentTags {
+                        localizedName
+                    }
+                    muteInfo {
+                        mutedSegmentConnection {
+                            nodes {
+                                duration
+                                offset
+                            }
+                        }
+                    }
                     game {
                         name
                     }
@@ -58,23 +73,69 @@ var VODS_GRAPHQL_QUERY = strings.ReplaceAll(`query videos($channelOwnerLogin: St
         }
 
         stream {
+            id
             createdAt
+            viewersCount
+            freeformTags {
+                name
+            }
         }
         broadcastSettings {
             game {
                 name
             }
             title
+            language
         }
     }
 }`, "\n", "")
-func Graph_vods(channel string) (VideoPacket, Video) {
+// broadcast_type is one of the Broadcast* constants, or empty for all of them
+func Graph_vods(channel string, broadcast_type string) (VideoPacket, Video) {
+	cache_id := fmt.Sprintf("graph-%s-videos", channel)
+	if broadcast_type != "" {
+		cache_id += "-" + strings.ToLower(broadcast_type)
+	}
+	ret, live_vid, _, err := graph_vods_page(context.TODO(), channel, broadcast_type, "", cache_id)
+	if err != nil {
+		return VideoPacket{nil, false, err}, live_vid
+	}
+	return VideoPacket{ret, false, nil}, live_vid
+}
+
+// Up to pages * PAGE_SIZE past broadcasts, oldest first, for when the first
+// page is not enough history
+func Graph_vod_history(ctx context.Context, channel string, pages int) ([]Video, error) {
+	var ret []Video
+	cursor := ""
+	for page := 0; page < pages; page += 1 {
+		vods, _, next, err := graph_vods_page(ctx, channel, BroadcastArchive, cursor, fmt.Sprintf("graph-%s-history-%d", channel, page))
+		if err != nil {
+			return ret, err
+		}
+		ret = append(vods, ret...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	return ret, nil
+}
+
+// Empty cursor for the first page
+func graph_vods_page(ctx context.Context, channel string, broadcast_type string, cursor string, cache_id string) ([]Video, Video, string, error) {
 	// url format https://www.twitch.tv/qtcinderella/videos?filter=all&sort=time (query params may or may not be there)
+	type_variable, cursor_variable := `null`, `null`
+	if broadcast_type != "" {
+		type_variable = `"` + broadcast_type + `"`
+	}
+	if cursor != "" {
+		cursor_variable = string(Must(json.Marshal(cursor)))
+	}
 	variables := strings.Join([]string{
 		`{`,
-		`"broadcastType":null,`,
+		`"broadcastType":` + type_variable + `,`,
 		`"channelOwnerLogin":"` + channel  + `",`,
-		`"cursor":null,`,
+		`"cursor":` + cursor_variable + `,`,
 		`"limit":` + fmt.Sprintf("%d", PAGE_SIZE) + `,`,
 		`"videoSort":"TIME"`,
 		`}`,
@@ -89,166 +150,239 @@ func Graph_vods(channel string) (VideoPacket, Video) {
 	Assert(json.Valid([]byte(query)))
 
 
-	videos := [PAGE_SIZE]Video{}
 	var request io.ReadCloser
 	{
-		x, err := Request(context.TODO(), "POST", map[string]string{
+		x, err := Request(ctx, "POST", map[string]string{
 			//"Authorization": void 0,
 			"Accept": "*/*",
 			"Accept-Language": "en-US",
 			"Content-Type": "text/plain; charset=UTF-8",
 			"Client-Id": CLIENT_ID,
 			//"Device-ID": void 0,
-		}, strings.NewReader(query), "https://gql.twitch.tv/gql#origin=twilight", fmt.Sprintf("graph-%s-videos", channel))
+		}, strings.NewReader(query), "https://gql.twitch.tv/gql#origin=twilight", cache_id)
 		if err != nil {
-			return VideoPacket{videos[:0], false, err}, Video{}
+			return nil, Video{}, "", err
 		}
 		request = x
 	}
 
-	ret, live_vid, err := func() ([]Video, Video, error) {
-		live_video := Video {
-			Channel: channel,
-		}
-		type VideoNode struct {
-			Typename       string `json:"__typename"`
-			Id             string `json:"id"`
-			Title          string `json:"title"`
-			Thumbnail_URL  string `json:"previewThumbnailURL"`
-			Published_at   string `json:"publishedAt"`
-			Length_seconds int    `json:"lengthSeconds"`
-			Game struct {
-				Name string `json:"name"`
-			} `json:"game"`
-			Owner struct {
-				Id            string `json:"id"`
-				Display_name  string `json:"displayName"`
-				Login         string `json:"login"`
-				Profile_URL   string `json:"profileImageURL"`
-			} `json:"owner"`
-			Moments struct {
-				Edges []struct {
-					Node struct {
-						Description           string        `json:"description"`
-						Position_milliseconds time.Duration `json:"positionMilliseconds"`
-					} `json:"node"`
-				} `json:"edges"`
-				Page_info struct {
-					Has_next_page bool `json:"hasNextPage"`
-				} `json:"pageInfo"`
-			} `json:"moments"`
+	ret, live_vid, next, err := parse_vods_page(request, channel, time.Now())
+	if err != nil {
+		request.Close()
+		return nil, live_vid, "", err
+	}
+	return ret, live_vid, next, request.Close()
+}
 
-		}
-		type VideoEdge struct {
-			Cursor string    `json:"cursor"`
-			Node   VideoNode `json:"node"`
-		}
-		type Query struct {
-			Data struct {
-				User struct {
-					Id string `json:"id"`
-					Videos struct {
-						Edges []VideoEdge `json:"edges"`
-						Page_info struct {
-							Has_next_page bool `json:"hasNextPage"`
-						} `json:"pageInfo"`
-					} `json:"videos"`
-
-					// Related to live status
-					Stream *struct {
-						Created_at string `json:"createdAt"`
-					} `json:"stream"`
-					Broadcast_settings struct {
-						Game struct {
-							Name string `json:"name"`
-						} `json:"game"`
-						Title string `json:"title"`
-					} `json:"broadcastSettings"`
-				} `json:"user"`
-			} `json:"data"`
-			Extensions struct {
-				Duration_milliseconds int    `json:"durationMilliseconds"`
-				Operation_name        string `json:"operationName"`
-				Request_id            string `json:"requestID"`
-
-			} `json:"extensions"`
-		}
+// Returns the VODs oldest first, and the live stream, which is only Is_live
+// if the channel is live
+func parse_vods(input io.Reader, channel string, now time.Time) ([]Video, Video, error) {
+	vods, live, _, err := parse_vods_page(input, channel, now)
+	return vods, live, err
+}
 
-		var unmarshalled []Query
-		dec := json.NewDecoder(request)
-		dec.DisallowUnknownFields()
-		if err := dec.Decode(&unmarshalled); err != nil {
-			return videos[:0], Video{}, err
-		}
+// Also returns the cursor of the next page, empty on the last page
+func parse_vods_page(input io.Reader, channel string, now time.Time) ([]Video, Video, string, error) {
+	live_video := Video {
+		Channel: channel,
+	}
+	type VideoNode struct {
+		Typename       string `json:"__typename"`
+		Id             string `json:"id"`
+		Title          string `json:"title"`
+		Thumbnail_URL  string `json:"previewThumbnailURL"`
+		Published_at   string `json:"publishedAt"`
+		Length_seconds int    `json:"lengthSeconds"`
+		Broadcast_type string `json:"broadcastType"`
+		Language       string `json:"language"`
+		Content_tags []struct {
+			Localized_name string `json:"localizedName"`
+		} `json:"contentTags"`
+		Mute_info *struct {
+			Muted_segment_connection *struct {
+				Nodes []struct {
+					Duration int `json:"duration"`
+					Offset   int `json:"offset"`
+				} `json:"nodes"`
+			} `json:"mutedSegmentConnection"`
+		} `json:"muteInfo"`
+		Game struct {
+			Name string `json:"name"`
+		} `json:"game"`
+		Owner struct {
+			Id            string `json:"id"`
+			Display_name  string `json:"displayName"`
+			Login         string `json:"login"`
+			Profile_URL   string `json:"profileImageURL"`
+		} `json:"owner"`
+		Moments struct {
+			Edges []struct {
+				Node struct {
+					Description           string        `json:"description"`
+					Position_milliseconds time.Duration `json:"positionMilliseconds"`
+				} `json:"node"`
+			} `json:"edges"`
+			Page_info struct {
+				Has_next_page bool `json:"hasNextPage"`
+			} `json:"pageInfo"`
+		} `json:"moments"`
+
+	}
+	type VideoEdge struct {
+		Cursor string    `json:"cursor"`
+		Node   VideoNode `json:"node"`
+	}
+	type Query struct {
+		Data struct {
+			User struct {
+				Id string `json:"id"`
+				Display_name string `json:"displayName"`
+				Profile_URL string `json:"profileImageURL"`
+				Videos struct {
+					Edges []VideoEdge `json:"edges"`
+					Page_info struct {
+						Has_next_page bool `json:"hasNextPage"`
+					} `json:"pageInfo"`
+				} `json:"videos"`
+
+				// Related to live status
+				Stream *struct {
+					Id            string `json:"id"`
+					Created_at    string `json:"createdAt"`
+					Viewers_count int    `json:"viewersCount"`
+					Freeform_tags []struct {
+						Name string `json:"name"`
+					} `json:"freeformTags"`
+				} `json:"stream"`
+				Broadcast_settings struct {
+					Game struct {
+						Name string `json:"name"`
+					} `json:"game"`
+					Title    string `json:"title"`
+					Language string `json:"language"`
+				} `json:"broadcastSettings"`
+			} `json:"user"`
+		} `json:"data"`
+		Extensions struct {
+			Duration_milliseconds int    `json:"durationMilliseconds"`
+			Operation_name        string `json:"operationName"`
+			Request_id            string `json:"requestID"`
+
+		} `json:"extensions"`
+	}
+
+	var unmarshalled []Query
+	dec := json.NewDecoder(input)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&unmarshalled); err != nil {
+		return nil, Video{}, "", err
+	}
+	if len(unmarshalled) == 0 {
+		return nil, Video{}, "", fmt.Errorf("Empty response for the VODs of %s", channel)
+	}
+
+	video_edges := unmarshalled[0].Data.User.Videos.Edges
+	min_length := PAGE_SIZE
+	if len(video_edges) < min_length {
+		min_length = len(video_edges)
+	}
+	videos := make([]Video, 0, min_length)
+	for i := min_length - 1; i >= 0; i -= 1 {
+		x := video_edges[i].Node
 
-		video_edges := unmarshalled[0].Data.User.Videos.Edges
-		min_length := PAGE_SIZE
-		if len(video_edges) < min_length {
-			min_length = len(video_edges)
+		var start time.Time
+		if x, err := time.Parse(time.RFC3339, x.Published_at); err != nil {
+			return nil, Video{}, "", err
+		} else {
+			start = x
 		}
-		idx := 0
-		for i := min_length - 1; i >= 0; i -= 1 {
-			x := video_edges[i].Node
-
-			var start time.Time
-			if x, err := time.Parse(time.RFC3339, x.Published_at); err != nil {
-				return videos[:0], Video{}, err
-			} else {
-				start = x
-			}
 
-			var chapters []Chapter
-			if len(x.Moments.Edges) == 0 {
-				chapters = []Chapter{Chapter{x.Game.Name, 0}}
-			} else {
-				chapters = make([]Chapter, len(x.Moments.Edges))
-				for j, y := range x.Moments.Edges {
-					chapters[j] = Chapter {
-						Name:     y.Node.Description,
-						Position: y.Node.Position_milliseconds * time.Millisecond,
-					}
+		var chapters []Chapter
+		if len(x.Moments.Edges) == 0 {
+			chapters = []Chapter{Chapter{x.Game.Name, 0}}
+		} else {
+			chapters = make([]Chapter, len(x.Moments.Edges))
+			for j, y := range x.Moments.Edges {
+				chapters[j] = Chapter {
+					Name:     y.Node.Description,
+					Position: y.Node.Position_milliseconds * time.Millisecond,
 				}
 			}
+		}
 
-			videos[idx] = Video {
-				Title: x.Title,
-				Channel: channel,
-				Thumbnail_URL: []string{x.Thumbnail_URL},
-				Start_time: start,
-				Duration: time.Duration(x.Length_seconds) * time.Second,
-				Is_live: false,
-				Url: "https://www.twitch.tv/videos/" + x.Id,
-				Chapters: chapters,
+		var tags []string
+		for _, tag := range x.Content_tags {
+			tags = append(tags, tag.Localized_name)
+		}
+		var muted []MutedSegment
+		if x.Mute_info != nil && x.Mute_info.Muted_segment_connection != nil {
+			for _, y := range x.Mute_info.Muted_segment_connection.Nodes {
+				muted = append(muted, MutedSegment{
+					Offset: time.Duration(y.Offset) * time.Second,
+					Duration: time.Duration(y.Duration) * time.Second,
+				})
 			}
-			idx += 1
 		}
 
-		// Is live
-		if unmarshalled[0].Data.User.Stream != nil {
-			user := unmarshalled[0].Data.User
-			var start time.Time
-			if x, err := time.Parse(time.RFC3339, user.Stream.Created_at); err != nil {
-				return videos[:0], live_video, err
-			} else {
-				start = x
-			}
+		videos = append(videos, Video {
+			Title: x.Title,
+			Channel: channel,
+			Channel_id: x.Owner.Id,
+			Thumbnail_URL: []string{x.Thumbnail_URL},
+			Avatar_URL: x.Owner.Profile_URL,
+			Start_time: start,
+			Duration: time.Duration(x.Length_seconds) * time.Second,
+			Is_live: false,
+			Url: "https://www.twitch.tv/videos/" + x.Id,
+			Chapters: chapters,
+			Id: x.Id,
+			Broadcast_type: x.Broadcast_type,
+			Game: x.Game.Name,
+			Display_name: x.Owner.Display_name,
+			Tags: tags,
+			Language: strings.ToLower(x.Language),
+			Muted_segments: muted,
+		})
+	}
 
-			live_video = Video{
-				Title: user.Broadcast_settings.Title,
-				Channel: channel,
-				Thumbnail_URL: []string{},
-				Start_time: start,
-				Duration: time.Now().Sub(start),
-				Is_live: true,
-				Url: "https://www.twitch.tv/" + channel,
-				Chapters: []Chapter{Chapter{user.Broadcast_settings.Game.Name, 0}},
-			}
+	// Is live
+	if unmarshalled[0].Data.User.Stream != nil {
+		user := unmarshalled[0].Data.User
+		var start time.Time
+		if x, err := time.Parse(time.RFC3339, user.Stream.Created_at); err != nil {
+			return nil, live_video, "", err
+		} else {
+			start = x
 		}
 
-		return videos[:idx], live_video, nil
-	}()
-	if err != nil {
-		return VideoPacket{videos[:0], false, err}, live_vid
+		var tags []string
+		for _, tag := range user.Stream.Freeform_tags {
+			tags = append(tags, tag.Name)
+		}
+		live_video = Video{
+			Title: user.Broadcast_settings.Title,
+			Channel: channel,
+			Channel_id: user.Id,
+			// The preview of a live stream is always at the same URL
+			Thumbnail_URL: []string{"https://static-cdn.jtvnw.net/previews-ttv/live_user_" + channel + "-320x180.jpg"},
+			Avatar_URL: user.Profile_URL,
+			Start_time: start,
+			Duration: now.Sub(start),
+			Is_live: true,
+			Url: "https://www.twitch.tv/" + channel,
+			Chapters: []Chapter{Chapter{user.Broadcast_settings.Game.Name, 0}},
+			Viewers: user.Stream.Viewers_count,
+			Id: user.Stream.Id,
+			Game: user.Broadcast_settings.Game.Name,
+			Display_name: user.Display_name,
+			Tags: tags,
+			Language: strings.ToLower(user.Broadcast_settings.Language),
+		}
+	}
+
+	var next string
+	if min_length > 0 && unmarshalled[0].Data.User.Videos.Page_info.Has_next_page {
+		next = video_edges[min_length - 1].Cursor
 	}
-	return VideoPacket{ret, false, request.Close()}, live_vid
+	return videos, live_video, next, nil
 }
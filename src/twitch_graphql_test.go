@@ -1,7 +1,11 @@
 package src
 
 import (
+	"strings"
 	"testing"
+	"time"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
 )
 
 //run: go test -v
@@ -14,8 +18,67 @@ func TestAdd(t *testing.T) {
 		if result.Err != nil {
 			t.Logf("ERROR: %s", result.Err)
 		}
-		t.Logf("%+v", result.Val)
+		t.Logf("%+v", result.Vids)
 	}
 }
 
 
+func TestParseVods(t *testing.T) {
+	now := time.Date(2024, 5, 2, 20, 0, 0, 0, time.UTC)
+	vods, live, err := parse_vods(strings.NewReader(`[{"data":{"user":{"id":"1","displayName":"Tsoding","profileImageURL":"https://example.com/a.png",
+		"videos":{"edges":[
+			{"cursor":"2","node":{"__typename":"Video","id":"200","title":"Newer","previewThumbnailURL":"https://example.com/2.jpg","publishedAt":"2024-05-02T10:00:00Z","lengthSeconds":3600,
+				"broadcastType":"HIGHLIGHT","language":"EN","contentTags":[{"localizedName":"English"}],"muteInfo":{"mutedSegmentConnection":{"nodes":[{"duration":360,"offset":60}]}},
+				"game":{"name":"Factorio"},"owner":{"id":"1","displayName":"Tsoding","login":"tsoding","profileImageURL":"https://example.com/a.png"},
+				"moments":{"edges":[],"pageInfo":{"hasNextPage":false}}}},
+			{"cursor":"1","node":{"__typename":"Video","id":"100","title":"Older","previewThumbnailURL":"https://example.com/1.jpg","publishedAt":"2024-05-01T10:00:00Z","lengthSeconds":7200,
+				"broadcastType":"ARCHIVE","language":"EN","contentTags":[],"muteInfo":{"mutedSegmentConnection":null},
+				"game":{"name":"Software and Game Development"},"owner":{"id":"1","displayName":"Tsoding","login":"tsoding","profileImageURL":"https://example.com/a.png"},
+				"moments":{"edges":[{"node":{"description":"Just Chatting","positionMilliseconds":0}},{"node":{"description":"Factorio","positionMilliseconds":60000}}],"pageInfo":{"hasNextPage":false}}}}
+		],"pageInfo":{"hasNextPage":true}},
+		"stream":{"id":"300","createdAt":"2024-05-02T18:00:00Z","viewersCount":1234,"freeformTags":[{"name":"Programming"}]},
+		"broadcastSettings":{"game":{"name":"Software and Game Development"},"title":"Live now","language":"EN"}
+	}}}]`), "tsoding", now)
+	a.AssertEqual(t, nil, err)
+
+	// Oldest first
+	a.AssertEqual(t, 2, len(vods))
+	a.AssertEqual(t, "100", vods[0].Id)
+	a.AssertEqual(t, BroadcastArchive, vods[0].Broadcast_type)
+	a.AssertEqual(t, []Chapter{{ "Just Chatting", 0 }, { "Factorio", time.Minute }}, vods[0].Chapters)
+	a.AssertEqual(t, []MutedSegment(nil), vods[0].Muted_segments)
+	a.AssertEqual(t, Video{
+		Title: "Newer",
+		Channel: "tsoding",
+		Channel_id: "1",
+		Thumbnail_URL: []string{"https://example.com/2.jpg"},
+		Avatar_URL: "https://example.com/a.png",
+		Start_time: time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC),
+		Duration: time.Hour,
+		Url: "https://www.twitch.tv/videos/200",
+		Chapters: []Chapter{{ "Factorio", 0 }},
+		Id: "200",
+		Broadcast_type: BroadcastHighlight,
+		Game: "Factorio",
+		Display_name: "Tsoding",
+		Tags: []string{"English"},
+		Language: "en",
+		Muted_segments: []MutedSegment{{ time.Minute, 6 * time.Minute }},
+	}, vods[1])
+
+	a.AssertEqual(t, true, live.Is_live)
+	a.AssertEqual(t, "300", live.Id)
+	a.AssertEqual(t, 1234, live.Viewers)
+	a.AssertEqual(t, 2 * time.Hour, live.Duration)
+	a.AssertEqual(t, "Software and Game Development", live.Game)
+	a.AssertEqual(t, []string{"Programming"}, live.Tags)
+	a.AssertEqual(t, "Tsoding", live.Display_name)
+}
+
+func TestParseBroadcastType(t *testing.T) {
+	a.AssertEqual(t, BroadcastHighlight, Must(Parse_broadcast_type("Highlight")))
+	a.AssertEqual(t, BroadcastPremiere, Must(Parse_broadcast_type("premiere")))
+	a.AssertEqual(t, "", Must(Parse_broadcast_type("all")))
+	_, err := Parse_broadcast_type("clip")
+	a.AssertEqual(t, `Unknown broadcast type "clip", expected one of all, archive, highlight, upload or premiere`, err.Error())
+}
@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strings"
 	"time"
+	"unicode"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -34,6 +35,53 @@ func Run(input io.Reader, output io.Writer, name string, arg ...string) error {
 	return nil
 }
 
+// Other people's text with its control characters replaced, so that printing
+// it to a terminal cannot move the cursor or start an escape sequence
+func Printable(str string) string {
+	if !strings.ContainsFunc(str, unicode.IsControl) {
+		return str
+	}
+	return strings.Map(Printable_rune, str)
+}
+
+// Tabs and line breaks become spaces, other control characters U+FFFD
+func Printable_rune(r rune) rune {
+	switch {
+	case r == '\t' || r == '\n' || r == '\r': return ' '
+	case unicode.IsControl(r): return '\uFFFD'
+	default: return r
+	}
+}
+
+// Writes to a temporary file next to path first and renames it over path, so
+// that crashing halfway leaves the old file rather than half of the new one.
+// The file ends up with perm even if it already existed.
+func Write_file_atomic(path string, data []byte, perm os.FileMode) error {
+	tmp_path := filepath.Join(filepath.Dir(path), "." + filepath.Base(path) + ".tmp")
+	fh, err := os.OpenFile(tmp_path, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	// OpenFile does not change the mode of a leftover temporary file
+	if err := fh.Chmod(perm); err != nil {
+		fh.Close()
+		return err
+	}
+	if _, err := fh.Write(data); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp_path, path)
+}
+
+// e.g. 1:02:03, which is also what streamlink's --hls-start-offset takes
+func Format_clock(d time.Duration) string {
+	return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes()) % 60, int(d.Seconds()) % 60)
+}
+
 func Is_similar_time(a, b time.Time) bool {
 	delta := a.Sub(b)
 	return -5 * time.Minute < delta && delta < 5 * time.Minute
@@ -48,6 +96,16 @@ func local_shim(filename string) string {
 }
 
 var http_client = &http.Client{}
+
+// Returns the access token of the logged in user, set by main once logged in
+// See the auth package
+var Authorizer func(ctx context.Context) (string, error)
+
+var ErrLoggedOut = fmt.Errorf("Not logged in, run 'streamsurf login' first")
+
+// An "Authorization" header of just the scheme ("OAuth" for gql.twitch.tv and
+// id.twitch.tv, "Bearer" for the Helix API) is filled in with the token from
+// Authorizer, e.g. {"Authorization": "OAuth"} -> "OAuth <token>"
 func Request(ctx context.Context, method string, headers map[string]string, body io.Reader, target string, cache_id string) (io.ReadCloser, error) {
 	shim_path := local_shim(cache_id)
 	if (IS_LOCAL) {
@@ -65,11 +123,21 @@ func Request(ctx context.Context, method string, headers map[string]string, body
 			return fh, nil
 		}
 	}
-	req := Must(http.NewRequest(method, target, body))
+	req := Must(http.NewRequestWithContext(ctx, method, target, body))
 	req.Header.Set("User-Agent", USER_AGENT)
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
+	if scheme := headers["Authorization"]; scheme == "OAuth" || scheme == "Bearer" {
+		if Authorizer == nil {
+			return nil, ErrLoggedOut
+		}
+		token, err := Authorizer(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", scheme + " " + token)
+	}
 
 	L_DEBUG.Printf("%s %q", method, target)
 	resp, err := http_client.Do(req);
@@ -170,3 +238,21 @@ func Assert(should_true bool) {
 }
 
 
+
+////////////////////////////////////////////////////////////////////////////////
+// Persistent state
+
+// Files we persist between runs (e.g. the watch-later queue) live in
+// $XDG_CONFIG_HOME/streamsurf or the platform equivalent
+func Config_path(filename string) (string, error) {
+	var dir string
+	if x, err := os.UserConfigDir(); err != nil {
+		return "", err
+	} else {
+		dir = filepath.Join(x, "streamsurf")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filename), nil
+}
@@ -0,0 +1,80 @@
+package src
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	a "github.com/yueleshia/streamsurf/src/testify"
+)
+
+//run: go test -v -run Authoriz
+
+func TestRequestAuthorization(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+	defer func(x func(context.Context) (string, error)) { Authorizer = x }(Authorizer)
+
+	get := func(headers map[string]string) (string, error) {
+		body, err := Request(context.Background(), "GET", headers, nil, server.URL, "test-authorization")
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		return string(data), err
+	}
+
+	Authorizer = nil
+	_, err := get(map[string]string{ "Authorization": "OAuth" })
+	a.AssertEqual(t, ErrLoggedOut, err)
+	got, err := get(nil)
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "", got)
+
+	Authorizer = func(context.Context) (string, error) { return "secret", nil }
+	got, err = get(map[string]string{ "Authorization": "Bearer" })
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "Bearer secret", got)
+	got, err = get(map[string]string{ "Authorization": "OAuth" })
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "OAuth secret", got)
+	// Anonymous requests stay anonymous
+	got, err = get(map[string]string{ "Client-Id": CLIENT_ID })
+	a.AssertEqual(t, nil, err)
+	a.AssertEqual(t, "", got)
+}
+
+func TestPrintable(t *testing.T) {
+	a.AssertEqual(t, "plain ○ text", Printable("plain ○ text"))
+	a.AssertEqual(t, "a b c", Printable("a\tb\nc"))
+	a.AssertEqual(t, "\uFFFD[2J\uFFFD\uFFFD", Printable("\x1B[2J\x7F\u009b"))
+}
+
+func TestFormatClock(t *testing.T) {
+	a.AssertEqual(t, "0:00:00", Format_clock(0))
+	a.AssertEqual(t, "0:01:05", Format_clock(65 * time.Second))
+	a.AssertEqual(t, "26:03:04", Format_clock(26 * time.Hour + 3 * time.Minute + 4 * time.Second))
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	Must1(os.WriteFile(path, []byte("old"), 0o644))
+	Must1(Write_file_atomic(path, []byte("new"), 0o600))
+	a.AssertEqual(t, "new", string(Must(os.ReadFile(path))))
+	a.AssertEqual(t, os.FileMode(0o600), Must(os.Stat(path)).Mode().Perm())
+	entries := Must(os.ReadDir(filepath.Dir(path)))
+	a.AssertEqual(t, 1, len(entries)) // No temporary file left over
+}
+
+func TestCheckChannelLimit(t *testing.T) {
+	a.AssertEqual(t, nil, Check_channel_limit(RING_QUEUE_SIZE / PAGE_SIZE))
+	a.AssertEqual(t, true, Check_channel_limit(RING_QUEUE_SIZE / PAGE_SIZE + 1) != nil)
+}